@@ -0,0 +1,571 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brayanMuniz/h_save/config"
+	"github.com/brayanMuniz/h_save/db"
+	"github.com/brayanMuniz/h_save/n"
+	"github.com/brayanMuniz/h_save/routes"
+)
+
+// errUsage is returned when a subcommand got bad arguments, the flag set has
+// already said what was wrong.
+var errUsage = errors.New("usage")
+
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func(cfg *config.Config, database *sql.DB, args []string) error
+}
+
+// databaseAccess is how main opens the database for a command. By default
+// it is migrated with db.InitDB.
+type databaseAccess int
+
+const (
+	migratedDatabase databaseAccess = iota
+	// noDatabase isn't opened, the command gets nil and works on the file
+	noDatabase
+)
+
+// commandDatabase lists the commands that must work on a database InitDB
+// would refuse or change.
+var commandDatabase = map[string]databaseAccess{
+	"check":   noDatabase,
+	"restore": noDatabase,
+}
+
+var commands = []command{
+	{"serve", "", "start the web server, the default", serveCommand},
+	{"sync", "[-json]", "match pending doujinshi with the library folders", syncCommand},
+	{"scan", "[-json] [path]", "add the new images of the images folder, or of path inside it", scanCommand},
+	{"import", "[-json] [-user name] [-start-page n] [-max-pages n] [-save-metadata] [-skip-organized]",
+		"download the torrents of the nhentai favorites with the saved credentials", importCommand},
+	{"export", "[-json] <file>", "write a copy of the database to file", exportCommand},
+	{"passwd", "[-json] [username]", "set a new password, read twice from stdin", passwdCommand},
+	{"check", "[-json] [-repair]", "check the database file and look for orphaned rows", checkCommand},
+	{"backup", "[-json] [-list]", "add a backup to the backup folder and delete the oldest, or list them", backupCommand},
+	{"restore", "[-json] <backup name or file>", "replace the database with a backup, stop the server first", restoreCommand},
+	{"export-data", "[-json] [-user name] <file>", "write the progress, favorites, bookmarks and saved filters of a user to file",
+		exportDataCommand},
+	{"import-data", "[-json] [-user name] [-policy newest|max|overwrite] [-dry-run] <file>",
+		"merge a file written by export-data into the data of a user", importDataCommand},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "Usage: h_save [flags] [command] [command flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\n    \t%s\n", cmd.name, cmd.args, cmd.usage)
+	}
+	fmt.Fprintln(w, "  help\n    \tprint this list")
+}
+
+// commandFlags parses the flags of a subcommand, every one of them taking
+// -json. It returns errUsage when they are wrong.
+func commandFlags(name string, args []string, define func(fs *flag.FlagSet)) (*flag.FlagSet, bool, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	jsonOutput := fs.Bool("json", false, "print the result as JSON")
+	if define != nil {
+		define(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, errUsage
+	}
+	return fs, *jsonOutput, nil
+}
+
+// output prints v as JSON, or else calls human to describe it.
+func output(jsonOutput bool, v interface{}, human func()) error {
+	if !jsonOutput {
+		human()
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func serveCommand(cfg *config.Config, database *sql.DB, args []string) error {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "serve takes no arguments, got %q\n", args)
+		return errUsage
+	}
+
+	secret, err := serverSecret(cfg.SecretFile)
+	if err != nil {
+		return err
+	}
+	credentialsKey, err := db.DeriveCredentialsKey(secret)
+	if err != nil {
+		return err
+	}
+
+	if cfg.BackupInterval > 0 {
+		go db.ScheduleBackups(database, cfg.BackupDir, cfg.BackupInterval, cfg.BackupKeep)
+	}
+
+	r := routes.SetupRouter(database, cfg, credentialsKey)
+	if err := r.Run(cfg.Listen); err != nil {
+		return fmt.Errorf("failed to start server: %w", err)
+	}
+	return nil
+}
+
+func syncCommand(cfg *config.Config, database *sql.DB, args []string) error {
+	fs, jsonOutput, err := commandFlags("sync", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "sync takes no arguments")
+		return errUsage
+	}
+
+	result, err := routes.SyncLibrary(database, cfg.Roots())
+	if err != nil {
+		return err
+	}
+	return output(jsonOutput, result, func() {
+		fmt.Printf("Synced %d doujinshi\n", len(result.Synced))
+		for _, s := range result.Synced {
+			fmt.Printf("  %d %s -> %s/%s\n", s.ID, s.Title, s.LibraryRoot, s.FolderName)
+		}
+		fmt.Printf("%d still pending\n", len(result.StillPending))
+		for _, p := range result.StillPending {
+			fmt.Printf("  %d %s (%s %s)\n", p.ID, p.Title, p.Source, p.ExternalID)
+		}
+		for _, root := range cfg.Roots().Doujinshi {
+			if folders := result.FoldersByRoot[root.Name]; len(folders) > 0 {
+				fmt.Printf("Unused folders in %s: %s\n", root.Name, strings.Join(folders, ", "))
+			}
+		}
+		if len(result.UnavailableRoots) > 0 {
+			fmt.Printf("Couldn't read the roots %s\n", strings.Join(result.UnavailableRoots, ", "))
+		}
+	})
+}
+
+func scanCommand(cfg *config.Config, database *sql.DB, args []string) error {
+	fs, jsonOutput, err := commandFlags("scan", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "scan takes at most one path")
+		return errUsage
+	}
+
+	root := cfg.Roots().Images
+	dir, err := root.Resolve(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("images folder %q: %w", fs.Arg(0), err)
+	}
+	result, err := db.ScanImagesFolder(database, root, dir)
+	if err != nil {
+		return err
+	}
+	return output(jsonOutput, result, func() {
+		fmt.Printf("Scanned %d images, %d new, %d duplicates\n", result.TotalScanned, result.NewImages, result.Duplicates)
+		for _, e := range result.Errors {
+			fmt.Println("  " + e)
+		}
+	})
+}
+
+// importCommand downloads the favorites of a user with their saved nhentai
+// credentials, it fails when they are missing or expired.
+func importCommand(cfg *config.Config, database *sql.DB, args []string) error {
+	var username *string
+	var startPage, maxPages *int
+	var saveMetadata, skipOrganized *bool
+	fs, jsonOutput, err := commandFlags("import", args, func(fs *flag.FlagSet) {
+		username = fs.String("user", "", "user whose credentials are used, needed when there are several users")
+		startPage = fs.Int("start-page", 1, "first page of favorites")
+		maxPages = fs.Int("max-pages", 20, "most pages downloaded")
+		saveMetadata = fs.Bool("save-metadata", false, "save the metadata of the favorites that aren't in the library")
+		skipOrganized = fs.Bool("skip-organized", false, "skip the favorites already in the library")
+	})
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 || *startPage <= 0 || *maxPages <= 0 {
+		fmt.Fprintln(os.Stderr, "import takes no arguments, and the pages must be positive")
+		return errUsage
+	}
+
+	user, err := pickUser(database, *username)
+	if err != nil {
+		return err
+	}
+	secret, err := serverSecret(cfg.SecretFile)
+	if err != nil {
+		return err
+	}
+	key, err := db.DeriveCredentialsKey(secret)
+	if err != nil {
+		return err
+	}
+	creds, err := db.GetProviderCredentials(database, key, user.ID, routes.NhentaiProvider)
+	if err != nil {
+		return fmt.Errorf("nhentai credentials of %s: %w", user.Username, err)
+	}
+	httpConfig := n.HTTPConfig{SessionId: creds.SessionId, CsrfToken: creds.CsrfToken}
+
+	accountName, err := routes.CheckNhentaiSession(httpConfig)
+	if errors.Is(err, n.ErrSessionExpired) {
+		db.SetCredentialsStatus(database, user.ID, routes.NhentaiProvider, db.CredentialsExpired, "")
+		return fmt.Errorf("nhentai credentials of %s: %w", user.Username, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reach nhentai: %w", err)
+	}
+	if err := db.SetCredentialsStatus(database, user.ID, routes.NhentaiProvider, db.CredentialsValid, accountName); err != nil {
+		return err
+	}
+
+	result := routes.DownloadAllFavorites(httpConfig, database, cfg.TorrentDir,
+		*saveMetadata, *skipOrganized, *startPage, *maxPages)
+	if result.SessionExpired {
+		db.SetCredentialsStatus(database, user.ID, routes.NhentaiProvider, db.CredentialsExpired, "")
+	}
+	err = output(jsonOutput, result, func() {
+		fmt.Printf("Processed %d favorites on %d pages: %d downloaded, %d skipped, %d failed, %d metadata saved\n",
+			result.TotalProcessed, result.PagesProcessed, len(result.Downloaded), len(result.Skipped),
+			len(result.Failed), len(result.MetadataSaved))
+		for _, title := range result.Failed {
+			fmt.Println("  failed: " + title)
+		}
+	})
+	if err == nil && result.SessionExpired {
+		err = fmt.Errorf("nhentai credentials of %s: %w", user.Username, n.ErrSessionExpired)
+	}
+	return err
+}
+
+func exportCommand(cfg *config.Config, database *sql.DB, args []string) error {
+	fs, jsonOutput, err := commandFlags("export", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "export takes the file to write")
+		return errUsage
+	}
+
+	path := fs.Arg(0)
+	if err := db.BackupTo(database, path); err != nil {
+		return fmt.Errorf("export to %s: %w", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	result := struct {
+		File string `json:"file"`
+		Size int64  `json:"size"`
+	}{path, info.Size()}
+	return output(jsonOutput, result, func() {
+		fmt.Printf("Exported the database to %s (%d bytes)\n", result.File, result.Size)
+	})
+}
+
+func passwdCommand(cfg *config.Config, database *sql.DB, args []string) error {
+	fs, jsonOutput, err := commandFlags("passwd", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "passwd takes at most one username")
+		return errUsage
+	}
+
+	user, err := resetPassword(database, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	result := struct {
+		Username string `json:"username"`
+	}{user.Username}
+	return output(jsonOutput, result, func() {
+		fmt.Printf("Password of %s changed, all their sessions were logged out.\n", user.Username)
+	})
+}
+
+// checkCommand fails when the database has problems, unless -repair fixed
+// them all. The database isn't migrated, and is opened read-only unless
+// repairing.
+func checkCommand(cfg *config.Config, _ *sql.DB, args []string) error {
+	var repair *bool
+	fs, jsonOutput, err := commandFlags("check", args, func(fs *flag.FlagSet) {
+		repair = fs.Bool("repair", false, "delete the orphaned rows")
+	})
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "check takes no arguments")
+		return errUsage
+	}
+
+	database, err := db.OpenUnmigrated(cfg.DBPath, !*repair)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", cfg.DBPath, err)
+	}
+	defer database.Close()
+
+	report, checkErr := db.CheckIntegrity(database, *repair)
+	if checkErr != nil && !errors.Is(checkErr, db.ErrCorrupt) {
+		return checkErr
+	}
+	err = output(jsonOutput, report, func() {
+		if report.OK {
+			fmt.Println("No problems found")
+			return
+		}
+		for _, problem := range report.Corruption {
+			fmt.Println("Damaged: " + problem)
+		}
+		for _, o := range report.Orphans {
+			fmt.Printf("%d rows of %s point at missing %s\n", o.Count, o.Table, o.Parent)
+		}
+		if report.Repaired > 0 {
+			fmt.Printf("Deleted %d orphaned rows\n", report.Repaired)
+		}
+	})
+	switch {
+	case err != nil:
+		return err
+	case checkErr != nil:
+		return checkErr
+	case len(report.Corruption) > 0:
+		return errors.New("the database file is damaged")
+	case len(report.Orphans) > 0 && !*repair:
+		return errors.New("found orphaned rows, run check -repair to delete them")
+	}
+	return nil
+}
+
+func backupCommand(cfg *config.Config, database *sql.DB, args []string) error {
+	var list *bool
+	fs, jsonOutput, err := commandFlags("backup", args, func(fs *flag.FlagSet) {
+		list = fs.Bool("list", false, "list the backups instead")
+	})
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "backup takes no arguments")
+		return errUsage
+	}
+
+	if *list {
+		backups, err := db.ListBackups(cfg.BackupDir)
+		if err != nil {
+			return err
+		}
+		return output(jsonOutput, backups, func() {
+			for _, b := range backups {
+				fmt.Printf("%s  %s  %d bytes\n", b.Name, b.CreatedAt.Local().Format("2006-01-02 15:04:05"), b.Size)
+			}
+		})
+	}
+
+	backup, err := db.CreateBackup(database, cfg.BackupDir)
+	if err != nil {
+		return err
+	}
+	deleted, err := db.PruneBackups(cfg.BackupDir, cfg.BackupKeep)
+	if err != nil {
+		return fmt.Errorf("backed up to %s, but deleting the old backups failed: %w", backup.Name, err)
+	}
+	result := struct {
+		Backup  db.Backup `json:"backup"`
+		Deleted []string  `json:"deleted"`
+	}{backup, deleted}
+	if result.Deleted == nil {
+		result.Deleted = []string{}
+	}
+	return output(jsonOutput, result, func() {
+		fmt.Printf("Backed up to %s\n", filepath.Join(cfg.BackupDir, backup.Name))
+		for _, name := range deleted {
+			fmt.Println("Deleted " + name)
+		}
+	})
+}
+
+// restoreCommand takes the name of a backup in the backup folder or the path
+// of any file. It doesn't open the database, which may be damaged or too new
+// to migrate.
+func restoreCommand(cfg *config.Config, _ *sql.DB, args []string) error {
+	fs, jsonOutput, err := commandFlags("restore", args, nil)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "restore takes the backup to restore")
+		return errUsage
+	}
+
+	path, err := db.BackupPath(cfg.BackupDir, fs.Arg(0))
+	if err != nil {
+		path = fs.Arg(0)
+	}
+	previous, err := db.RestoreBackupFile(cfg.DBPath, path, cfg.BackupDir)
+	if err != nil {
+		return err
+	}
+	result := struct {
+		Restored string     `json:"restored"`
+		Previous *db.Backup `json:"previous"`
+	}{path, nil}
+	if previous.Name != "" {
+		result.Previous = &previous
+	}
+	return output(jsonOutput, result, func() {
+		if result.Previous == nil {
+			fmt.Printf("Restored %s, there was no database to back up\n", path)
+			return
+		}
+		fmt.Printf("Restored %s, the replaced database is backed up as %s\n", path, previous.Name)
+	})
+}
+
+// exportDataCommand never overwrites a file.
+func exportDataCommand(cfg *config.Config, database *sql.DB, args []string) error {
+	var username *string
+	fs, jsonOutput, err := commandFlags("export-data", args, func(fs *flag.FlagSet) {
+		username = fs.String("user", "", "user whose data is exported, needed when there are several users")
+	})
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "export-data takes the file to write")
+		return errUsage
+	}
+
+	user, err := pickUser(database, *username)
+	if err != nil {
+		return err
+	}
+	data, err := db.ExportUserData(database, user.ID)
+	if err != nil {
+		return err
+	}
+
+	path := fs.Arg(0)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(data); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	result := struct {
+		File         string `json:"file"`
+		Username     string `json:"username"`
+		Doujinshi    int    `json:"doujinshi"`
+		Images       int    `json:"images"`
+		SavedFilters int    `json:"savedFilters"`
+	}{path, data.Username, len(data.Doujinshi), len(data.Images), len(data.SavedFilters)}
+	return output(jsonOutput, result, func() {
+		fmt.Printf("Exported the data of %s on %d doujinshi, %d images and %d saved filters to %s\n",
+			result.Username, result.Doujinshi, result.Images, result.SavedFilters, result.File)
+	})
+}
+
+func importDataCommand(cfg *config.Config, database *sql.DB, args []string) error {
+	var username, policy *string
+	var dryRun *bool
+	fs, jsonOutput, err := commandFlags("import-data", args, func(fs *flag.FlagSet) {
+		username = fs.String("user", "", "user the data is merged into, needed when there are several users")
+		policy = fs.String("policy", db.ConflictNewest, "value kept when both have one: newest, max or overwrite")
+		dryRun = fs.Bool("dry-run", false, "only report what would change")
+	})
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || !db.ValidConflictPolicy(*policy) {
+		fmt.Fprintln(os.Stderr, "import-data takes the file to import, and the policy must be newest, max or overwrite")
+		return errUsage
+	}
+
+	user, err := pickUser(database, *username)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var data db.UserData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return fmt.Errorf("%w: %v", db.ErrInvalidUserData, err)
+	}
+
+	report, err := db.ImportUserData(database, user.ID, data, *policy, *dryRun)
+	if err != nil {
+		return err
+	}
+	return output(jsonOutput, report, func() {
+		if report.DryRun {
+			fmt.Printf("Dry run, nothing was changed. Importing into %s with the %s policy would give:\n", user.Username, report.Policy)
+		} else {
+			fmt.Printf("Imported into %s with the %s policy:\n", user.Username, report.Policy)
+		}
+		for _, c := range []struct {
+			name   string
+			counts db.ImportCounts
+		}{
+			{"progress", report.Progress}, {"page o-counts", report.PageOCounts}, {"bookmarks", report.Bookmarks},
+			{"image progress", report.ImageProgress}, {"favorite images", report.FavoriteImages},
+			{"favorites", report.Favorites}, {"saved filters", report.SavedFilters},
+		} {
+			fmt.Printf("  %-16s %d added, %d updated, %d kept, %d skipped\n",
+				c.name, c.counts.Added, c.counts.Updated, c.counts.Kept, c.counts.Skipped)
+		}
+		for _, d := range report.MissingDoujinshi {
+			fmt.Printf("Missing doujinshi %s %s %s\n", d.Source, d.ExternalID, d.Title)
+		}
+		for _, img := range report.MissingImages {
+			fmt.Printf("Missing image %s %s\n", img.Hash, img.Filename)
+		}
+		kinds := make([]string, 0, len(report.MissingEntities))
+		for kind := range report.MissingEntities {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Printf("Missing %s: %s\n", kind, strings.Join(report.MissingEntities[kind], ", "))
+		}
+	})
+}
@@ -0,0 +1,346 @@
+// Package config gathers the server settings. Every setting has a default
+// and can be set in a config file, by an environment variable and by a flag,
+// each overriding the one before. The file uses the .env format with the
+// same names as the environment variables, e.g. H_SAVE_DB=/var/lib/h_save.db.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brayanMuniz/h_save/db"
+	"github.com/brayanMuniz/h_save/library"
+	"github.com/joho/godotenv"
+)
+
+// DefaultFile is read when no config file is given and it exists in the
+// working directory.
+const DefaultFile = "h_save.env"
+
+type Config struct {
+	// File is the config file that was read, empty when there was none
+	File string
+
+	DBPath string
+	Listen string
+	// DoujinshiDir is the default doujinshi root, DoujinshiRoots the others
+	DoujinshiDir   string
+	DoujinshiRoots []NamedDir
+	ImagesDir      string
+	TorrentDir     string
+	// SecretFile holds the server secret when H_SAVE_SECRET isn't set
+	SecretFile string
+
+	// BackupDir keeps the backups, BackupInterval is how often one is made
+	// while the server runs, 0 for never, and BackupKeep how many are kept
+	BackupDir      string
+	BackupInterval time.Duration
+	BackupKeep     int
+
+	LoginLimits db.LoginLimits
+	// TrustedProxies are the IPs and CIDR ranges whose X-Forwarded-For
+	// header is believed, nil when the server isn't behind a proxy
+	TrustedProxies []string
+}
+
+type NamedDir struct {
+	Name string
+	Dir  string
+}
+
+type settingKind int
+
+const (
+	plainSetting settingKind = iota
+	// paths given in the config file are relative to its folder
+	pathSetting
+	// a comma separated list of name=path
+	namedPathsSetting
+)
+
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	kind  settingKind
+	def   string
+}
+
+var settings = []setting{
+	{"H_SAVE_DB", "db", "SQLite database file", pathSetting, "h_save.db"},
+	{"H_SAVE_LISTEN", "listen", "address to listen on", plainSetting, ":8080"},
+	{"H_SAVE_DOUJINSHI_DIR", "doujinshi-dir", "folder of the synced doujinshi, the root named " + library.DefaultDoujinshiRoot,
+		pathSetting, "doujinshi"},
+	{"H_SAVE_DOUJINSHI_ROOTS", "doujinshi-roots", "more doujinshi folders as name=folder,name=folder", namedPathsSetting, ""},
+	{"H_SAVE_IMAGES_DIR", "images-dir", "folder of the images", pathSetting, "images"},
+	{"H_SAVE_TORRENT_DIR", "torrent-dir", "folder downloaded .torrent files are saved in", pathSetting, "download_me_senpai"},
+	{"H_SAVE_SECRET_FILE", "secret-file", "file holding the server secret, created if missing", pathSetting, "h_save.secret"},
+	{"H_SAVE_BACKUP_DIR", "backup-dir", "folder the database backups are kept in", pathSetting, "backups"},
+	{"H_SAVE_BACKUP_INTERVAL", "backup-interval", "how often the server backs up the database, 0 to never", plainSetting, "24h"},
+	{"H_SAVE_BACKUP_KEEP", "backup-keep", "how many backups are kept, the oldest are deleted", plainSetting, "7"},
+	{"H_SAVE_LOGIN_IP_THRESHOLD", "login-ip-threshold", "failed logins in a row before an IP is locked out",
+		plainSetting, strconv.Itoa(db.DefaultLoginLimits.IPThreshold)},
+	{"H_SAVE_LOGIN_GLOBAL_THRESHOLD", "login-global-threshold", "failed logins in a row from all IPs before every login is locked out",
+		plainSetting, strconv.Itoa(db.DefaultLoginLimits.GlobalThreshold)},
+	{"H_SAVE_LOGIN_BASE_LOCKOUT", "login-base-lockout", "first lockout, doubled with every further failure",
+		plainSetting, db.DefaultLoginLimits.BaseLockout.String()},
+	{"H_SAVE_LOGIN_MAX_LOCKOUT", "login-max-lockout", "longest lockout",
+		plainSetting, db.DefaultLoginLimits.MaxLockout.String()},
+	{"H_SAVE_LOGIN_WINDOW", "login-window", "how long failed logins count",
+		plainSetting, db.DefaultLoginLimits.Window.String()},
+	{"H_SAVE_TRUSTED_PROXIES", "trusted-proxies", "IPs or CIDR ranges of reverse proxies whose X-Forwarded-For is used, comma separated",
+		plainSetting, ""},
+}
+
+// Load reads the settings from the config file, the environment and the
+// flags in args, and returns the arguments left after the flags. The config
+// file is given with -config or H_SAVE_CONFIG.
+func Load(args []string, getenv func(string) string) (*Config, []string, error) {
+	fs := flag.NewFlagSet("h_save", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", "", "config file in the .env format (env H_SAVE_CONFIG, default "+DefaultFile+" if it exists)")
+	for _, s := range settings {
+		fs.String(s.flag, "", fmt.Sprintf("%s (env %s, default %q)", s.usage, s.env, s.def))
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(os.Stderr)
+			fs.PrintDefaults()
+		}
+		return nil, nil, err
+	}
+
+	values := make(map[string]string, len(settings))
+	for _, s := range settings {
+		values[s.env] = s.def
+	}
+
+	cfg := &Config{File: *configFile}
+	if cfg.File == "" {
+		cfg.File = getenv("H_SAVE_CONFIG")
+	}
+	if cfg.File == "" {
+		if _, err := os.Stat(DefaultFile); err == nil {
+			cfg.File = DefaultFile
+		}
+	}
+	if cfg.File != "" {
+		fileValues, err := godotenv.Read(cfg.File)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading config file: %w", err)
+		}
+		for _, s := range settings {
+			v, ok := fileValues[s.env]
+			if !ok || v == "" {
+				continue
+			}
+			switch s.kind {
+			case pathSetting:
+				v = relativeTo(filepath.Dir(cfg.File), v)
+			case namedPathsSetting:
+				v = namedPathsRelativeTo(filepath.Dir(cfg.File), v)
+			}
+			values[s.env] = v
+		}
+	}
+
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			values[s.env] = v
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				values[s.env] = f.Value.String()
+			}
+		}
+	})
+
+	if err := cfg.set(values); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// set fills in the config from the merged values and validates it, all the
+// problems are reported at once.
+func (cfg *Config) set(values map[string]string) error {
+	var errs []error
+	intValue := func(key string) int {
+		v, err := strconv.Atoi(strings.TrimSpace(values[key]))
+		if err != nil || v <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be a positive number, got %q", key, values[key]))
+		}
+		return v
+	}
+	durationValue := func(key string) time.Duration {
+		v, err := time.ParseDuration(strings.TrimSpace(values[key]))
+		if err != nil || v <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be a positive duration like 30s or 1h, got %q", key, values[key]))
+		}
+		return v
+	}
+	pathValue := func(key string) string {
+		v := strings.TrimSpace(values[key])
+		if v == "" {
+			errs = append(errs, fmt.Errorf("%s can't be empty", key))
+		}
+		return v
+	}
+
+	cfg.DBPath = pathValue("H_SAVE_DB")
+	cfg.DoujinshiDir = pathValue("H_SAVE_DOUJINSHI_DIR")
+	cfg.ImagesDir = pathValue("H_SAVE_IMAGES_DIR")
+	cfg.TorrentDir = pathValue("H_SAVE_TORRENT_DIR")
+	cfg.SecretFile = pathValue("H_SAVE_SECRET_FILE")
+	cfg.BackupDir = pathValue("H_SAVE_BACKUP_DIR")
+	cfg.BackupKeep = intValue("H_SAVE_BACKUP_KEEP")
+	if v := strings.TrimSpace(values["H_SAVE_BACKUP_INTERVAL"]); v != "0" {
+		cfg.BackupInterval = durationValue("H_SAVE_BACKUP_INTERVAL")
+	}
+
+	names := map[string]bool{library.DefaultDoujinshiRoot: true}
+	for _, entry := range splitList(values["H_SAVE_DOUJINSHI_ROOTS"]) {
+		name, dir, _ := strings.Cut(entry, "=")
+		name, dir = strings.TrimSpace(name), strings.TrimSpace(dir)
+		switch {
+		case !validRootName(name) || dir == "":
+			errs = append(errs, fmt.Errorf("H_SAVE_DOUJINSHI_ROOTS: %q must be name=folder, the name made of letters, digits, - and _", entry))
+		case names[name]:
+			errs = append(errs, fmt.Errorf("H_SAVE_DOUJINSHI_ROOTS: there are several roots named %q", name))
+		default:
+			names[name] = true
+			cfg.DoujinshiRoots = append(cfg.DoujinshiRoots, NamedDir{Name: name, Dir: dir})
+		}
+	}
+
+	cfg.Listen = strings.TrimSpace(values["H_SAVE_LISTEN"])
+	if _, port, err := net.SplitHostPort(cfg.Listen); err != nil {
+		errs = append(errs, fmt.Errorf("H_SAVE_LISTEN must be host:port or :port, got %q", cfg.Listen))
+	} else if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		errs = append(errs, fmt.Errorf("H_SAVE_LISTEN has an invalid port %q", port))
+	}
+
+	cfg.LoginLimits = db.LoginLimits{
+		IPThreshold:     intValue("H_SAVE_LOGIN_IP_THRESHOLD"),
+		GlobalThreshold: intValue("H_SAVE_LOGIN_GLOBAL_THRESHOLD"),
+		BaseLockout:     durationValue("H_SAVE_LOGIN_BASE_LOCKOUT"),
+		MaxLockout:      durationValue("H_SAVE_LOGIN_MAX_LOCKOUT"),
+		Window:          durationValue("H_SAVE_LOGIN_WINDOW"),
+	}
+	if cfg.LoginLimits.MaxLockout < cfg.LoginLimits.BaseLockout {
+		errs = append(errs, errors.New("H_SAVE_LOGIN_MAX_LOCKOUT can't be shorter than H_SAVE_LOGIN_BASE_LOCKOUT"))
+	}
+
+	// without trusted proxies the client IP is the address of the
+	// connection, so X-Forwarded-For can't dodge the login lockout
+	for _, proxy := range splitList(values["H_SAVE_TRUSTED_PROXIES"]) {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				errs = append(errs, fmt.Errorf("H_SAVE_TRUSTED_PROXIES: %q is neither an IP nor a CIDR range", proxy))
+				continue
+			}
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, proxy)
+	}
+
+	// the database and library folders may be created later, but what
+	// holds them has to exist
+	if cfg.DBPath != "" {
+		if err := requireDir(filepath.Dir(cfg.DBPath)); err != nil {
+			errs = append(errs, fmt.Errorf("H_SAVE_DB: %w", err))
+		}
+	}
+	folders := []struct{ key, dir string }{
+		{"H_SAVE_DOUJINSHI_DIR", cfg.DoujinshiDir},
+		{"H_SAVE_IMAGES_DIR", cfg.ImagesDir},
+		{"H_SAVE_TORRENT_DIR", cfg.TorrentDir},
+		{"H_SAVE_BACKUP_DIR", cfg.BackupDir},
+	}
+	for _, root := range cfg.DoujinshiRoots {
+		folders = append(folders, struct{ key, dir string }{"H_SAVE_DOUJINSHI_ROOTS " + root.Name, root.Dir})
+	}
+	for _, f := range folders {
+		if f.dir == "" {
+			continue
+		}
+		if info, err := os.Stat(f.dir); err == nil && !info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s: %s is not a folder", f.key, f.dir))
+		} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("%s: %w", f.key, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func relativeTo(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func namedPathsRelativeTo(dir, value string) string {
+	entries := splitList(value)
+	for i, entry := range entries {
+		if name, path, ok := strings.Cut(entry, "="); ok && strings.TrimSpace(path) != "" {
+			entries[i] = name + "=" + relativeTo(dir, strings.TrimSpace(path))
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func validRootName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+func requireDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a folder", dir)
+	}
+	return nil
+}
+
+// Roots are the library folders the server reads from.
+func (cfg *Config) Roots() library.Roots {
+	roots := library.Roots{
+		Doujinshi: []*library.Root{library.NewRoot(library.DefaultDoujinshiRoot, cfg.DoujinshiDir)},
+		Images:    library.NewRoot("images", cfg.ImagesDir),
+	}
+	for _, root := range cfg.DoujinshiRoots {
+		roots.Doujinshi = append(roots.Doujinshi, library.NewRoot(root.Name, root.Dir))
+	}
+	return roots
+}
@@ -0,0 +1,201 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// API tokens let scripts use the API without a browser session. They are sent
+// as "Authorization: Bearer <token>" and, like session tokens, only a hash of
+// them is stored.
+
+const apiTokenPrefix = "hs_"
+
+// APITokenScopeGroups are the route groups a scope can be limited to.
+var APITokenScopeGroups = []string{"library", "images", "user", "nhentai"}
+
+var (
+	ErrInvalidAPIToken  = errors.New("invalid API token")
+	ErrInvalidTokenName = errors.New("token name can't be empty")
+	ErrTokenNameTaken   = errors.New("a token with that name already exists")
+	ErrInvalidScope     = errors.New(`scopes must be "read", "write" or "<group>:read" / "<group>:write" with group one of ` +
+		strings.Join(APITokenScopeGroups, ", "))
+)
+
+type APIToken struct {
+	ID     int64  `json:"id"`
+	UserID int64  `json:"userId"`
+	Name   string `json:"name"`
+	// Prefix is the start of the token, enough to tell tokens apart.
+	Prefix string `json:"prefix"`
+	// Scopes is empty for full access. "read" only allows reading, "write"
+	// allows everything, and either can be limited to one route group, as in
+	// "images:write".
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+func validScope(scope string) bool {
+	group, level, found := strings.Cut(scope, ":")
+	if !found {
+		group, level = "", scope
+	}
+	if level != "read" && level != "write" {
+		return false
+	}
+	if !found {
+		return true
+	}
+	for _, g := range APITokenScopeGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether the token may make a request to the route group,
+// write being true for requests that change something.
+func (t APIToken) Allows(group string, write bool) bool {
+	if len(t.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range t.Scopes {
+		g, level, found := strings.Cut(scope, ":")
+		if !found {
+			g, level = "", scope
+		}
+		if g != "" && g != group {
+			continue
+		}
+		if level == "write" || !write {
+			return true
+		}
+	}
+	return false
+}
+
+func parseScopes(scopes string) []string {
+	fields := strings.Fields(scopes)
+	if fields == nil {
+		return []string{}
+	}
+	return fields
+}
+
+const apiTokenColumns = `id, user_id, name, prefix, scopes, created_at, last_used_at`
+
+func scanAPIToken(row interface{ Scan(...interface{}) error }) (APIToken, error) {
+	var t APIToken
+	var scopes string
+	var lastUsed sql.NullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Prefix, &scopes, &t.CreatedAt, &lastUsed)
+	t.Scopes = parseScopes(scopes)
+	if lastUsed.Valid {
+		t.LastUsedAt = &lastUsed.Time
+	}
+	return t, err
+}
+
+// CreateAPIToken adds a named token for the user and returns it. The token
+// is not stored and can't be recovered later.
+func CreateAPIToken(db *sql.DB, userID int64, name string, scopes []string) (string, APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", APIToken{}, ErrInvalidTokenName
+	}
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return "", APIToken{}, ErrInvalidScope
+		}
+	}
+
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM api_tokens WHERE user_id = ? AND name = ?)`, userID, name).Scan(&exists)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	if exists {
+		return "", APIToken{}, ErrTokenNameTaken
+	}
+
+	random, err := newToken()
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	token := apiTokenPrefix + random
+
+	result, err := db.Exec(`
+		INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, userID, name, hashToken(token), token[:len(apiTokenPrefix)+6], strings.Join(scopes, " "), time.Now().UTC())
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	t, err := scanAPIToken(db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE id = ?`, id))
+	return token, t, err
+}
+
+// ValidateAPIToken returns the token's details and records that it was used.
+// It returns ErrInvalidAPIToken for unknown and revoked tokens.
+func ValidateAPIToken(db *sql.DB, token string) (APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return APIToken{}, ErrInvalidAPIToken
+	}
+	t, err := scanAPIToken(db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE token_hash = ?`, hashToken(token)))
+	if err == sql.ErrNoRows {
+		return t, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return t, err
+	}
+
+	now := time.Now().UTC()
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) > sessionTouchInterval {
+		if _, err := db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, now, t.ID); err != nil {
+			return t, err
+		}
+		t.LastUsedAt = &now
+	}
+	return t, nil
+}
+
+func ListAPITokens(db *sql.DB, userID int64) ([]APIToken, error) {
+	rows, err := db.Query(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteAPIToken revokes one of the user's tokens. It returns sql.ErrNoRows
+// when the user has no token with that ID.
+func DeleteAPIToken(db *sql.DB, userID, id int64) error {
+	result, err := db.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
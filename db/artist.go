@@ -14,10 +14,10 @@ type ArtistData struct {
 	ImageCount    int      `json:"imageCount"`
 }
 
-func GetAllArtist(db *sql.DB) ([]ArtistData, error) {
+func GetAllArtist(db *sql.DB, userID int64) ([]ArtistData, error) {
 	favoriteArtistsSet := make(map[string]bool)
-	favQuery := `SELECT a.name FROM favorite_artists fa JOIN artists a ON fa.artist_id = a.id`
-	favRows, err := db.Query(favQuery)
+	favQuery := `SELECT a.name FROM favorite_artists fa JOIN artists a ON fa.artist_id = a.id WHERE fa.user_id = ?`
+	favRows, err := db.Query(favQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -36,7 +36,7 @@ func GetAllArtist(db *sql.DB) ([]ArtistData, error) {
 	}
 	favRows.Close() // Close after successful iteration
 
-	mainQuery := `
+	mainQuery := withUser + `
 	SELECT
 		a.id, 
 		a.name,
@@ -49,25 +49,28 @@ func GetAllArtist(db *sql.DB) ([]ArtistData, error) {
 	LEFT JOIN
 		doujinshi_artists da ON a.id = da.artist_id
 	LEFT JOIN
-		doujinshi d ON da.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != ''
+		doujinshi d ON da.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != '' AND ` + doujinshiVisible("d.id") + `
 	LEFT JOIN
 		(SELECT 
 			 po.doujinshi_id, 
 			 SUM(po.o_count) AS total_o_for_doujin 
 		 FROM doujinshi_page_o po 
+		 WHERE po.user_id = ` + currentUser + `
 		 GROUP BY po.doujinshi_id
 		) d_ocount ON d.id = d_ocount.doujinshi_id
 	LEFT JOIN
-		doujinshi_progress dp ON d.id = dp.doujinshi_id
+		doujinshi_progress dp ON d.id = dp.doujinshi_id AND dp.user_id = ` + currentUser + `
 	LEFT JOIN
-		image_artists ia ON a.id = ia.artist_id
+		image_artists ia ON a.id = ia.artist_id AND ` + imageVisible("ia.image_id") + `
+	WHERE
+		` + entityVisible("artists", "a.id") + `
 	GROUP BY
 		a.id, a.name
 	ORDER BY
 		a.name ASC;
 	`
 
-	allRows, err := db.Query(mainQuery)
+	allRows, err := db.Query(mainQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -110,18 +113,18 @@ func GetAllArtist(db *sql.DB) ([]ArtistData, error) {
 	return results, nil
 }
 
-func GetArtistDetails(db *sql.DB, artistID int64) (*ArtistData, error) {
+func GetArtistDetails(db *sql.DB, userID, artistID int64) (*ArtistData, error) {
 	// Check if the artist is a favorite
 	var isFavorite bool
 	err := db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM favorite_artists WHERE artist_id = ?)`,
-		artistID,
+		`SELECT EXISTS(SELECT 1 FROM favorite_artists WHERE user_id = ? AND artist_id = ?)`,
+		userID, artistID,
 	).Scan(&isFavorite)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 
-	query := `
+	query := withUser + `
 	SELECT
 		a.id,
 		a.name,
@@ -133,25 +136,26 @@ func GetArtistDetails(db *sql.DB, artistID int64) (*ArtistData, error) {
 	LEFT JOIN
 		doujinshi_artists da ON a.id = da.artist_id
 	LEFT JOIN
-		doujinshi d ON da.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != ''
+		doujinshi d ON da.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != '' AND ` + doujinshiVisible("d.id") + `
 	LEFT JOIN
 		(SELECT 
 			 po.doujinshi_id, 
 			 SUM(po.o_count) AS total_o_for_doujin 
 		 FROM doujinshi_page_o po 
+		 WHERE po.user_id = ` + currentUser + `
 		 GROUP BY po.doujinshi_id
 		) d_ocount ON d.id = d_ocount.doujinshi_id
 	LEFT JOIN
-		doujinshi_progress dp ON d.id = dp.doujinshi_id
+		doujinshi_progress dp ON d.id = dp.doujinshi_id AND dp.user_id = ` + currentUser + `
 	WHERE
-		a.id = ?
+		a.id = ? AND ` + entityVisible("artists", "a.id") + `
 	GROUP BY
 		a.id, a.name;
 	`
 	var artistInfo ArtistData
 	var avgRating sql.NullFloat64
 
-	err = db.QueryRow(query, artistID).Scan(
+	err = db.QueryRow(query, userID, artistID).Scan(
 		&artistInfo.ID,
 		&artistInfo.Name,
 		&artistInfo.DoujinCount,
@@ -174,15 +178,16 @@ func GetArtistDetails(db *sql.DB, artistID int64) (*ArtistData, error) {
 	return &artistInfo, nil
 }
 
-func GetDoujinshiByArtist(db *sql.DB, artistID int64) ([]Doujinshi, error) {
-	query := `
-		SELECT d.id, d.source, d.external_id, d.title, d.pages, d.uploaded, d.folder_name
+func GetDoujinshiByArtist(db *sql.DB, userID, artistID int64) ([]Doujinshi, error) {
+	query := withUser + `
+		SELECT d.id, d.source, d.external_id, d.title, d.pages, d.uploaded, d.folder_name, d.library_root
 		FROM doujinshi d
 		JOIN doujinshi_artists da ON d.id = da.doujinshi_id
 		WHERE da.artist_id = ? AND d.folder_name IS NOT NULL AND d.folder_name != ''
+			AND ` + doujinshiVisible("d.id") + `
 		ORDER BY d.uploaded DESC, d.title ASC -- Example ordering
 	`
-	rows, err := db.Query(query, artistID)
+	rows, err := db.Query(query, userID, artistID)
 	if err != nil {
 		return nil, err
 	}
@@ -192,14 +197,20 @@ func GetDoujinshiByArtist(db *sql.DB, artistID int64) ([]Doujinshi, error) {
 	for rows.Next() {
 		var d Doujinshi
 		if err := rows.Scan(&d.ID, &d.Source, &d.ExternalID, &d.Title, &d.Pages,
-			&d.Uploaded, &d.FolderName); err != nil {
+			&d.Uploaded, &d.FolderName, &d.LibraryRoot); err != nil {
 			return nil, err
 		}
 
-		populateDoujinshiDetails(db, &d)
 		results = append(results, d)
 
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateDoujinshi(db, userID, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
 
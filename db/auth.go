@@ -2,32 +2,215 @@ package db
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
 	"golang.org/x/crypto/bcrypt"
 )
 
-// NOTE: not in use
-func InsertUser(db *sql.DB, username, passwordHash string) (int64, error) {
-	res, err := db.Exec(
-		`INSERT INTO users (username, password_hash) VALUES (?, ?)`,
-		username, passwordHash,
-	)
+// DefaultUsername and DefaultPassword are the admin account of a new install.
+// Until the password is changed the account is flagged with
+// must_change_password.
+const (
+	DefaultUsername = "admin"
+	DefaultPassword = "ecchi"
+)
+
+const minPasswordLength = 6
+
+var (
+	ErrWeakPassword       = errors.New("password must be at least 6 characters and not the default password")
+	ErrInvalidUsername    = errors.New("username can't be empty")
+	ErrUserExists         = errors.New("username is already taken")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrGuestAdmin         = errors.New("a guest can't be an admin")
+)
+
+type User struct {
+	ID                 int64     `json:"id"`
+	Username           string    `json:"username"`
+	IsAdmin            bool      `json:"isAdmin"`
+	IsGuest            bool      `json:"isGuest"`
+	MustChangePassword bool      `json:"mustChangePassword"`
+	CreatedAt          time.Time `json:"createdAt"`
+}
+
+const userColumns = `id, username, is_admin, is_guest, must_change_password, created_at`
+
+func scanUser(row interface{ Scan(...interface{}) error }) (User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Username, &u.IsAdmin, &u.IsGuest, &u.MustChangePassword, &u.CreatedAt)
+	return u, err
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength || password == DefaultPassword {
+		return "", ErrWeakPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// CreateUser adds a user with a password chosen by an admin, which the user
+// has to change after logging in. Guests keep it, as the account is usually
+// shared.
+func CreateUser(db *sql.DB, username, password string, isAdmin, isGuest bool) (User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return User{}, ErrInvalidUsername
+	}
+	if isAdmin && isGuest {
+		return User{}, ErrGuestAdmin
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return User{}, err
+	}
+
+	var exists bool
+	err = db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)`, username).Scan(&exists)
+	if err != nil {
+		return User{}, err
+	}
+	if exists {
+		return User{}, ErrUserExists
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO users (username, password_hash, must_change_password, is_admin, is_guest)
+		VALUES (?, ?, ?, ?, ?)
+	`, username, hash, !isGuest, isAdmin, isGuest)
+	if err != nil {
+		return User{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	return GetUser(db, id)
+}
+
+// GetUser returns sql.ErrNoRows when the user doesn't exist.
+func GetUser(db *sql.DB, id int64) (User, error) {
+	return scanUser(db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// GetUserByUsername returns sql.ErrNoRows when the user doesn't exist.
+// Usernames are case-insensitive.
+func GetUserByUsername(db *sql.DB, username string) (User, error) {
+	return scanUser(db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, strings.TrimSpace(username)))
+}
+
+func ListUsers(db *sql.DB) ([]User, error) {
+	rows, err := db.Query(`SELECT ` + userColumns + ` FROM users ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// DeleteUser removes a user along with their favorites, progress, bookmarks,
+// saved filters and sessions. It returns sql.ErrNoRows when the user doesn't
+// exist.
+func DeleteUser(db *sql.DB, id int64) error {
+	tx, err := db.Begin()
 	if err != nil {
-		return 0, err
+		return err
 	}
-	return res.LastInsertId()
+	defer tx.Rollback()
+
+	for _, table := range perUserTables {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE user_id = ?`, id); err != nil {
+			return err
+		}
+	}
+	result, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
 }
 
-func SetPassword(db *sql.DB, passwordHash string) error {
-	_, err := db.Exec(`
-        INSERT INTO user (id, password_hash) VALUES (1, ?)
-        ON CONFLICT(id) DO UPDATE SET password_hash=excluded.password_hash
-    `, passwordHash)
+// Authenticate checks a username and password. The username may be left
+// empty while there is only one user, as logins did before there were more.
+func Authenticate(db *sql.DB, username, password string) (User, error) {
+	var user User
+	var err error
+	if strings.TrimSpace(username) == "" {
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+			return user, err
+		}
+		if count != 1 {
+			return user, ErrInvalidCredentials
+		}
+		user, err = scanUser(db.QueryRow(`SELECT ` + userColumns + ` FROM users`))
+	} else {
+		user, err = GetUserByUsername(db, username)
+	}
+	if err == sql.ErrNoRows {
+		return user, ErrInvalidCredentials
+	}
+	if err != nil {
+		return user, err
+	}
+
+	ok, err := CheckPassword(db, user.ID, password)
+	if err != nil {
+		return user, err
+	}
+	if !ok {
+		return user, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+func SetPassword(db *sql.DB, userID int64, passwordHash string) error {
+	_, err := db.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, passwordHash, userID)
 	return err
 }
 
-func CheckPassword(database *sql.DB, password string) (bool, error) {
+// ChangePassword validates and hashes the new password, stores it and clears
+// the must change flag.
+func ChangePassword(db *sql.DB, userID int64, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := SetPassword(db, userID, hash); err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE users SET must_change_password = 0 WHERE id = ?`, userID)
+	return err
+}
+
+// MustChangePassword reports whether the user still has the default password
+// or one set by an admin.
+func MustChangePassword(db *sql.DB, userID int64) (bool, error) {
+	var mustChange bool
+	err := db.QueryRow(`SELECT must_change_password FROM users WHERE id = ?`, userID).Scan(&mustChange)
+	return mustChange, err
+}
+
+func CheckPassword(database *sql.DB, userID int64, password string) (bool, error) {
 	var hash string
-	err := database.QueryRow(`SELECT password_hash FROM user WHERE id = 1`).Scan(&hash)
+	err := database.QueryRow(`SELECT password_hash FROM users WHERE id = ?`, userID).Scan(&hash)
 	if err != nil {
 		return false, err
 	}
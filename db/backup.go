@@ -0,0 +1,423 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	ErrBackupExists   = errors.New("the backup file already exists")
+	ErrBackupNotFound = errors.New("no backup with that name")
+	// ErrInvalidBackup wraps the reason a file can't be restored.
+	ErrInvalidBackup = errors.New("not a valid h_save backup")
+)
+
+// Backups are kept in one folder as h_save-<UTC time>.db, the newest first
+// when listed.
+const backupTimeLayout = "20060102T150405Z"
+
+var backupName = regexp.MustCompile(`^h_save-\d{8}T\d{6}Z(-\d+)?\.db$`)
+
+type Backup struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BackupTo writes a consistent copy of the database to path with VACUUM
+// INTO, which works while the server is using the database. An existing file
+// is never overwritten.
+func BackupTo(db *sql.DB, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return ErrBackupExists
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	_, err := db.Exec(`VACUUM INTO ?`, path)
+	return err
+}
+
+// CreateBackup adds a backup to dir, created if missing. The copy is written
+// under a temporary name first, so a failed one is never listed.
+func CreateBackup(db *sql.DB, dir string) (Backup, error) {
+	return createBackup(dir, func(path string) error {
+		return BackupTo(db, path)
+	})
+}
+
+// createBackup names the next backup in dir and has write create it.
+func createBackup(dir string, write func(path string) error) (Backup, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return Backup{}, err
+	}
+
+	now := time.Now().UTC()
+	stamp := now.Format(backupTimeLayout)
+	name := "h_save-" + stamp + ".db"
+	// backups made in the same second are numbered, after the last one so
+	// they keep sorting by time
+	existing, err := ListBackups(dir)
+	if err != nil {
+		return Backup{}, err
+	}
+	number := 1
+	for _, b := range existing {
+		if strings.HasPrefix(b.Name, "h_save-"+stamp) && backupNumber(b.Name) >= number {
+			number = backupNumber(b.Name) + 1
+		}
+	}
+	if number > 1 {
+		name = fmt.Sprintf("h_save-%s-%d.db", stamp, number)
+	}
+
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	os.Remove(tmp)
+	if err := write(tmp); err != nil {
+		os.Remove(tmp)
+		return Backup{}, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return Backup{}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Backup{}, err
+	}
+	return Backup{Name: name, Size: info.Size(), CreatedAt: now.Truncate(time.Second)}, nil
+}
+
+// backupNumber is N in h_save-<time>-N.db, 1 without it.
+func backupNumber(name string) int {
+	rest := strings.TrimSuffix(strings.TrimPrefix(name, "h_save-"), ".db")
+	if _, n, ok := strings.Cut(rest, "-"); ok {
+		if number, err := strconv.Atoi(n); err == nil {
+			return number
+		}
+	}
+	return 1
+}
+
+// ListBackups returns the backups in dir, newest first. A missing folder has
+// none.
+func ListBackups(dir string) ([]Backup, error) {
+	backups := []Backup{}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return backups, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !backupName.MatchString(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		stamp := strings.TrimPrefix(entry.Name(), "h_save-")[:len(backupTimeLayout)]
+		createdAt, err := time.Parse(backupTimeLayout, stamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Name: entry.Name(), Size: info.Size(), CreatedAt: createdAt})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].CreatedAt.Equal(backups[j].CreatedAt) {
+			return backups[i].CreatedAt.After(backups[j].CreatedAt)
+		}
+		return backupNumber(backups[i].Name) > backupNumber(backups[j].Name)
+	})
+	return backups, nil
+}
+
+// BackupPath returns the path of the backup called name in dir. Only names
+// of backups are accepted, so it can't lead out of the folder.
+func BackupPath(dir, name string) (string, error) {
+	if !backupName.MatchString(name) {
+		return "", ErrBackupNotFound
+	}
+	path := filepath.Join(dir, name)
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return "", ErrBackupNotFound
+	}
+	return path, nil
+}
+
+// PruneBackups deletes the oldest backups in dir so that keep are left, and
+// returns the deleted names.
+func PruneBackups(dir string, keep int) ([]string, error) {
+	backups, err := ListBackups(dir)
+	if err != nil || len(backups) <= keep {
+		return nil, err
+	}
+	var deleted []string
+	for _, b := range backups[keep:] {
+		if err := os.Remove(filepath.Join(dir, b.Name)); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, b.Name)
+	}
+	return deleted, nil
+}
+
+// ScheduleBackups adds a backup to dir every interval and keeps the newest
+// keep. It never returns, run it in a goroutine. The first backup is due an
+// interval after the newest one, so restarts don't postpone them.
+func ScheduleBackups(db *sql.DB, dir string, interval time.Duration, keep int) {
+	for {
+		wait := time.Duration(0)
+		if backups, err := ListBackups(dir); err != nil {
+			log.Printf("Scheduled backup: %v", err)
+			wait = interval
+		} else if len(backups) > 0 {
+			wait = interval - time.Since(backups[0].CreatedAt)
+		}
+		time.Sleep(wait)
+
+		backup, err := CreateBackup(db, dir)
+		if err != nil {
+			log.Printf("Scheduled backup failed: %v", err)
+			// try again later rather than right away
+			time.Sleep(interval)
+			continue
+		}
+		log.Printf("Scheduled backup %s done", backup.Name)
+		if _, err := PruneBackups(dir, keep); err != nil {
+			log.Printf("Deleting old backups failed: %v", err)
+		}
+	}
+}
+
+// ValidateBackup checks that the file at path is an undamaged h_save
+// database this build can migrate. Errors wrap ErrInvalidBackup.
+func ValidateBackup(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%w: %s is not a file", ErrInvalidBackup, path)
+	}
+
+	src, err := openReadOnly(path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer src.Close()
+
+	var result string
+	if err := src.QueryRow(`PRAGMA integrity_check(1)`).Scan(&result); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%w: the file is damaged: %s", ErrInvalidBackup, result)
+	}
+
+	version, _, err := currentVersion(src)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("%w: it is at schema version %d but this build only knows up to %d",
+			ErrInvalidBackup, version, len(migrations))
+	}
+	var hasDoujinshi bool
+	if err := src.QueryRow(`SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'doujinshi')`).
+		Scan(&hasDoujinshi); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if !hasDoujinshi {
+		return fmt.Errorf("%w: it has no h_save tables", ErrInvalidBackup)
+	}
+	return nil
+}
+
+func openReadOnly(path string) (*sql.DB, error) {
+	escaped := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(path)
+	db, err := sql.Open("sqlite3", "file:"+escaped+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// RestoreBackup replaces the content of the database at dbPath with the
+// backup at path, after validating it and backing up the current content to
+// backupDir. The pages are copied with the SQLite backup API in a single
+// step, so everyone using the database sees either the old content or the
+// new one. The restored database is then migrated like on startup. It
+// returns the backup of the replaced content.
+func RestoreBackup(db *sql.DB, dbPath, path, backupDir string) (Backup, error) {
+	if err := ValidateBackup(path); err != nil {
+		return Backup{}, err
+	}
+	previous, err := CreateBackup(db, backupDir)
+	if err != nil {
+		return Backup{}, fmt.Errorf("backing up the current database: %w", err)
+	}
+
+	if err := copyDatabase(db, path); err != nil {
+		return previous, fmt.Errorf("restoring %s: %w", path, err)
+	}
+	if err := setupDB(db, dbPath); err != nil {
+		return previous, fmt.Errorf("migrating the restored database: %w", err)
+	}
+	return previous, nil
+}
+
+// RestoreBackupFile replaces the database file at dbPath with the backup at
+// path. Nothing may have the database open, but unlike RestoreBackup it
+// works when the current database is damaged or too new for this build. The
+// current file is backed up to backupDir first, copied byte for byte when
+// SQLite can't read it, and the zero Backup is returned when there was none.
+// The restored database is then migrated like on startup.
+func RestoreBackupFile(dbPath, path, backupDir string) (Backup, error) {
+	if err := ValidateBackup(path); err != nil {
+		return Backup{}, err
+	}
+	previous, err := backupFile(dbPath, backupDir)
+	if err != nil {
+		return Backup{}, fmt.Errorf("backing up the current database: %w", err)
+	}
+
+	tmp := dbPath + ".restore"
+	os.Remove(tmp)
+	if err := copyFile(path, tmp); err != nil {
+		os.Remove(tmp)
+		return previous, fmt.Errorf("restoring %s: %w", path, err)
+	}
+	// the journal of the old file would be applied to the restored one
+	for _, journal := range []string{dbPath + "-wal", dbPath + "-shm"} {
+		if err := os.Remove(journal); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			os.Remove(tmp)
+			return previous, err
+		}
+	}
+	if err := os.Rename(tmp, dbPath); err != nil {
+		os.Remove(tmp)
+		return previous, fmt.Errorf("restoring %s: %w", path, err)
+	}
+
+	db, err := InitDB(dbPath)
+	if err != nil {
+		return previous, fmt.Errorf("migrating the restored database: %w", err)
+	}
+	return previous, db.Close()
+}
+
+// backupFile adds the database file at dbPath to the backups in dir.
+func backupFile(dbPath, dir string) (Backup, error) {
+	if _, err := os.Stat(dbPath); errors.Is(err, fs.ErrNotExist) {
+		return Backup{}, nil
+	} else if err != nil {
+		return Backup{}, err
+	}
+
+	if src, err := openReadOnly(dbPath); err == nil {
+		backup, err := CreateBackup(src, dir)
+		src.Close()
+		if err == nil {
+			return backup, nil
+		}
+	}
+	return createBackup(dir, func(path string) error {
+		return copyFile(dbPath, path)
+	})
+}
+
+func copyFile(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func copyDatabase(db *sql.DB, path string) error {
+	src, err := openReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	ctx := context.Background()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	destConn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(dest interface{}) error {
+		return srcConn.Raw(func(source interface{}) error {
+			return copyPages(dest.(*sqlite3.SQLiteConn), source.(*sqlite3.SQLiteConn))
+		})
+	})
+}
+
+// copyPages copies every page of source over dest, waiting while someone
+// else is writing to dest.
+func copyPages(dest, source *sqlite3.SQLiteConn) error {
+	backup, err := dest.Backup("main", source, "main")
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		done, err := backup.Step(-1)
+		if err != nil {
+			backup.Finish()
+			return err
+		}
+		if done {
+			return backup.Finish()
+		}
+		if time.Now().After(deadline) {
+			backup.Finish()
+			return errors.New("the database stayed busy")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
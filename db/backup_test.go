@@ -0,0 +1,80 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRestoreBackupFile(t *testing.T) {
+	tests := []struct {
+		name string
+		// current prepares the database being replaced
+		current      func(t *testing.T, dbPath string)
+		wantPrevious bool
+	}{
+		{"missing", func(t *testing.T, dbPath string) {}, false},
+		{"valid", func(t *testing.T, dbPath string) {
+			db, err := InitDB(dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.Close()
+		}, true},
+		{"too new", func(t *testing.T, dbPath string) {
+			db, err := InitDB(dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			if _, err := db.Exec(`INSERT INTO schema_version VALUES (999, 'future', CURRENT_TIMESTAMP)`); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+		{"damaged", func(t *testing.T, dbPath string) {
+			if err := os.WriteFile(dbPath, []byte("not a database"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			backupDir := filepath.Join(dir, "backups")
+
+			source := newTestDB(t)
+			addTestDoujinshi(t, source, Doujinshi{Title: "restored"})
+			backup := filepath.Join(dir, "backup.db")
+			if err := BackupTo(source, backup); err != nil {
+				t.Fatal(err)
+			}
+
+			dbPath := filepath.Join(dir, "h_save.db")
+			tt.current(t, dbPath)
+
+			previous, err := RestoreBackupFile(dbPath, backup, backupDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := previous.Name != ""; got != tt.wantPrevious {
+				t.Errorf("previous = %+v, want a backup: %v", previous, tt.wantPrevious)
+			}
+			if tt.wantPrevious {
+				if _, err := os.Stat(filepath.Join(backupDir, previous.Name)); err != nil {
+					t.Errorf("previous backup: %v", err)
+				}
+			}
+
+			db, err := InitDB(dbPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			var title string
+			if err := db.QueryRow(`SELECT title FROM doujinshi`).Scan(&title); err != nil || title != "restored" {
+				t.Errorf("restored title = %q, %v", title, err)
+			}
+		})
+	}
+}
@@ -5,23 +5,23 @@ import (
 	"fmt"
 )
 
-func AddBookmark(db *sql.DB, doujinshiID int64, filename, name string) error {
+func AddBookmark(db *sql.DB, userID, doujinshiID int64, filename, name string) error {
 	_, err := db.Exec(`
-		INSERT INTO doujinshi_bookmarks (doujinshi_id, filename, name)
-		VALUES (?, ?, ?)
-		ON CONFLICT(doujinshi_id, filename) DO UPDATE SET name=excluded.name
-	`, doujinshiID, filename, name)
+		INSERT INTO doujinshi_bookmarks (user_id, doujinshi_id, filename, name, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, doujinshi_id, filename) DO UPDATE SET name=excluded.name, updated_at=excluded.updated_at
+	`, userID, doujinshiID, filename, name)
 	return err
 }
 
-func GetBookmarks(db *sql.DB, doujinshiID int64) ([]DoujinshiBookmark, error) {
+func GetBookmarks(db *sql.DB, userID, doujinshiID int64) ([]DoujinshiBookmark, error) {
 	rows, err := db.Query(`
 		SELECT db.id, db.doujinshi_id, db.filename, db.name, db.created_at, d.folder_name
 		FROM doujinshi_bookmarks db
 		JOIN doujinshi d ON db.doujinshi_id = d.id
-		WHERE db.doujinshi_id = ?
+		WHERE db.user_id = ? AND db.doujinshi_id = ?
 		ORDER BY db.filename
-	`, doujinshiID)
+	`, userID, doujinshiID)
 	if err != nil {
 		return nil, err
 	}
@@ -35,7 +35,7 @@ func GetBookmarks(db *sql.DB, doujinshiID int64) ([]DoujinshiBookmark, error) {
 			// Try to get the image for this bookmark
 			if folderName != "" {
 				filePath := "doujinshi/" + folderName + "/" + bm.Filename
-				if image, err := GetImageByFilePath(db, filePath); err == nil {
+				if image, err := GetImageByFilePath(db, userID, filePath); err == nil {
 					bm.ImageID = &image.ID
 					bm.ThumbnailURL = "/api/images/" + fmt.Sprintf("%d", image.ID) + "/thumbnail"
 				}
@@ -46,8 +46,8 @@ func GetBookmarks(db *sql.DB, doujinshiID int64) ([]DoujinshiBookmark, error) {
 	return bookmarks, nil
 }
 
-// GetBookmarksByEntity gets all bookmarks for doujinshi associated with a specific entity
-func GetBookmarksByEntity(db *sql.DB, entityType string, entityID int64) ([]DoujinshiBookmark, error) {
+// GetBookmarksByEntity gets all of the user's bookmarks for doujinshi associated with a specific entity
+func GetBookmarksByEntity(db *sql.DB, userID int64, entityType string, entityID int64) ([]DoujinshiBookmark, error) {
 	var joinTable, entityIDCol string
 
 	switch entityType {
@@ -75,11 +75,11 @@ func GetBookmarksByEntity(db *sql.DB, entityType string, entityID int64) ([]Douj
 		FROM doujinshi_bookmarks db
 		JOIN ` + joinTable + ` jt ON db.doujinshi_id = jt.doujinshi_id
 		JOIN doujinshi d ON db.doujinshi_id = d.id
-		WHERE jt.` + entityIDCol + ` = ?
+		WHERE db.user_id = ? AND jt.` + entityIDCol + ` = ?
 		ORDER BY db.doujinshi_id, db.filename
 	`
 
-	rows, err := db.Query(query, entityID)
+	rows, err := db.Query(query, userID, entityID)
 	if err != nil {
 		return nil, err
 	}
@@ -93,7 +93,7 @@ func GetBookmarksByEntity(db *sql.DB, entityType string, entityID int64) ([]Douj
 			// Try to get the image for this bookmark
 			if folderName != "" {
 				filePath := "doujinshi/" + folderName + "/" + bm.Filename
-				if image, err := GetImageByFilePath(db, filePath); err == nil {
+				if image, err := GetImageByFilePath(db, userID, filePath); err == nil {
 					bm.ImageID = &image.ID
 					bm.ThumbnailURL = "/api/images/" + fmt.Sprintf("%d", image.ID) + "/thumbnail"
 				}
@@ -104,16 +104,16 @@ func GetBookmarksByEntity(db *sql.DB, entityType string, entityID int64) ([]Douj
 	return bookmarks, nil
 }
 
-func UpdateBookmark(db *sql.DB, bookmarkID int64, name string) error {
+func UpdateBookmark(db *sql.DB, userID, bookmarkID int64, name string) error {
 	_, err := db.Exec(`
 		UPDATE doujinshi_bookmarks 
-		SET name = ? 
-		WHERE id = ?
-	`, name, bookmarkID)
+		SET name = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND user_id = ?
+	`, name, bookmarkID, userID)
 	return err
 }
 
-func RemoveBookmark(db *sql.DB, doujinshiID int64, filename string) error {
-	_, err := db.Exec(`DELETE FROM doujinshi_bookmarks WHERE doujinshi_id = ? AND filename = ?`, doujinshiID, filename)
+func RemoveBookmark(db *sql.DB, userID, doujinshiID int64, filename string) error {
+	_, err := db.Exec(`DELETE FROM doujinshi_bookmarks WHERE user_id = ? AND doujinshi_id = ? AND filename = ?`, userID, doujinshiID, filename)
 	return err
 }
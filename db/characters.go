@@ -14,10 +14,10 @@ type CharacterData struct {
 	ImageCount    int      `json:"imageCount"`
 }
 
-func GetAllCharacters(db *sql.DB) ([]CharacterData, error) {
+func GetAllCharacters(db *sql.DB, userID int64) ([]CharacterData, error) {
 	favoriteCharactersSet := make(map[int64]bool)
-	favQuery := `SELECT character_id FROM favorite_characters`
-	favRows, err := db.Query(favQuery)
+	favQuery := `SELECT character_id FROM favorite_characters WHERE user_id = ?`
+	favRows, err := db.Query(favQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +34,7 @@ func GetAllCharacters(db *sql.DB) ([]CharacterData, error) {
 		return nil, err
 	}
 
-	mainQuery := `
+	mainQuery := withUser + `
 	SELECT
 		c.id,
 		c.name,
@@ -47,25 +47,28 @@ func GetAllCharacters(db *sql.DB) ([]CharacterData, error) {
 	LEFT JOIN
 		doujinshi_characters dc ON c.id = dc.character_id
 	LEFT JOIN
-		doujinshi d ON dc.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != ''
+		doujinshi d ON dc.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != '' AND ` + doujinshiVisible("d.id") + `
 	LEFT JOIN
 		(SELECT
 			 po.doujinshi_id,
 			 SUM(po.o_count) AS total_o_for_doujin
 		 FROM doujinshi_page_o po
+		 WHERE po.user_id = ` + currentUser + `
 		 GROUP BY po.doujinshi_id
 		) d_ocount ON d.id = d_ocount.doujinshi_id
 	LEFT JOIN
-		doujinshi_progress dp ON d.id = dp.doujinshi_id
+		doujinshi_progress dp ON d.id = dp.doujinshi_id AND dp.user_id = ` + currentUser + `
 	LEFT JOIN
-		image_characters ic ON c.id = ic.character_id
+		image_characters ic ON c.id = ic.character_id AND ` + imageVisible("ic.image_id") + `
+	WHERE
+		` + entityVisible("characters", "c.id") + `
 	GROUP BY
 		c.id, c.name
 	ORDER BY
 		c.name ASC;
 	`
 
-	allRows, err := db.Query(mainQuery)
+	allRows, err := db.Query(mainQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -108,17 +111,17 @@ func GetAllCharacters(db *sql.DB) ([]CharacterData, error) {
 	return results, nil
 }
 
-func GetCharacterDetails(db *sql.DB, characterID int64) (*CharacterData, error) {
+func GetCharacterDetails(db *sql.DB, userID, characterID int64) (*CharacterData, error) {
 	var isFavorite bool
 	err := db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM favorite_characters WHERE character_id = ?)`,
-		characterID,
+		`SELECT EXISTS(SELECT 1 FROM favorite_characters WHERE user_id = ? AND character_id = ?)`,
+		userID, characterID,
 	).Scan(&isFavorite)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 
-	query := `
+	query := withUser + `
 	SELECT
 		c.id,
 		c.name,
@@ -130,25 +133,26 @@ func GetCharacterDetails(db *sql.DB, characterID int64) (*CharacterData, error)
 	LEFT JOIN
 		doujinshi_characters dc ON c.id = dc.character_id
 	LEFT JOIN
-		doujinshi d ON dc.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != ''
+		doujinshi d ON dc.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != '' AND ` + doujinshiVisible("d.id") + `
 	LEFT JOIN
 		(SELECT
 			 po.doujinshi_id,
 			 SUM(po.o_count) AS total_o_for_doujin
 		 FROM doujinshi_page_o po
+		 WHERE po.user_id = ` + currentUser + `
 		 GROUP BY po.doujinshi_id
 		) d_ocount ON d.id = d_ocount.doujinshi_id
 	LEFT JOIN
-		doujinshi_progress dp ON d.id = dp.doujinshi_id
+		doujinshi_progress dp ON d.id = dp.doujinshi_id AND dp.user_id = ` + currentUser + `
 	WHERE
-		c.id = ?
+		c.id = ? AND ` + entityVisible("characters", "c.id") + `
 	GROUP BY
 		c.id, c.name;
 	`
 	var charInfo CharacterData
 	var avgRating sql.NullFloat64
 
-	err = db.QueryRow(query, characterID).Scan(
+	err = db.QueryRow(query, userID, characterID).Scan(
 		&charInfo.ID,
 		&charInfo.Name,
 		&charInfo.DoujinCount,
@@ -170,15 +174,16 @@ func GetCharacterDetails(db *sql.DB, characterID int64) (*CharacterData, error)
 	return &charInfo, nil
 }
 
-func GetDoujinshiByCharacter(db *sql.DB, characterID int64) ([]Doujinshi, error) {
-	query := `
-		SELECT d.id, d.source, d.external_id, d.title, d.pages, d.uploaded, d.folder_name
+func GetDoujinshiByCharacter(db *sql.DB, userID, characterID int64) ([]Doujinshi, error) {
+	query := withUser + `
+		SELECT d.id, d.source, d.external_id, d.title, d.pages, d.uploaded, d.folder_name, d.library_root
 		FROM doujinshi d
 		JOIN doujinshi_characters dc ON d.id = dc.doujinshi_id
 		WHERE dc.character_id = ? AND d.folder_name IS NOT NULL AND d.folder_name != ''
+			AND ` + doujinshiVisible("d.id") + `
 		ORDER BY d.uploaded DESC, d.title ASC
 	`
-	rows, err := db.Query(query, characterID)
+	rows, err := db.Query(query, userID, characterID)
 	if err != nil {
 		return nil, err
 	}
@@ -188,12 +193,18 @@ func GetDoujinshiByCharacter(db *sql.DB, characterID int64) ([]Doujinshi, error)
 	for rows.Next() {
 		var d Doujinshi
 		if err := rows.Scan(&d.ID, &d.Source, &d.ExternalID, &d.Title, &d.Pages,
-			&d.Uploaded, &d.FolderName); err != nil {
+			&d.Uploaded, &d.FolderName, &d.LibraryRoot); err != nil {
 			return nil, err
 		}
-		populateDoujinshiDetails(db, &d)
 		results = append(results, d)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateDoujinshi(db, userID, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
 
@@ -210,27 +221,27 @@ func GetCharacterIDByName(db *sql.DB, characterName string) (int64, error) {
 	return characterID, nil
 }
 
-func AddFavoriteCharacter(db *sql.DB, characterID int64) error {
-	_, err := db.Exec(`INSERT OR IGNORE INTO favorite_characters (character_id) VALUES (?)`, characterID)
+func AddFavoriteCharacter(db *sql.DB, userID, characterID int64) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO favorite_characters (user_id, character_id) VALUES (?, ?)`, userID, characterID)
 	return err
 }
 
-func RemoveFavoriteCharacter(db *sql.DB, characterID int64) error {
-	_, err := db.Exec(`DELETE FROM favorite_characters WHERE character_id = ?`, characterID)
+func RemoveFavoriteCharacter(db *sql.DB, userID, characterID int64) error {
+	_, err := db.Exec(`DELETE FROM favorite_characters WHERE user_id = ? AND character_id = ?`, userID, characterID)
 	return err
 }
 
-func GetImagesByCharacter(db *sql.DB, characterID int64) ([]Image, error) {
-	query := `
+func GetImagesByCharacter(db *sql.DB, userID, characterID int64) ([]Image, error) {
+	query := withUser + `
 		SELECT i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
 			i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
 			COALESCE(i.hash, '') as hash
 		FROM images i
 		JOIN image_characters ic ON i.id = ic.image_id
-		WHERE ic.character_id = ?
+		WHERE ic.character_id = ? AND ` + imageVisible("i.id") + `
 		ORDER BY i.uploaded DESC
 	`
-	rows, err := db.Query(query, characterID)
+	rows, err := db.Query(query, userID, characterID)
 	if err != nil {
 		return nil, err
 	}
@@ -244,8 +255,14 @@ func GetImagesByCharacter(db *sql.DB, characterID int64) ([]Image, error) {
 			&img.Format, &img.Uploaded, &img.Hash); err != nil {
 			return nil, err
 		}
-		populateImageDetails(db, &img)
 		results = append(results, img)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateImages(db, userID, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Provider credentials are the cookies of a user's account on a source site.
+// They are stored encrypted with AES-GCM, the key being derived from the
+// server secret, so a copy of the database alone doesn't leak them.
+
+const (
+	CredentialsValid     = "valid"
+	CredentialsExpired   = "expired"
+	CredentialsUnchecked = "unchecked"
+)
+
+var (
+	ErrNoCredentials = errors.New("no credentials saved")
+	// ErrCredentialsKey means the credentials were encrypted with another
+	// server secret.
+	ErrCredentialsKey = errors.New("saved credentials can't be decrypted, the server secret changed")
+)
+
+type ProviderCredentials struct {
+	SessionId string `json:"sessionId"`
+	CsrfToken string `json:"csrfToken"`
+}
+
+// CredentialsStatus describes saved credentials without revealing them.
+type CredentialsStatus struct {
+	Provider string `json:"provider"`
+	// AccountName is the username on the provider, known once validated.
+	AccountName string     `json:"accountName"`
+	Status      string     `json:"status"`
+	ValidatedAt *time.Time `json:"validatedAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// DeriveCredentialsKey turns the server secret into the AES-256 key used for
+// provider credentials.
+func DeriveCredentialsKey(secret []byte) ([]byte, error) {
+	key := make([]byte, 32)
+	kdf := hkdf.New(sha256.New, secret, nil, []byte("h_save provider credentials"))
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func credentialsCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SaveProviderCredentials stores the user's credentials for provider,
+// replacing earlier ones. They are unchecked until ValidateProviderCredentials
+// records the result of a check.
+func SaveProviderCredentials(db *sql.DB, key []byte, userID int64, provider string, creds ProviderCredentials) error {
+	aead, err := credentialsCipher(key)
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	// the row is bound to the user and provider, so it can't be copied to
+	// another user
+	sealed := aead.Seal(nonce, nonce, plaintext, credentialsAD(userID, provider))
+
+	_, err = db.Exec(`
+		INSERT INTO provider_credentials (user_id, provider, encrypted, status, account_name, validated_at, updated_at)
+		VALUES (?, ?, ?, ?, '', NULL, ?)
+		ON CONFLICT(user_id, provider) DO UPDATE SET
+			encrypted = excluded.encrypted,
+			status = excluded.status,
+			account_name = '',
+			validated_at = NULL,
+			updated_at = excluded.updated_at
+	`, userID, provider, sealed, CredentialsUnchecked, time.Now().UTC())
+	return err
+}
+
+func credentialsAD(userID int64, provider string) []byte {
+	ad, _ := json.Marshal([]interface{}{userID, provider})
+	return ad
+}
+
+// GetProviderCredentials decrypts the user's credentials. It returns
+// ErrNoCredentials when none are saved.
+func GetProviderCredentials(db *sql.DB, key []byte, userID int64, provider string) (ProviderCredentials, error) {
+	var creds ProviderCredentials
+	var sealed []byte
+	err := db.QueryRow(`SELECT encrypted FROM provider_credentials WHERE user_id = ? AND provider = ?`,
+		userID, provider).Scan(&sealed)
+	if err == sql.ErrNoRows {
+		return creds, ErrNoCredentials
+	}
+	if err != nil {
+		return creds, err
+	}
+
+	aead, err := credentialsCipher(key)
+	if err != nil {
+		return creds, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return creds, ErrCredentialsKey
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, credentialsAD(userID, provider))
+	if err != nil {
+		return creds, ErrCredentialsKey
+	}
+	err = json.Unmarshal(plaintext, &creds)
+	return creds, err
+}
+
+// GetCredentialsStatus returns ErrNoCredentials when none are saved.
+func GetCredentialsStatus(db *sql.DB, userID int64, provider string) (CredentialsStatus, error) {
+	s := CredentialsStatus{Provider: provider}
+	var validatedAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT account_name, status, validated_at, updated_at
+		FROM provider_credentials WHERE user_id = ? AND provider = ?
+	`, userID, provider).Scan(&s.AccountName, &s.Status, &validatedAt, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return s, ErrNoCredentials
+	}
+	if validatedAt.Valid {
+		s.ValidatedAt = &validatedAt.Time
+	}
+	return s, err
+}
+
+// SetCredentialsStatus records the result of checking the credentials,
+// status being CredentialsValid or CredentialsExpired.
+func SetCredentialsStatus(db *sql.DB, userID int64, provider, status, accountName string) error {
+	_, err := db.Exec(`
+		UPDATE provider_credentials SET status = ?, account_name = ?, validated_at = ?
+		WHERE user_id = ? AND provider = ?
+	`, status, accountName, time.Now().UTC(), userID, provider)
+	return err
+}
+
+// DeleteProviderCredentials returns sql.ErrNoRows when none were saved.
+func DeleteProviderCredentials(db *sql.DB, userID int64, provider string) error {
+	result, err := db.Exec(`DELETE FROM provider_credentials WHERE user_id = ? AND provider = ?`, userID, provider)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/brayanMuniz/h_save/types"
+)
+
+// Queries reading a user's progress, favorites or bookmarks start with
+// withUser, binding the user ID as their first argument, and refer to it as
+// currentUser.
+const (
+	withUser    = `WITH me(user_id) AS (SELECT ?)`
+	currentUser = `(SELECT user_id FROM me)`
+)
+
+func userArgs(userID int64, args []interface{}) []interface{} {
+	return append([]interface{}{userID}, args...)
+}
+
+// Per-row expressions used by the browse query. They expect the doujinshi
+// table aliased as d and doujinshiProgressJoin.
+const (
+	doujinshiProgressJoin      = `LEFT JOIN doujinshi_progress p ON p.doujinshi_id = d.id AND p.user_id = ` + currentUser
+	doujinshiOCountExpr        = `(SELECT COALESCE(SUM(po.o_count), 0) FROM doujinshi_page_o po WHERE po.doujinshi_id = d.id AND po.user_id = ` + currentUser + `)`
+	doujinshiBookmarkCountExpr = `(SELECT COUNT(*) FROM doujinshi_bookmarks bm WHERE bm.doujinshi_id = d.id AND bm.user_id = ` + currentUser + `)`
+	doujinshiRatingExpr        = `COALESCE(p.rating, 0)`
+	doujinshiPageCountExpr     = `CAST(COALESCE(d.pages, '0') AS INTEGER)`
+)
+
+// entityRelation links doujinshi or images (the owner) to named entities
+// through a join table.
+type entityRelation struct {
+	entityTable string
+	joinTable   string
+	entityIDCol string
+	ownerCol    string
+}
+
+var (
+	doujinshiTags       = entityRelation{"tags", "doujinshi_tags", "tag_id", "doujinshi_id"}
+	doujinshiArtists    = entityRelation{"artists", "doujinshi_artists", "artist_id", "doujinshi_id"}
+	doujinshiCharacters = entityRelation{"characters", "doujinshi_characters", "character_id", "doujinshi_id"}
+	doujinshiParodies   = entityRelation{"parodies", "doujinshi_parodies", "parody_id", "doujinshi_id"}
+	doujinshiGroups     = entityRelation{"groups", "doujinshi_groups", "group_id", "doujinshi_id"}
+	doujinshiLanguages  = entityRelation{"languages", "doujinshi_languages", "language_id", "doujinshi_id"}
+	doujinshiCategories = entityRelation{"categories", "doujinshi_categories", "category_id", "doujinshi_id"}
+)
+
+// idsMatching returns a subquery selecting the owners linked to any of the
+// given names. Names are compared case-insensitively.
+func (r entityRelation) idsMatching(count int) string {
+	return `SELECT j.` + r.ownerCol + ` FROM ` + r.joinTable + ` j
+		JOIN ` + r.entityTable + ` e ON e.id = j.` + r.entityIDCol + `
+		WHERE e.name COLLATE NOCASE IN (` + placeholders(count) + `)`
+}
+
+// filterClause collects SQL conditions and their arguments so they can be
+// joined into a single WHERE clause.
+type filterClause struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (f *filterClause) add(condition string, args ...interface{}) {
+	f.conditions = append(f.conditions, condition)
+	f.args = append(f.args, args...)
+}
+
+func (f *filterClause) where() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(f.conditions, " AND ")
+}
+
+func placeholders(count int) string {
+	if count <= 0 {
+		return ""
+	}
+	return strings.Repeat("?,", count-1) + "?"
+}
+
+func stringArgs(values []string) []interface{} {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+	return args
+}
+
+// Every included value must be present, any excluded value rules the row out.
+// idColumn is the owner's ID in the outer query.
+func (f *filterClause) addGroup(idColumn string, relation entityRelation, group types.FilterGroup) {
+	for _, name := range nonEmpty(group.Included) {
+		f.add(idColumn+` IN (`+relation.idsMatching(1)+`)`, name)
+	}
+	if excluded := nonEmpty(group.Excluded); len(excluded) > 0 {
+		f.add(idColumn+` NOT IN (`+relation.idsMatching(len(excluded))+`)`, stringArgs(excluded)...)
+	}
+}
+
+// The owner must have at least one of the values.
+func (f *filterClause) addAnyOf(idColumn string, relation entityRelation, values []string) {
+	var names []string
+	for _, v := range nonEmpty(values) {
+		// the browse page uses "all" to mean no language filter
+		if strings.EqualFold(v, "all") {
+			return
+		}
+		names = append(names, v)
+	}
+	if len(names) > 0 {
+		f.add(idColumn+` IN (`+relation.idsMatching(len(names))+`)`, stringArgs(names)...)
+	}
+}
+
+func (f *filterClause) addRange(expr string, r types.RangeFilter) {
+	if r.Min > 0 {
+		f.add(expr+` >= ?`, r.Min)
+	}
+	if r.Max > 0 {
+		f.add(expr+` <= ?`, r.Max)
+	}
+}
+
+func nonEmpty(values []string) []string {
+	var result []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func buildDoujinshiFilter(filters types.BrowseFilters) (filterClause, error) {
+	var f filterClause
+	f.add(`d.folder_name IS NOT NULL AND d.folder_name != ''`)
+	f.add(doujinshiVisible("d.id"))
+
+	f.addGroup("d.id", doujinshiArtists, filters.Artists)
+	f.addGroup("d.id", doujinshiGroups, filters.Groups)
+	f.addGroup("d.id", doujinshiTags, filters.Tags)
+	f.addGroup("d.id", doujinshiCharacters, filters.Characters)
+	f.addGroup("d.id", doujinshiParodies, filters.Parodies)
+	f.addAnyOf("d.id", doujinshiLanguages, filters.Languages)
+	// Formats only apply to images, genres are stored as categories
+	f.addAnyOf("d.id", doujinshiCategories, filters.Genres)
+
+	f.addRange(doujinshiRatingExpr, filters.Rating)
+	f.addRange(doujinshiOCountExpr, filters.OCount)
+	f.addRange(doujinshiPageCountExpr, filters.PageCount)
+	f.addRange(doujinshiBookmarkCountExpr, filters.BookmarkCount)
+
+	if search := strings.TrimSpace(filters.Search); search != "" {
+		cond, args := doujinshiSearchCondition(search)
+		f.add(cond, args...)
+	}
+
+	if filters.CurrentlyReading {
+		f.add(doujinshiReadingCondition)
+	}
+
+	if filters.Expression != nil {
+		if err := filters.Expression.Validate(); err != nil {
+			return f, err
+		}
+		cond, args := compileDoujinshiExpr(*filters.Expression)
+		f.add(cond, args...)
+	}
+
+	if filters.Query != "" {
+		expr, err := types.ParseQuery(filters.Query)
+		if err != nil {
+			return f, err
+		}
+		if expr != nil {
+			cond, args := compileDoujinshiExpr(*expr)
+			f.add(cond, args...)
+		}
+	}
+
+	return f, nil
+}
+
+const doujinshiReadingCondition = `(COALESCE(p.last_page, 0) > 0 AND COALESCE(p.last_page, 0) < ` + doujinshiPageCountExpr + `)`
+
+// doujinshiSearchCondition matches titles through the FTS index when it is
+// available and falls back to LIKE otherwise. Words with CJK characters are
+// matched as substrings, see searchTerms.
+func doujinshiSearchCondition(search string) (string, []interface{}) {
+	if match, substrings := searchTerms(search); searchIndexAvailable && (match != "" || len(substrings) > 0) {
+		var conds []string
+		var args []interface{}
+		if match != "" {
+			conds = append(conds, `doujinshi_fts MATCH ?`)
+			args = append(args, match)
+		}
+		if len(substrings) > 0 {
+			cond, condArgs := substringConditions(substrings, "title", "second_title", "metadata")
+			conds = append(conds, cond)
+			args = append(args, condArgs...)
+		}
+		return `d.id IN (SELECT rowid FROM doujinshi_fts WHERE ` + strings.Join(conds, " AND ") + `)`, args
+	}
+	pattern := "%" + search + "%"
+	return `(d.title LIKE ? OR d.second_title LIKE ?)`, []interface{}{pattern, pattern}
+}
+
+var doujinshiExprRelations = map[string]entityRelation{
+	types.FieldArtist:    doujinshiArtists,
+	types.FieldTag:       doujinshiTags,
+	types.FieldCharacter: doujinshiCharacters,
+	types.FieldParody:    doujinshiParodies,
+	types.FieldGroup:     doujinshiGroups,
+	types.FieldLanguage:  doujinshiLanguages,
+	types.FieldCategory:  doujinshiCategories,
+}
+
+var doujinshiExprNumbers = map[string]string{
+	types.FieldRating:    doujinshiRatingExpr,
+	types.FieldOCount:    doujinshiOCountExpr,
+	types.FieldPages:     doujinshiPageCountExpr,
+	types.FieldBookmarks: doujinshiBookmarkCountExpr,
+}
+
+// compileDoujinshiExpr turns a validated expression into a single SQL
+// condition. An empty and matches everything, an empty or matches nothing.
+// A condition that is NULL, e.g. over a missing progress row, counts as
+// false so negating it matches.
+func compileDoujinshiExpr(e types.FilterExpr) (string, []interface{}) {
+	switch e.Op {
+	case types.OpAnd, types.OpOr:
+		if len(e.Children) == 0 {
+			if e.Op == types.OpAnd {
+				return "1", nil
+			}
+			return "0", nil
+		}
+		var parts []string
+		var args []interface{}
+		for _, child := range e.Children {
+			cond, childArgs := compileDoujinshiExpr(child)
+			parts = append(parts, cond)
+			args = append(args, childArgs...)
+		}
+		return "(" + strings.Join(parts, " "+strings.ToUpper(e.Op)+" ") + ")", args
+	case types.OpNot:
+		cond, args := compileDoujinshiExpr(e.Children[0])
+		return "NOT COALESCE(" + cond + ", 0)", args
+	}
+
+	if relation, ok := doujinshiExprRelations[e.Field]; ok {
+		return `(d.id IN (` + relation.idsMatching(1) + `))`, []interface{}{strings.TrimSpace(e.Value)}
+	}
+	if expr, ok := doujinshiExprNumbers[e.Field]; ok {
+		cmp := e.Cmp
+		if cmp == "" {
+			cmp = "="
+		}
+		value, _ := strconv.Atoi(e.Value)
+		return `(` + expr + ` ` + cmp + ` ?)`, []interface{}{value}
+	}
+	if e.Field == types.FieldSearch {
+		cond, args := doujinshiSearchCondition(strings.TrimSpace(e.Value))
+		return "(" + cond + ")", args
+	}
+	return doujinshiReadingCondition, nil
+}
+
+var doujinshiSortColumns = map[string]sortColumn{
+	"uploaded": {`COALESCE(CAST(strftime('%s', d.uploaded) AS INTEGER), 0)`, "desc"},
+	"title":    {`COALESCE(d.title, '') COLLATE NOCASE`, "asc"},
+	"rating":   {doujinshiRatingExpr, "desc"},
+	"oCount":   {doujinshiOCountExpr, "desc"},
+}
+
+func init() {
+	// names used by the README and the browse page
+	doujinshiSortColumns["date"] = doujinshiSortColumns["uploaded"]
+	doujinshiSortColumns["ocount"] = doujinshiSortColumns["oCount"]
+}
+
+type DoujinshiPage struct {
+	Doujinshi  []Doujinshi `json:"doujinshi"`
+	Total      int         `json:"total"`
+	NextCursor string      `json:"nextCursor,omitempty"`
+	Seed       int64       `json:"seed,omitempty"`
+}
+
+// ListDoujinshi returns one page of the synced doujinshi matching the filters,
+// along with the total number of matches. Ratings, o-counts and bookmarks are
+// the user's.
+func ListDoujinshi(db *sql.DB, userID int64, filters types.BrowseFilters, opts ListOptions) (DoujinshiPage, error) {
+	var page DoujinshiPage
+
+	opts, err := opts.paged()
+	if err != nil {
+		return page, err
+	}
+	sorting, err := resolveSort(opts, doujinshiSortColumns, "uploaded", "d.id")
+	if err != nil {
+		return page, err
+	}
+	page.Seed = sorting.seed
+
+	clause, err := buildDoujinshiFilter(filters)
+	if err != nil {
+		return page, err
+	}
+	if page.Total, err = countDoujinshi(db, userID, clause); err != nil {
+		return page, err
+	}
+
+	if opts.Cursor != "" {
+		if err := sorting.addCursor(&clause, opts.Cursor, "d.id"); err != nil {
+			return page, err
+		}
+	}
+
+	// fetch one extra row to know if there is a next page
+	pageOpts := opts
+	if pageOpts.Limit > 0 {
+		pageOpts.Limit++
+	}
+	limit := pageOpts.limitOffset()
+
+	rows, err := db.Query(withUser+`
+	SELECT
+		d.id, d.source, d.external_id, d.title, COALESCE(d.second_title, '') as second_title,
+		d.pages, d.uploaded, d.folder_name, d.library_root,
+		`+doujinshiOCountExpr+` AS o_count,
+		`+doujinshiBookmarkCountExpr+` AS bookmark_count,
+		`+sorting.expr+` AS sort_key
+	FROM doujinshi d
+	`+doujinshiProgressJoin+`
+	`+clause.where()+`
+	`+sorting.orderBy("d.id")+`
+	`+limit, userArgs(userID, clause.args)...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	var doujinshiList []Doujinshi
+	var sortKeys []interface{}
+	for rows.Next() {
+		var d Doujinshi
+		var sortKey interface{}
+		err := rows.Scan(
+			&d.ID, &d.Source, &d.ExternalID, &d.Title, &d.SecondTitle, &d.Pages,
+			&d.Uploaded, &d.FolderName, &d.LibraryRoot, &d.OCount, &d.BookmarkCount, &sortKey,
+		)
+		if err != nil {
+			return page, err
+		}
+		doujinshiList = append(doujinshiList, d)
+		sortKeys = append(sortKeys, sortKey)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if opts.Limit > 0 && len(doujinshiList) > opts.Limit {
+		doujinshiList = doujinshiList[:opts.Limit]
+		last := doujinshiList[len(doujinshiList)-1]
+		page.NextCursor = sorting.cursorAfter(sortKeys[opts.Limit-1], last.ID)
+	}
+
+	if err := hydrateDoujinshi(db, userID, doujinshiList); err != nil {
+		return page, err
+	}
+	page.Doujinshi = doujinshiList
+	return page, nil
+}
+
+// CountDoujinshi returns how many synced doujinshi match the filters for the
+// user.
+func CountDoujinshi(db *sql.DB, userID int64, filters types.BrowseFilters) (int, error) {
+	clause, err := buildDoujinshiFilter(filters)
+	if err != nil {
+		return 0, err
+	}
+	return countDoujinshi(db, userID, clause)
+}
+
+func countDoujinshi(db *sql.DB, userID int64, clause filterClause) (int, error) {
+	var total int
+	err := db.QueryRow(withUser+`
+	SELECT COUNT(*)
+	FROM doujinshi d
+	`+doujinshiProgressJoin+`
+	`+clause.where(), userArgs(userID, clause.args)...).Scan(&total)
+	return total, err
+}
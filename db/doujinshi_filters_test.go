@@ -0,0 +1,153 @@
+package db
+
+import (
+	"database/sql"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/brayanMuniz/h_save/types"
+)
+
+func pointerTo(v int) *int { return &v }
+
+// seedFilterLibrary adds four 20 page doujinshi: one never opened, one rated
+// but never read, one being read and one finished.
+func seedFilterLibrary(t *testing.T, db *sql.DB) map[string]int64 {
+	t.Helper()
+	ids := map[string]int64{}
+	for _, title := range []string{"unopened", "rated", "reading", "finished"} {
+		ids[title] = addTestDoujinshi(t, db, Doujinshi{Title: title, Pages: "20", Tags: []string{title}})
+	}
+
+	progress := []struct {
+		title    string
+		rating   *int
+		lastPage *int
+	}{
+		{"rated", pointerTo(5), nil},
+		{"reading", pointerTo(3), pointerTo(5)},
+		{"finished", nil, pointerTo(20)},
+	}
+	for _, p := range progress {
+		id := strconv.FormatInt(ids[p.title], 10)
+		if err := SetDoujinshiProgress(db, testUserID, id, p.rating, p.lastPage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ids
+}
+
+func TestListDoujinshiQuery(t *testing.T) {
+	db := newTestDB(t)
+	seedFilterLibrary(t, db)
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"is:reading", []string{"reading"}},
+		{"-is:reading", []string{"finished", "rated", "unopened"}},
+		{"NOT is:reading", []string{"finished", "rated", "unopened"}},
+		{"rating>=4", []string{"rated"}},
+		{"-rating>=4", []string{"finished", "reading", "unopened"}},
+		{"-rating>=3", []string{"finished", "unopened"}},
+		{"rating=0", []string{"finished", "unopened"}},
+		{"-(is:reading OR rating>=4)", []string{"finished", "unopened"}},
+		{"-tag:unopened -tag:finished", []string{"rated", "reading"}},
+		{"-(-is:reading)", []string{"reading"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			page, err := ListDoujinshi(db, testUserID, types.BrowseFilters{Query: tt.query}, ListOptions{All: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, d := range page.Doujinshi {
+				got = append(got, d.Title)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("titles = %v, want %v", got, tt.want)
+			}
+			if page.Total != len(tt.want) {
+				t.Errorf("total = %d, want %d", page.Total, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestDoujinshiLibraryRoot(t *testing.T) {
+	db := newTestDB(t)
+	id := addTestDoujinshi(t, db, Doujinshi{Title: "moved book", Tags: []string{"vanilla"}, Artists: []string{"someone"}})
+	addTestDoujinshi(t, db, Doujinshi{Title: "other book", Tags: []string{"vanilla"}, Artists: []string{"someone"}})
+	if err := SetLibraryRoot(db, id, "archive"); err != nil {
+		t.Fatal(err)
+	}
+	tagID, err := GetTagIDByName(db, "vanilla")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaders := []struct {
+		name string
+		load func(t *testing.T) ([]Doujinshi, error)
+	}{
+		{"list", func(t *testing.T) ([]Doujinshi, error) {
+			page, err := ListDoujinshi(db, testUserID, types.BrowseFilters{}, ListOptions{})
+			return page.Doujinshi, err
+		}},
+		{"by tag", func(t *testing.T) ([]Doujinshi, error) { return GetDoujinshiByTag(db, testUserID, tagID) }},
+		{"global search", func(t *testing.T) ([]Doujinshi, error) {
+			results, err := GlobalSearch(db, testUserID, "moved", 10)
+			var list []Doujinshi
+			for _, r := range results.Doujinshi {
+				list = append(list, r.Doujinshi)
+			}
+			return list, err
+		}},
+		{"similar", func(t *testing.T) ([]Doujinshi, error) {
+			similar, err := GetSimilarDoujinshi(db, testUserID, id+1, 10)
+			var list []Doujinshi
+			for _, s := range similar {
+				list = append(list, s.Doujinshi)
+			}
+			return list, err
+		}},
+		{"text search", func(t *testing.T) ([]Doujinshi, error) {
+			if !searchIndexAvailable {
+				t.Skip("full-text search needs -tags sqlite_fts5")
+			}
+			results, err := SearchDoujinshiText(db, testUserID, "moved", 10)
+			var list []Doujinshi
+			for _, r := range results {
+				list = append(list, r.Doujinshi)
+			}
+			return list, err
+		}},
+	}
+
+	for _, l := range loaders {
+		t.Run(l.name, func(t *testing.T) {
+			list, err := l.load(t)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, d := range list {
+				if d.ID == id {
+					found = true
+					if d.LibraryRoot != "archive" {
+						t.Errorf("library root = %q, want archive", d.LibraryRoot)
+					}
+				}
+			}
+			if !found {
+				t.Errorf("doujinshi %d missing from %d results", id, len(list))
+			}
+		})
+	}
+}
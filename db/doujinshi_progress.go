@@ -5,7 +5,7 @@ import (
 	"strconv"
 )
 
-func GetDoujinshiProgress(db *sql.DB, doujinshiID string) (DoujinshiProgress, error) {
+func GetDoujinshiProgress(db *sql.DB, userID int64, doujinshiID string) (DoujinshiProgress, error) {
 	var progress DoujinshiProgress
 	id, err := strconv.ParseInt(doujinshiID, 10, 64)
 	if err != nil {
@@ -17,8 +17,8 @@ func GetDoujinshiProgress(db *sql.DB, doujinshiID string) (DoujinshiProgress, er
 	err = db.QueryRow(`
         SELECT rating, last_page 
         FROM doujinshi_progress 
-        WHERE doujinshi_id = ?
-    `, id).Scan(&progress.Rating, &progress.LastPage)
+        WHERE user_id = ? AND doujinshi_id = ?
+    `, userID, id).Scan(&progress.Rating, &progress.LastPage)
 
 	if err == sql.ErrNoRows {
 		// No progress record exists, return empty progress with just the ID
@@ -30,6 +30,7 @@ func GetDoujinshiProgress(db *sql.DB, doujinshiID string) (DoujinshiProgress, er
 
 func SetDoujinshiProgress(
 	db *sql.DB,
+	userID int64,
 	doujinshiID string,
 	rating *int,
 	lastPage *int,
@@ -40,18 +41,20 @@ func SetDoujinshiProgress(
 	}
 
 	_, err = db.Exec(`
-        INSERT INTO doujinshi_progress (doujinshi_id, rating, last_page)
-        VALUES (?, ?, ?)
-        ON CONFLICT(doujinshi_id) DO UPDATE SET
+        INSERT INTO doujinshi_progress (user_id, doujinshi_id, rating, last_page, updated_at)
+        VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+        ON CONFLICT(user_id, doujinshi_id) DO UPDATE SET
             rating = COALESCE(excluded.rating, rating),
-            last_page = COALESCE(excluded.last_page, last_page)
-    `, id, rating, lastPage)
+            last_page = COALESCE(excluded.last_page, last_page),
+            updated_at = excluded.updated_at
+    `, userID, id, rating, lastPage)
 
 	return err
 }
 
 func UpdateDoujinshiProgress(
 	db *sql.DB,
+	userID int64,
 	doujinshiID string,
 	rating *int,
 	lastPage *int,
@@ -76,9 +79,9 @@ func UpdateDoujinshiProgress(
 		lastPageValue = nil
 	}
 
-	query := `UPDATE doujinshi_progress SET rating = ?, last_page = ? WHERE doujinshi_id = ?`
+	query := `UPDATE doujinshi_progress SET rating = ?, last_page = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ? AND doujinshi_id = ?`
 
-	result, err := db.Exec(query, ratingValue, lastPageValue, id)
+	result, err := db.Exec(query, ratingValue, lastPageValue, userID, id)
 	if err != nil {
 		return err
 	}
@@ -90,8 +93,8 @@ func UpdateDoujinshiProgress(
 
 	// If no rows were affected, create a new record
 	if rowsAffected == 0 {
-		insertQuery := `INSERT INTO doujinshi_progress (doujinshi_id, rating, last_page) VALUES (?, ?, ?)`
-		_, err = db.Exec(insertQuery, id, ratingValue, lastPageValue)
+		insertQuery := `INSERT INTO doujinshi_progress (user_id, doujinshi_id, rating, last_page, updated_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`
+		_, err = db.Exec(insertQuery, userID, id, ratingValue, lastPageValue)
 		return err
 	}
 
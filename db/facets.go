@@ -0,0 +1,167 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/brayanMuniz/h_save/types"
+)
+
+type FacetValue struct {
+	ID    int64  `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// HistogramBucket counts the doujinshi whose value is in [Min, Min+BucketSize).
+type HistogramBucket struct {
+	Min   int `json:"min"`
+	Count int `json:"count"`
+}
+
+type Histogram struct {
+	BucketSize int               `json:"bucketSize"`
+	Buckets    []HistogramBucket `json:"buckets"`
+}
+
+type DoujinshiFacets struct {
+	Total      int          `json:"total"`
+	Tags       []FacetValue `json:"tags"`
+	Artists    []FacetValue `json:"artists"`
+	Characters []FacetValue `json:"characters"`
+	Parodies   []FacetValue `json:"parodies"`
+	Groups     []FacetValue `json:"groups"`
+	Languages  []FacetValue `json:"languages"`
+	Categories []FacetValue `json:"categories"`
+	Rating     Histogram    `json:"rating"`
+	OCount     Histogram    `json:"oCount"`
+	PageCount  Histogram    `json:"pageCount"`
+}
+
+const (
+	ratingBucketSize = 1
+	oCountBucketSize = 1
+	pagesBucketSize  = 10
+)
+
+// GetDoujinshiFacets counts, within the doujinshi matching the filters, how
+// many have each related value and how the numeric fields are distributed.
+// limit caps the values returned per facet, 0 returns all of them. Ratings and
+// o-counts are the user's.
+func GetDoujinshiFacets(db *sql.DB, userID int64, filters types.BrowseFilters, limit int) (DoujinshiFacets, error) {
+	var facets DoujinshiFacets
+
+	clause, err := buildDoujinshiFilter(filters)
+	if err != nil {
+		return facets, err
+	}
+	if facets.Total, err = countDoujinshi(db, userID, clause); err != nil {
+		return facets, err
+	}
+
+	// every facet query starts from the same filtered set
+	matched := withUser + `,
+	matched AS (
+		SELECT
+			d.id,
+			` + doujinshiRatingExpr + ` AS rating,
+			` + doujinshiOCountExpr + ` AS o_count,
+			` + doujinshiPageCountExpr + ` AS pages
+		FROM doujinshi d
+		` + doujinshiProgressJoin + `
+		` + clause.where() + `
+	)`
+
+	relations := []struct {
+		relation entityRelation
+		values   *[]FacetValue
+	}{
+		{doujinshiTags, &facets.Tags},
+		{doujinshiArtists, &facets.Artists},
+		{doujinshiCharacters, &facets.Characters},
+		{doujinshiParodies, &facets.Parodies},
+		{doujinshiGroups, &facets.Groups},
+		{doujinshiLanguages, &facets.Languages},
+		{doujinshiCategories, &facets.Categories},
+	}
+	args := userArgs(userID, clause.args)
+	for _, r := range relations {
+		values, err := facetValues(db, matched, r.relation, args, limit)
+		if err != nil {
+			return facets, err
+		}
+		*r.values = values
+	}
+
+	histograms := []struct {
+		column     string
+		bucketSize int
+		histogram  *Histogram
+	}{
+		{"rating", ratingBucketSize, &facets.Rating},
+		{"o_count", oCountBucketSize, &facets.OCount},
+		{"pages", pagesBucketSize, &facets.PageCount},
+	}
+	for _, h := range histograms {
+		buckets, err := histogramBuckets(db, matched, h.column, h.bucketSize, args)
+		if err != nil {
+			return facets, err
+		}
+		*h.histogram = Histogram{BucketSize: h.bucketSize, Buckets: buckets}
+	}
+
+	return facets, nil
+}
+
+func facetValues(db *sql.DB, matched string, relation entityRelation, args []interface{}, limit int) ([]FacetValue, error) {
+	query := matched + `
+	SELECT e.id, e.name, COUNT(*) AS doujin_count
+	FROM matched m
+	JOIN ` + relation.joinTable + ` j ON j.` + relation.ownerCol + ` = m.id
+	JOIN ` + relation.entityTable + ` e ON e.id = j.` + relation.entityIDCol + `
+	GROUP BY e.id
+	ORDER BY doujin_count DESC, e.name COLLATE NOCASE ASC`
+
+	queryArgs := args
+	if limit > 0 {
+		query += ` LIMIT ?`
+		queryArgs = append(append([]interface{}{}, args...), limit)
+	}
+
+	rows, err := db.Query(query, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []FacetValue{}
+	for rows.Next() {
+		var v FacetValue
+		if err := rows.Scan(&v.ID, &v.Name, &v.Count); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+func histogramBuckets(db *sql.DB, matched, column string, bucketSize int, args []interface{}) ([]HistogramBucket, error) {
+	rows, err := db.Query(matched+`
+	SELECT (`+column+` / ?) * ? AS bucket, COUNT(*)
+	FROM matched
+	GROUP BY bucket
+	ORDER BY bucket`, append(append([]interface{}{}, args...), bucketSize, bucketSize)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buckets := []HistogramBucket{}
+	for rows.Next() {
+		var b HistogramBucket
+		if err := rows.Scan(&b.Min, &b.Count); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+	return buckets, rows.Err()
+}
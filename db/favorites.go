@@ -4,52 +4,52 @@ import (
 	"database/sql"
 )
 
-func AddFavoriteTag(database *sql.DB, tagID int64) error {
-	_, err := database.Exec(`INSERT OR IGNORE INTO favorite_tags (tag_id) VALUES (?)`, tagID)
+func AddFavoriteTag(database *sql.DB, userID, tagID int64) error {
+	_, err := database.Exec(`INSERT OR IGNORE INTO favorite_tags (user_id, tag_id) VALUES (?, ?)`, userID, tagID)
 	return err
 }
 
-func RemoveFavoriteTag(database *sql.DB, tagID int64) error {
-	_, err := database.Exec(`DELETE FROM favorite_tags WHERE tag_id = ?`, tagID)
+func RemoveFavoriteTag(database *sql.DB, userID, tagID int64) error {
+	_, err := database.Exec(`DELETE FROM favorite_tags WHERE user_id = ? AND tag_id = ?`, userID, tagID)
 	return err
 }
 
-func AddFavoriteArtist(database *sql.DB, artistID int64) error {
-	_, err := database.Exec(`INSERT OR IGNORE INTO favorite_artists (artist_id) VALUES (?)`, artistID)
+func AddFavoriteArtist(database *sql.DB, userID, artistID int64) error {
+	_, err := database.Exec(`INSERT OR IGNORE INTO favorite_artists (user_id, artist_id) VALUES (?, ?)`, userID, artistID)
 	return err
 }
 
-func RemoveFavoriteArtist(database *sql.DB, artistID int64) error {
-	_, err := database.Exec(`DELETE FROM favorite_artists WHERE artist_id = ?`, artistID)
+func RemoveFavoriteArtist(database *sql.DB, userID, artistID int64) error {
+	_, err := database.Exec(`DELETE FROM favorite_artists WHERE user_id = ? AND artist_id = ?`, userID, artistID)
 	return err
 }
 
-func AddFavoriteGroup(database *sql.DB, groupID int64) error {
-	_, err := database.Exec(`INSERT OR IGNORE INTO favorite_groups (group_id) VALUES (?)`, groupID)
+func AddFavoriteGroup(database *sql.DB, userID, groupID int64) error {
+	_, err := database.Exec(`INSERT OR IGNORE INTO favorite_groups (user_id, group_id) VALUES (?, ?)`, userID, groupID)
 	return err
 }
 
-func RemoveFavoriteGroup(database *sql.DB, groupID int64) error {
-	_, err := database.Exec(`DELETE FROM favorite_groups WHERE group_id = ?`, groupID)
+func RemoveFavoriteGroup(database *sql.DB, userID, groupID int64) error {
+	_, err := database.Exec(`DELETE FROM favorite_groups WHERE user_id = ? AND group_id = ?`, userID, groupID)
 	return err
 }
 
-func AddFavoriteLanguage(database *sql.DB, languageID int64) error {
-	_, err := database.Exec(`INSERT OR IGNORE INTO favorite_languages (language_id) VALUES (?)`, languageID)
+func AddFavoriteLanguage(database *sql.DB, userID, languageID int64) error {
+	_, err := database.Exec(`INSERT OR IGNORE INTO favorite_languages (user_id, language_id) VALUES (?, ?)`, userID, languageID)
 	return err
 }
 
-func RemoveFavoriteLanguage(database *sql.DB, languageID int64) error {
-	_, err := database.Exec(`DELETE FROM favorite_languages WHERE language_id = ?`, languageID)
+func RemoveFavoriteLanguage(database *sql.DB, userID, languageID int64) error {
+	_, err := database.Exec(`DELETE FROM favorite_languages WHERE user_id = ? AND language_id = ?`, userID, languageID)
 	return err
 }
 
-func AddFavoriteCategory(database *sql.DB, categoryID int64) error {
-	_, err := database.Exec(`INSERT OR IGNORE INTO favorite_categories (category_id) VALUES (?)`, categoryID)
+func AddFavoriteCategory(database *sql.DB, userID, categoryID int64) error {
+	_, err := database.Exec(`INSERT OR IGNORE INTO favorite_categories (user_id, category_id) VALUES (?, ?)`, userID, categoryID)
 	return err
 }
 
-func RemoveFavoriteCategory(database *sql.DB, categoryID int64) error {
-	_, err := database.Exec(`DELETE FROM favorite_categories WHERE category_id = ?`, categoryID)
+func RemoveFavoriteCategory(database *sql.DB, userID, categoryID int64) error {
+	_, err := database.Exec(`DELETE FROM favorite_categories WHERE user_id = ? AND category_id = ?`, userID, categoryID)
 	return err
 }
@@ -7,21 +7,70 @@ import (
 	"time"
 )
 
+// Saved filter kinds. Rows saved before image filters existed are doujinshi
+// filters.
+const (
+	SavedFilterDoujinshi = "doujinshi"
+	SavedFilterImages    = "images"
+)
+
 type SavedFilter struct {
-	ID        int64               `json:"id"`
-	Name      string              `json:"name"`
-	Filters   types.BrowseFilters `json:"filters"`
-	CreatedAt time.Time           `json:"createdAt"`
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Kind says whether Filters or ImageFilters holds the filter.
+	Kind         string              `json:"kind"`
+	Filters      types.BrowseFilters `json:"filters"`
+	ImageFilters *types.ImageFilters `json:"imageFilters,omitempty"`
+	CreatedAt    time.Time           `json:"createdAt"`
+	// Count is the number of doujinshi or images currently matching the
+	// filter, nil when the stored filter can't be evaluated.
+	Count *int `json:"count"`
+}
+
+func (sf SavedFilter) filtersJSON() (string, error) {
+	var filtersJSON []byte
+	var err error
+	if sf.Kind == SavedFilterImages {
+		filtersJSON, err = json.Marshal(sf.ImageFilters)
+	} else {
+		filtersJSON, err = json.Marshal(sf.Filters)
+	}
+	return string(filtersJSON), err
+}
+
+func (sf *SavedFilter) scanFilters(filtersJSON string) error {
+	if sf.Kind == SavedFilterImages {
+		sf.ImageFilters = &types.ImageFilters{}
+		return json.Unmarshal([]byte(filtersJSON), sf.ImageFilters)
+	}
+	return json.Unmarshal([]byte(filtersJSON), &sf.Filters)
+}
+
+// CountMatches runs the filter and returns how many items match it for the
+// user.
+func (sf SavedFilter) CountMatches(db *sql.DB, userID int64) (int, error) {
+	if sf.Kind == SavedFilterImages {
+		return CountImages(db, userID, *sf.ImageFilters)
+	}
+	return CountDoujinshi(db, userID, sf.Filters)
+}
+
+func normalizeKind(kind string) string {
+	if kind == "" {
+		return SavedFilterDoujinshi
+	}
+	return kind
 }
 
-func CreateSavedFilter(db *sql.DB, name string, filters types.BrowseFilters) (int64, error) {
-	filtersJSON, err := json.Marshal(filters)
+func CreateSavedFilter(db *sql.DB, userID int64, sf SavedFilter) (int64, error) {
+	sf.Kind = normalizeKind(sf.Kind)
+	filtersJSON, err := sf.filtersJSON()
 	if err != nil {
 		return 0, err
 	}
 
-	query := `INSERT INTO saved_filters (name, filters_json) VALUES (?, ?)`
-	result, err := db.Exec(query, name, string(filtersJSON))
+	query := `INSERT INTO saved_filters (user_id, name, filters_json, kind, updated_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	result, err := db.Exec(query, userID, sf.Name, filtersJSON, sf.Kind)
 	if err != nil {
 		return 0, err
 	}
@@ -29,9 +78,9 @@ func CreateSavedFilter(db *sql.DB, name string, filters types.BrowseFilters) (in
 	return result.LastInsertId()
 }
 
-func GetAllSavedFilters(db *sql.DB) ([]SavedFilter, error) {
-	query := `SELECT id, name, filters_json, created_at FROM saved_filters ORDER BY name ASC`
-	rows, err := db.Query(query)
+func GetAllSavedFilters(db *sql.DB, userID int64) ([]SavedFilter, error) {
+	query := `SELECT id, name, kind, filters_json, created_at FROM saved_filters WHERE user_id = ? ORDER BY name ASC`
+	rows, err := db.Query(query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -42,33 +91,64 @@ func GetAllSavedFilters(db *sql.DB) ([]SavedFilter, error) {
 		var sf SavedFilter
 		var filtersJSON string
 
-		if err := rows.Scan(&sf.ID, &sf.Name, &filtersJSON, &sf.CreatedAt); err != nil {
+		if err := rows.Scan(&sf.ID, &sf.Name, &sf.Kind, &filtersJSON, &sf.CreatedAt); err != nil {
 			return nil, err
 		}
 
-		if err := json.Unmarshal([]byte(filtersJSON), &sf.Filters); err != nil {
+		if err := sf.scanFilters(filtersJSON); err != nil {
 			continue
 		}
 
 		savedFilters = append(savedFilters, sf)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range savedFilters {
+		if count, err := savedFilters[i].CountMatches(db, userID); err == nil {
+			savedFilters[i].Count = &count
+		}
+	}
 
 	return savedFilters, nil
 }
 
-func UpdateSavedFilter(db *sql.DB, id int64, name string, filters types.BrowseFilters) error {
-	filtersJSON, err := json.Marshal(filters)
+// GetSavedFilter returns sql.ErrNoRows when the user has no filter with that
+// ID.
+func GetSavedFilter(db *sql.DB, userID, id int64) (SavedFilter, error) {
+	var sf SavedFilter
+	var filtersJSON string
+
+	query := `SELECT id, name, kind, filters_json, created_at FROM saved_filters WHERE id = ? AND user_id = ?`
+	err := db.QueryRow(query, id, userID).Scan(&sf.ID, &sf.Name, &sf.Kind, &filtersJSON, &sf.CreatedAt)
+	if err != nil {
+		return sf, err
+	}
+
+	if err := sf.scanFilters(filtersJSON); err != nil {
+		return sf, err
+	}
+	return sf, nil
+}
+
+// UpdateSavedFilter replaces the name, kind and filters of the user's filter
+// with sf.ID.
+func UpdateSavedFilter(db *sql.DB, userID int64, sf SavedFilter) error {
+	sf.Kind = normalizeKind(sf.Kind)
+	filtersJSON, err := sf.filtersJSON()
 	if err != nil {
 		return err
 	}
 
-	query := `UPDATE saved_filters SET name = ?, filters_json = ? WHERE id = ?`
-	_, err = db.Exec(query, name, string(filtersJSON), id)
+	query := `UPDATE saved_filters SET name = ?, filters_json = ?, kind = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ?`
+	_, err = db.Exec(query, sf.Name, filtersJSON, sf.Kind, sf.ID, userID)
 	return err
 }
 
-func DeleteSavedFilter(db *sql.DB, id int64) error {
-	query := `DELETE FROM saved_filters WHERE id = ?`
-	_, err := db.Exec(query, id)
+func DeleteSavedFilter(db *sql.DB, userID, id int64) error {
+	query := `DELETE FROM saved_filters WHERE id = ? AND user_id = ?`
+	_, err := db.Exec(query, id, userID)
 	return err
 }
@@ -0,0 +1,401 @@
+package db
+
+import (
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The global search scores names and titles in Go so it can forgive typos:
+// every query word must match a word of the text exactly, as a prefix, as a
+// substring or within a small edit distance, and the score is the average of
+// how well each word matched. Only the candidates found in SQL are scored,
+// see candidatePrefix.
+
+// globalSearchCandidates is the most rows of each kind scored in Go.
+const globalSearchCandidates = 200
+
+// MaxGlobalSearchLimit is the most results of each kind a search returns.
+const MaxGlobalSearchLimit = 50
+
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// allowedEdits grows with the word length, short words must match exactly.
+func allowedEdits(word []rune) int {
+	switch {
+	case len(word) <= 3:
+		return 0
+	case len(word) <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance is the Damerau-Levenshtein (optimal string alignment)
+// distance between a and b.
+func editDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(b)]
+}
+
+func wordScore(query, word string) float64 {
+	switch {
+	case word == query:
+		return 1
+	case strings.HasPrefix(word, query):
+		return 0.9
+	case len(query) >= 3 && strings.Contains(word, query):
+		return 0.7
+	}
+
+	q, w := []rune(query), []rune(word)
+	edits := allowedEdits(q)
+	if edits == 0 {
+		return 0
+	}
+	if d := editDistance(q, w); d <= edits {
+		return 0.65 - 0.15*float64(d-1)
+	}
+	// a typo in a prefix the user is still typing
+	if len(w) > len(q) {
+		if d := editDistance(q, w[:len(q)]); d <= edits {
+			return 0.55 - 0.15*float64(d-1)
+		}
+	}
+	return 0
+}
+
+// matchScore returns 0 when text doesn't match every query word, otherwise a
+// score where 1 is an exact match of every word.
+func matchScore(query []string, text string) float64 {
+	words := searchWords(text)
+	if len(words) == 0 || len(query) == 0 {
+		return 0
+	}
+
+	var total float64
+	for _, q := range query {
+		var best float64
+		for _, w := range words {
+			if s := wordScore(q, w); s > best {
+				best = s
+			}
+		}
+		if best == 0 {
+			// "bigbreasts" should still find "big breasts"
+			return 0.9 * wordScore(strings.Join(query, ""), strings.Join(words, ""))
+		}
+		total += best
+	}
+	score := total / float64(len(query))
+
+	// prefer texts that are entirely the query over longer ones containing it
+	if len(words) == len(query) {
+		score += 0.05
+	}
+	return score
+}
+
+type scoredID struct {
+	id    int64
+	name  string
+	score float64
+}
+
+// candidatePrefix is what of a query word has to appear in a candidate. Words
+// long enough to have typos only need their first two letters, a typo after
+// them is still found.
+func candidatePrefix(word string) string {
+	if r := []rune(word); allowedEdits(r) > 0 {
+		return string(r[:2])
+	}
+	return word
+}
+
+// likeCandidates requires the prefix of every word in one of the columns.
+func likeCandidates(words []string, columns ...string) (string, []interface{}) {
+	prefixes := make([]string, len(words))
+	for i, w := range words {
+		prefixes[i] = candidatePrefix(w)
+	}
+	return substringConditions(prefixes, columns...)
+}
+
+// candidateOrder sorts the rows containing the most whole query words first,
+// so the best candidates are kept when there are too many.
+func candidateOrder(words []string, columns ...string) (string, []interface{}) {
+	var terms []string
+	var args []interface{}
+	for _, w := range words {
+		cond, condArgs := substringConditions([]string{w}, columns...)
+		terms = append(terms, cond)
+		args = append(args, condArgs...)
+	}
+	return `ORDER BY (` + strings.Join(terms, " + ") + `) DESC LIMIT ` + strconv.Itoa(globalSearchCandidates), args
+}
+
+// doujinshiCandidates finds the titles through the FTS index when it is
+// available. Words with CJK characters aren't split into tokens by it and
+// are looked for with LIKE, see isCJK.
+func doujinshiCandidates(words []string) (string, []interface{}) {
+	var terms, likeWords []string
+	for _, w := range words {
+		if searchIndexAvailable && strings.IndexFunc(w, isCJK) < 0 {
+			terms = append(terms, `"`+candidatePrefix(w)+`"*`)
+		} else {
+			likeWords = append(likeWords, w)
+		}
+	}
+
+	var conds []string
+	var args []interface{}
+	if len(terms) > 0 {
+		conds = append(conds, `id IN (SELECT rowid FROM doujinshi_fts WHERE doujinshi_fts MATCH ?)`)
+		args = append(args, `{title second_title} : (`+strings.Join(terms, " ")+`)`)
+	}
+	if len(likeWords) > 0 {
+		cond, condArgs := likeCandidates(likeWords, "title", "second_title")
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// topMatches scores the rows of a query selecting (id, text, alternative
+// text), keeping the better of the two scores, and returns the best limit
+// matches. The query can use the user's visibility conditions.
+func topMatches(db *sql.DB, userID int64, query string, args []interface{}, words []string, limit int) ([]scoredID, error) {
+	rows, err := db.Query(withUser+query, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []scoredID
+	for rows.Next() {
+		var id int64
+		var text, altText string
+		if err := rows.Scan(&id, &text, &altText); err != nil {
+			return nil, err
+		}
+		score := matchScore(words, text)
+		if alt := matchScore(words, altText); alt > score {
+			score = alt
+		}
+		if score > 0 {
+			matches = append(matches, scoredID{id: id, name: text, score: score})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].id < matches[j].id
+	})
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+type EntityMatch struct {
+	ID          int64   `json:"id"`
+	Name        string  `json:"name"`
+	DoujinCount int     `json:"doujinCount"`
+	ImageCount  int     `json:"imageCount"`
+	Score       float64 `json:"score"`
+}
+
+type ScoredDoujinshi struct {
+	Doujinshi
+	Score float64 `json:"score"`
+}
+
+type ScoredImage struct {
+	Image
+	Score float64 `json:"score"`
+}
+
+type GlobalSearchResults struct {
+	Doujinshi  []ScoredDoujinshi `json:"doujinshi"`
+	Images     []ScoredImage     `json:"images"`
+	Artists    []EntityMatch     `json:"artists"`
+	Tags       []EntityMatch     `json:"tags"`
+	Characters []EntityMatch     `json:"characters"`
+	Parodies   []EntityMatch     `json:"parodies"`
+	Groups     []EntityMatch     `json:"groups"`
+}
+
+// GlobalSearch finds synced doujinshi by title, images by filename and
+// entities by name, returning at most limit results of each kind ordered by
+// score. Doujinshi and images come with the user's progress.
+func GlobalSearch(db *sql.DB, userID int64, text string, limit int) (GlobalSearchResults, error) {
+	results := GlobalSearchResults{
+		Doujinshi:  []ScoredDoujinshi{},
+		Images:     []ScoredImage{},
+		Artists:    []EntityMatch{},
+		Tags:       []EntityMatch{},
+		Characters: []EntityMatch{},
+		Parodies:   []EntityMatch{},
+		Groups:     []EntityMatch{},
+	}
+	words := searchWords(text)
+	if len(words) == 0 {
+		return results, nil
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+	limit = min(limit, MaxGlobalSearchLimit)
+
+	cond, args := doujinshiCandidates(words)
+	order, orderArgs := candidateOrder(words, "title", "second_title")
+	doujinshiMatches, err := topMatches(db, userID, `
+		SELECT id, COALESCE(title, ''), COALESCE(second_title, '')
+		FROM doujinshi WHERE folder_name IS NOT NULL AND folder_name != ''
+		AND `+doujinshiVisible("id")+` AND `+cond+` `+order, append(args, orderArgs...), words, limit)
+	if err != nil {
+		return results, err
+	}
+	doujinshiList, err := getDoujinshiByIDs(db, userID, scoredIDs(doujinshiMatches))
+	if err != nil {
+		return results, err
+	}
+	scores := matchScores(doujinshiMatches)
+	for _, d := range doujinshiList {
+		results.Doujinshi = append(results.Doujinshi, ScoredDoujinshi{Doujinshi: d, Score: scores[d.ID]})
+	}
+
+	cond, args = likeCandidates(words, "filename")
+	order, orderArgs = candidateOrder(words, "filename")
+	imageMatches, err := topMatches(db, userID, `SELECT id, filename, '' FROM images
+		WHERE `+imageVisible("id")+` AND `+cond+` `+order, append(args, orderArgs...), words, limit)
+	if err != nil {
+		return results, err
+	}
+	images, err := getImagesByIDs(db, userID, scoredIDs(imageMatches))
+	if err != nil {
+		return results, err
+	}
+	scores = matchScores(imageMatches)
+	for _, img := range images {
+		results.Images = append(results.Images, ScoredImage{Image: img, Score: scores[img.ID]})
+	}
+
+	entities := []struct {
+		doujinshi entityRelation
+		images    entityRelation
+		matches   *[]EntityMatch
+	}{
+		{doujinshiArtists, imageArtists, &results.Artists},
+		{doujinshiTags, imageTags, &results.Tags},
+		{doujinshiCharacters, imageCharacters, &results.Characters},
+		{doujinshiParodies, imageParodies, &results.Parodies},
+		{doujinshiGroups, imageGroups, &results.Groups},
+	}
+	cond, args = likeCandidates(words, "name")
+	order, orderArgs = candidateOrder(words, "name")
+	args = append(args, orderArgs...)
+	for _, e := range entities {
+		matches, err := topMatches(db, userID, `SELECT id, name, '' FROM `+e.doujinshi.entityTable+`
+			WHERE `+entityVisible(e.doujinshi.entityTable, "id")+` AND `+cond+` `+order, args, words, limit)
+		if err != nil {
+			return results, err
+		}
+		if *e.matches, err = entityCounts(db, userID, e.doujinshi, e.images, matches); err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+func scoredIDs(matches []scoredID) []int64 {
+	ids := make([]int64, len(matches))
+	for i, m := range matches {
+		ids[i] = m.id
+	}
+	return ids
+}
+
+func matchScores(matches []scoredID) map[int64]float64 {
+	scores := make(map[int64]float64, len(matches))
+	for _, m := range matches {
+		scores[m.id] = m.score
+	}
+	return scores
+}
+
+// entityCounts adds the synced doujinshi and image counts the user can see to
+// the matches.
+func entityCounts(db *sql.DB, userID int64, doujinshi, images entityRelation, matches []scoredID) ([]EntityMatch, error) {
+	result := []EntityMatch{}
+	if len(matches) == 0 {
+		return result, nil
+	}
+
+	rows, err := db.Query(withUser+`
+	SELECT
+		ids.value,
+		(SELECT COUNT(*) FROM `+doujinshi.joinTable+` j
+			JOIN doujinshi d ON d.id = j.doujinshi_id
+			WHERE j.`+doujinshi.entityIDCol+` = ids.value
+			AND d.folder_name IS NOT NULL AND d.folder_name != ''
+			AND `+doujinshiVisible("d.id")+`),
+		(SELECT COUNT(*) FROM `+images.joinTable+` j WHERE j.`+images.entityIDCol+` = ids.value
+			AND `+imageVisible("j.image_id")+`)
+	FROM json_each(?) ids`, userID, idsJSON(scoredIDs(matches)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[int64][2]int)
+	for rows.Next() {
+		var id int64
+		var c [2]int
+		if err := rows.Scan(&id, &c[0], &c[1]); err != nil {
+			return nil, err
+		}
+		counts[id] = c
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, m := range matches {
+		c := counts[m.id]
+		result = append(result, EntityMatch{ID: m.id, Name: m.name, DoujinCount: c[0], ImageCount: c[1], Score: m.score})
+	}
+	return result, nil
+}
@@ -15,10 +15,10 @@ type GroupData struct {
 	ImageCount    int      `json:"imageCount"`
 }
 
-func GetAllGroups(db *sql.DB) ([]GroupData, error) {
+func GetAllGroups(db *sql.DB, userID int64) ([]GroupData, error) {
 	favoriteGroupsSet := make(map[int64]bool)
-	favQuery := `SELECT group_id FROM favorite_groups`
-	favRows, err := db.Query(favQuery)
+	favQuery := `SELECT group_id FROM favorite_groups WHERE user_id = ?`
+	favRows, err := db.Query(favQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -35,7 +35,7 @@ func GetAllGroups(db *sql.DB) ([]GroupData, error) {
 		return nil, err
 	}
 
-	mainQuery := `
+	mainQuery := withUser + `
 	SELECT
 		g.id,
 		g.name,
@@ -48,25 +48,28 @@ func GetAllGroups(db *sql.DB) ([]GroupData, error) {
 	LEFT JOIN
 		doujinshi_groups dg ON g.id = dg.group_id
 	LEFT JOIN
-		doujinshi d ON dg.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != ''
+		doujinshi d ON dg.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != '' AND ` + doujinshiVisible("d.id") + `
 	LEFT JOIN
 		(SELECT
 			 po.doujinshi_id,
 			 SUM(po.o_count) AS total_o_for_doujin
 		 FROM doujinshi_page_o po
+		 WHERE po.user_id = ` + currentUser + `
 		 GROUP BY po.doujinshi_id
 		) d_ocount ON d.id = d_ocount.doujinshi_id
 	LEFT JOIN
-		doujinshi_progress dp ON d.id = dp.doujinshi_id
+		doujinshi_progress dp ON d.id = dp.doujinshi_id AND dp.user_id = ` + currentUser + `
 	LEFT JOIN
-		image_groups ig ON g.id = ig.group_id
+		image_groups ig ON g.id = ig.group_id AND ` + imageVisible("ig.image_id") + `
+	WHERE
+		` + entityVisible("groups", "g.id") + `
 	GROUP BY
 		g.id, g.name
 	ORDER BY
 		g.name ASC;
 	`
 
-	allRows, err := db.Query(mainQuery)
+	allRows, err := db.Query(mainQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -109,17 +112,17 @@ func GetAllGroups(db *sql.DB) ([]GroupData, error) {
 	return results, nil
 }
 
-func GetGroupDetails(db *sql.DB, groupID int64) (*GroupData, error) {
+func GetGroupDetails(db *sql.DB, userID, groupID int64) (*GroupData, error) {
 	var isFavorite bool
 	err := db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM favorite_groups WHERE group_id = ?)`,
-		groupID,
+		`SELECT EXISTS(SELECT 1 FROM favorite_groups WHERE user_id = ? AND group_id = ?)`,
+		userID, groupID,
 	).Scan(&isFavorite)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 
-	query := `
+	query := withUser + `
 	SELECT
 		g.id,
 		g.name,
@@ -131,25 +134,26 @@ func GetGroupDetails(db *sql.DB, groupID int64) (*GroupData, error) {
 	LEFT JOIN
 		doujinshi_groups dg ON g.id = dg.group_id
 	LEFT JOIN
-		doujinshi d ON dg.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != ''
+		doujinshi d ON dg.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != '' AND ` + doujinshiVisible("d.id") + `
 	LEFT JOIN
 		(SELECT
 			 po.doujinshi_id,
 			 SUM(po.o_count) AS total_o_for_doujin
 		 FROM doujinshi_page_o po
+		 WHERE po.user_id = ` + currentUser + `
 		 GROUP BY po.doujinshi_id
 		) d_ocount ON d.id = d_ocount.doujinshi_id
 	LEFT JOIN
-		doujinshi_progress dp ON d.id = dp.doujinshi_id
+		doujinshi_progress dp ON d.id = dp.doujinshi_id AND dp.user_id = ` + currentUser + `
 	WHERE
-		g.id = ?
+		g.id = ? AND ` + entityVisible("groups", "g.id") + `
 	GROUP BY
 		g.id, g.name;
 	`
 	var groupInfo GroupData
 	var avgRating sql.NullFloat64
 
-	err = db.QueryRow(query, groupID).Scan(
+	err = db.QueryRow(query, userID, groupID).Scan(
 		&groupInfo.ID,
 		&groupInfo.Name,
 		&groupInfo.DoujinCount,
@@ -171,15 +175,16 @@ func GetGroupDetails(db *sql.DB, groupID int64) (*GroupData, error) {
 	return &groupInfo, nil
 }
 
-func GetDoujinshiByGroup(db *sql.DB, groupID int64) ([]Doujinshi, error) {
-	query := `
-		SELECT d.id, d.source, d.external_id, d.title, d.pages, d.uploaded, d.folder_name
+func GetDoujinshiByGroup(db *sql.DB, userID, groupID int64) ([]Doujinshi, error) {
+	query := withUser + `
+		SELECT d.id, d.source, d.external_id, d.title, d.pages, d.uploaded, d.folder_name, d.library_root
 		FROM doujinshi d
 		JOIN doujinshi_groups dg ON d.id = dg.doujinshi_id
 		WHERE dg.group_id = ? AND d.folder_name IS NOT NULL AND d.folder_name != ''
+			AND ` + doujinshiVisible("d.id") + `
 		ORDER BY d.uploaded DESC, d.title ASC
 	`
-	rows, err := db.Query(query, groupID)
+	rows, err := db.Query(query, userID, groupID)
 	if err != nil {
 		return nil, err
 	}
@@ -189,13 +194,19 @@ func GetDoujinshiByGroup(db *sql.DB, groupID int64) ([]Doujinshi, error) {
 	for rows.Next() {
 		var d Doujinshi
 		if err := rows.Scan(&d.ID, &d.Source, &d.ExternalID, &d.Title, &d.Pages,
-			&d.Uploaded, &d.FolderName); err != nil {
+			&d.Uploaded, &d.FolderName, &d.LibraryRoot); err != nil {
 			return nil, err
 		}
 
-		populateDoujinshiDetails(db, &d)
 		results = append(results, d)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateDoujinshi(db, userID, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
 
@@ -212,17 +223,17 @@ func GetGroupIDByName(db *sql.DB, groupName string) (int64, error) {
 	return groupID, nil
 }
 
-func GetImagesByGroup(db *sql.DB, groupID int64) ([]Image, error) {
-	query := `
+func GetImagesByGroup(db *sql.DB, userID, groupID int64) ([]Image, error) {
+	query := withUser + `
 		SELECT i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
 			i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
 			COALESCE(i.hash, '') as hash
 		FROM images i
 		JOIN image_groups ig ON i.id = ig.image_id
-		WHERE ig.group_id = ?
+		WHERE ig.group_id = ? AND ` + imageVisible("i.id") + `
 		ORDER BY i.uploaded DESC
 	`
-	rows, err := db.Query(query, groupID)
+	rows, err := db.Query(query, userID, groupID)
 	if err != nil {
 		return nil, err
 	}
@@ -236,8 +247,14 @@ func GetImagesByGroup(db *sql.DB, groupID int64) ([]Image, error) {
 			&img.Format, &img.Uploaded, &img.Hash); err != nil {
 			return nil, err
 		}
-		populateImageDetails(db, &img)
 		results = append(results, img)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateImages(db, userID, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
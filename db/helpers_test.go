@@ -0,0 +1,50 @@
+package db
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestDB creates a migrated database in a temp dir. The default admin is
+// user 1.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := InitDB(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+const testUserID = 1
+
+// addTestDoujinshi inserts a synced doujinshi and returns its id.
+func addTestDoujinshi(t *testing.T, db *sql.DB, d Doujinshi) int64 {
+	t.Helper()
+	if d.Source == "" {
+		d.Source = "nhentai"
+	}
+	if d.ExternalID == "" {
+		d.ExternalID = d.Title
+	}
+	if d.Uploaded.IsZero() {
+		d.Uploaded = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	folder := d.FolderName
+	if folder == "" {
+		folder = d.ExternalID
+	}
+	if err := InsertDoujinshiWithMetadata(db, d, folder); err != nil {
+		t.Fatal(err)
+	}
+
+	var id int64
+	err := db.QueryRow(`SELECT id FROM doujinshi WHERE source = ? AND external_id = ?`, d.Source, d.ExternalID).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
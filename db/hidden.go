@@ -0,0 +1,167 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrUnknownHidden = errors.New("unknown name in hidden content")
+
+// Hidden content is left out of everything guests see: lists, entity pages,
+// search and similar items. A doujinshi or image is hidden when it is listed
+// itself or has a hidden tag, artist, character, parody or group. The
+// conditions below go into queries starting with withUser and do nothing for
+// other users.
+
+type hiddenEntityKind struct {
+	kind      string
+	doujinshi entityRelation
+	images    entityRelation
+}
+
+var hiddenEntityKinds = []hiddenEntityKind{
+	{"tags", doujinshiTags, imageTags},
+	{"artists", doujinshiArtists, imageArtists},
+	{"characters", doujinshiCharacters, imageCharacters},
+	{"parodies", doujinshiParodies, imageParodies},
+	{"groups", doujinshiGroups, imageGroups},
+}
+
+type HiddenContent struct {
+	Tags       []string `json:"tags"`
+	Artists    []string `json:"artists"`
+	Characters []string `json:"characters"`
+	Parodies   []string `json:"parodies"`
+	Groups     []string `json:"groups"`
+	Doujinshi  []int64  `json:"doujinshi"`
+	Images     []int64  `json:"images"`
+}
+
+func (h *HiddenContent) names(kind string) *[]string {
+	switch kind {
+	case "tags":
+		return &h.Tags
+	case "artists":
+		return &h.Artists
+	case "characters":
+		return &h.Characters
+	case "parodies":
+		return &h.Parodies
+	default:
+		return &h.Groups
+	}
+}
+
+const guestUser = `EXISTS (SELECT 1 FROM users WHERE id = ` + currentUser + ` AND is_guest = 1)`
+
+var hiddenDoujinshiIDs, hiddenImageIDs string
+
+func init() {
+	doujinshi := []string{`SELECT entity_id FROM hidden_content WHERE kind = 'doujinshi'`}
+	images := []string{`SELECT entity_id FROM hidden_content WHERE kind = 'images'`}
+	for _, k := range hiddenEntityKinds {
+		doujinshi = append(doujinshi, `SELECT j.doujinshi_id FROM `+k.doujinshi.joinTable+` j
+			JOIN hidden_content h ON h.kind = '`+k.kind+`' AND h.entity_id = j.`+k.doujinshi.entityIDCol)
+		images = append(images, `SELECT j.image_id FROM `+k.images.joinTable+` j
+			JOIN hidden_content h ON h.kind = '`+k.kind+`' AND h.entity_id = j.`+k.images.entityIDCol)
+	}
+	hiddenDoujinshiIDs = strings.Join(doujinshi, " UNION ")
+	hiddenImageIDs = strings.Join(images, " UNION ")
+}
+
+// doujinshiVisible is true when the current user may see the doujinshi with
+// the ID in idColumn.
+func doujinshiVisible(idColumn string) string {
+	return `NOT (` + guestUser + ` AND ` + idColumn + ` IN (` + hiddenDoujinshiIDs + `))`
+}
+
+func imageVisible(idColumn string) string {
+	return `NOT (` + guestUser + ` AND ` + idColumn + ` IN (` + hiddenImageIDs + `))`
+}
+
+// entityVisible is true when the current user may see the tag, artist, ...
+// of the given kind, named after its table.
+func entityVisible(kind, idColumn string) string {
+	return `NOT (` + guestUser + ` AND ` + idColumn + ` IN (SELECT entity_id FROM hidden_content WHERE kind = '` + kind + `'))`
+}
+
+func GetHiddenContent(db *sql.DB) (HiddenContent, error) {
+	h := HiddenContent{
+		Tags: []string{}, Artists: []string{}, Characters: []string{}, Parodies: []string{}, Groups: []string{},
+		Doujinshi: []int64{}, Images: []int64{},
+	}
+
+	var selects []string
+	for _, k := range hiddenEntityKinds {
+		selects = append(selects, `SELECT '`+k.kind+`', 0, e.name FROM hidden_content h
+			JOIN `+k.doujinshi.entityTable+` e ON e.id = h.entity_id WHERE h.kind = '`+k.kind+`'`)
+	}
+	selects = append(selects, `SELECT kind, entity_id, '' FROM hidden_content WHERE kind IN ('doujinshi', 'images')`)
+
+	rows, err := db.Query(strings.Join(selects, " UNION ALL ") + ` ORDER BY 1, 3, 2`)
+	if err != nil {
+		return h, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind, name string
+		var id int64
+		if err := rows.Scan(&kind, &id, &name); err != nil {
+			return h, err
+		}
+		switch kind {
+		case "doujinshi":
+			h.Doujinshi = append(h.Doujinshi, id)
+		case "images":
+			h.Images = append(h.Images, id)
+		default:
+			names := h.names(kind)
+			*names = append(*names, name)
+		}
+	}
+	return h, rows.Err()
+}
+
+// SetHiddenContent replaces the hidden content. Names must be of existing
+// tags, artists, ..., an error naming the first unknown one is returned
+// otherwise.
+func SetHiddenContent(db *sql.DB, h HiddenContent) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM hidden_content`); err != nil {
+		return err
+	}
+
+	for _, k := range hiddenEntityKinds {
+		for _, name := range *h.names(k.kind) {
+			var id int64
+			err := tx.QueryRow(`SELECT id FROM `+k.doujinshi.entityTable+` WHERE LOWER(name) = LOWER(?)`,
+				strings.TrimSpace(name)).Scan(&id)
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("%w: %s %q", ErrUnknownHidden, k.kind, name)
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO hidden_content (kind, entity_id) VALUES (?, ?)`, k.kind, id); err != nil {
+				return err
+			}
+		}
+	}
+	for kind, ids := range map[string][]int64{"doujinshi": h.Doujinshi, "images": h.Images} {
+		for _, id := range ids {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO hidden_content (kind, entity_id) VALUES (?, ?)`, kind, id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
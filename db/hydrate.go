@@ -0,0 +1,289 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Lists are hydrated with a fixed number of queries no matter how many rows
+// they have: one for every related name and one for counts and progress. IDs
+// are passed as a single JSON array so large pages don't hit SQLite's
+// variable limit.
+
+// The relations each list is hydrated with, in the order their names are
+// copied into the rows.
+var doujinshiHydratedRelations = []entityRelation{
+	doujinshiTags,
+	doujinshiArtists,
+	doujinshiCharacters,
+	doujinshiParodies,
+	doujinshiGroups,
+	doujinshiLanguages,
+	doujinshiCategories,
+}
+
+var imageHydratedRelations = []entityRelation{
+	imageTags,
+	imageArtists,
+	imageCharacters,
+	imageParodies,
+	imageGroups,
+	imageCategories,
+}
+
+func idsJSON(ids []int64) string {
+	encoded, _ := json.Marshal(ids)
+	return string(encoded)
+}
+
+// loadRelatedNames returns, for each relation in order, the names linked to
+// every owner ID.
+func loadRelatedNames(db *sql.DB, relations []entityRelation, ids string) ([]map[int64][]string, error) {
+	var selects []string
+	var args []interface{}
+	for i, r := range relations {
+		selects = append(selects, `
+		SELECT `+strconv.Itoa(i)+` AS relation, j.`+r.ownerCol+` AS owner_id, json_group_array(e.name)
+		FROM `+r.joinTable+` j
+		JOIN `+r.entityTable+` e ON e.id = j.`+r.entityIDCol+`
+		WHERE j.`+r.ownerCol+` IN (SELECT value FROM json_each(?))
+		GROUP BY j.`+r.ownerCol)
+		args = append(args, ids)
+	}
+
+	rows, err := db.Query(strings.Join(selects, " UNION ALL "), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]map[int64][]string, len(relations))
+	for i := range names {
+		names[i] = make(map[int64][]string)
+	}
+	for rows.Next() {
+		var relation int
+		var ownerID int64
+		var encoded string
+		if err := rows.Scan(&relation, &ownerID, &encoded); err != nil {
+			return nil, err
+		}
+		var list []string
+		if err := json.Unmarshal([]byte(encoded), &list); err != nil {
+			return nil, err
+		}
+		names[relation][ownerID] = list
+	}
+	return names, rows.Err()
+}
+
+// hydrateDoujinshi fills in the related names, and the user's o-count,
+// bookmark count and progress, of every doujinshi in the list.
+func hydrateDoujinshi(db *sql.DB, userID int64, list []Doujinshi) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(list))
+	for i, d := range list {
+		ids[i] = d.ID
+	}
+	encodedIDs := idsJSON(ids)
+
+	names, err := loadRelatedNames(db, doujinshiHydratedRelations, encodedIDs)
+	if err != nil {
+		return err
+	}
+
+	type details struct {
+		oCount, bookmarkCount int
+		progress              *DoujinshiProgress
+	}
+	byID := make(map[int64]details, len(list))
+
+	rows, err := db.Query(withUser+`
+	SELECT
+		d.id,
+		`+doujinshiOCountExpr+`,
+		`+doujinshiBookmarkCountExpr+`,
+		p.rating, p.last_page
+	FROM json_each(?) ids
+	JOIN doujinshi d ON d.id = ids.value
+	`+doujinshiProgressJoin, userID, encodedIDs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var det details
+		// progress is always set, with nil fields when nothing is recorded
+		det.progress = &DoujinshiProgress{}
+		if err := rows.Scan(&id, &det.oCount, &det.bookmarkCount, &det.progress.Rating, &det.progress.LastPage); err != nil {
+			return err
+		}
+		det.progress.DoujinshiID = id
+		byID[id] = det
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range list {
+		d := &list[i]
+		d.Tags = names[0][d.ID]
+		d.Artists = names[1][d.ID]
+		d.Characters = names[2][d.ID]
+		d.Parodies = names[3][d.ID]
+		d.Groups = names[4][d.ID]
+		d.Languages = names[5][d.ID]
+		d.Categories = names[6][d.ID]
+
+		det := byID[d.ID]
+		d.OCount = det.oCount
+		d.BookmarkCount = det.bookmarkCount
+		d.Progress = det.progress
+	}
+	return nil
+}
+
+// hydrateImages fills in the related names and the user's progress of every
+// image in the list.
+func hydrateImages(db *sql.DB, userID int64, list []Image) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(list))
+	for i, img := range list {
+		ids[i] = img.ID
+	}
+	encodedIDs := idsJSON(ids)
+
+	names, err := loadRelatedNames(db, imageHydratedRelations, encodedIDs)
+	if err != nil {
+		return err
+	}
+
+	type progress struct{ rating, oCount, viewCount int }
+	byID := make(map[int64]progress, len(list))
+
+	rows, err := db.Query(`
+	SELECT ip.image_id, COALESCE(ip.rating, 0), COALESCE(ip.o_count, 0), COALESCE(ip.view_count, 0)
+	FROM image_progress ip
+	WHERE ip.user_id = ? AND ip.image_id IN (SELECT value FROM json_each(?))
+	`, userID, encodedIDs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var p progress
+		if err := rows.Scan(&id, &p.rating, &p.oCount, &p.viewCount); err != nil {
+			return err
+		}
+		byID[id] = p
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range list {
+		img := &list[i]
+		img.Tags = names[0][img.ID]
+		img.Artists = names[1][img.ID]
+		img.Characters = names[2][img.ID]
+		img.Parodies = names[3][img.ID]
+		img.Groups = names[4][img.ID]
+		img.Categories = names[5][img.ID]
+
+		p := byID[img.ID]
+		img.Rating = p.rating
+		img.OCount = p.oCount
+		img.ViewCount = p.viewCount
+	}
+	return nil
+}
+
+// getDoujinshiByIDs loads and hydrates the doujinshi in the order of ids,
+// skipping IDs that don't exist or are hidden from the user.
+func getDoujinshiByIDs(db *sql.DB, userID int64, ids []int64) ([]Doujinshi, error) {
+	rows, err := db.Query(withUser+`
+	SELECT d.id, d.source, d.external_id, d.title, COALESCE(d.second_title, '') as second_title,
+		d.pages, d.uploaded, d.folder_name, d.library_root
+	FROM doujinshi d
+	WHERE d.id IN (SELECT value FROM json_each(?)) AND `+doujinshiVisible("d.id"), userID, idsJSON(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]Doujinshi, len(ids))
+	for rows.Next() {
+		var d Doujinshi
+		if err := rows.Scan(&d.ID, &d.Source, &d.ExternalID, &d.Title, &d.SecondTitle,
+			&d.Pages, &d.Uploaded, &d.FolderName, &d.LibraryRoot); err != nil {
+			return nil, err
+		}
+		byID[d.ID] = d
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := make([]Doujinshi, 0, len(ids))
+	for _, id := range ids {
+		if d, ok := byID[id]; ok {
+			list = append(list, d)
+		}
+	}
+	if err := hydrateDoujinshi(db, userID, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// getImagesByIDs loads and hydrates the images in the order of ids, skipping
+// IDs that don't exist or are hidden from the user.
+func getImagesByIDs(db *sql.DB, userID int64, ids []int64) ([]Image, error) {
+	rows, err := db.Query(withUser+`
+	SELECT i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
+		i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
+		COALESCE(i.hash, '') as hash
+	FROM images i
+	WHERE i.id IN (SELECT value FROM json_each(?)) AND `+imageVisible("i.id"), userID, idsJSON(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]Image, len(ids))
+	for rows.Next() {
+		var img Image
+		if err := rows.Scan(&img.ID, &img.Source, &img.ExternalID,
+			&img.Filename, &img.FilePath, &img.FileSize, &img.Width, &img.Height,
+			&img.Format, &img.Uploaded, &img.Hash); err != nil {
+			return nil, err
+		}
+		byID[img.ID] = img
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	list := make([]Image, 0, len(ids))
+	for _, id := range ids {
+		if img, ok := byID[id]; ok {
+			list = append(list, img)
+		}
+	}
+	if err := hydrateImages(db, userID, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
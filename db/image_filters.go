@@ -0,0 +1,201 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/brayanMuniz/h_save/types"
+)
+
+// Per-row expressions used by the image query. They expect the images table
+// aliased as i and imageProgressJoin.
+const (
+	imageProgressJoin    = `LEFT JOIN image_progress ip ON ip.image_id = i.id AND ip.user_id = ` + currentUser
+	imageRatingExpr      = `COALESCE(ip.rating, 0)`
+	imageOCountExpr      = `COALESCE(ip.o_count, 0)`
+	imageViewCountExpr   = `COALESCE(ip.view_count, 0)`
+	imageAspectRatioExpr = `(CAST(i.width AS REAL) / NULLIF(i.height, 0))`
+)
+
+var (
+	imageTags       = entityRelation{"tags", "image_tags", "tag_id", "image_id"}
+	imageArtists    = entityRelation{"artists", "image_artists", "artist_id", "image_id"}
+	imageCharacters = entityRelation{"characters", "image_characters", "character_id", "image_id"}
+	imageParodies   = entityRelation{"parodies", "image_parodies", "parody_id", "image_id"}
+	imageGroups     = entityRelation{"groups", "image_groups", "group_id", "image_id"}
+	imageCategories = entityRelation{"categories", "image_categories", "category_id", "image_id"}
+)
+
+func (f *filterClause) addFloatRange(expr string, r types.FloatRangeFilter) {
+	if r.Min > 0 {
+		f.add(expr+` >= ?`, r.Min)
+	}
+	if r.Max > 0 {
+		f.add(expr+` <= ?`, r.Max)
+	}
+}
+
+func buildImageFilter(filters types.ImageFilters) filterClause {
+	var f filterClause
+	f.add(imageVisible("i.id"))
+
+	f.addGroup("i.id", imageArtists, filters.Artists)
+	f.addGroup("i.id", imageGroups, filters.Groups)
+	f.addGroup("i.id", imageTags, filters.Tags)
+	f.addGroup("i.id", imageCharacters, filters.Characters)
+	f.addGroup("i.id", imageParodies, filters.Parodies)
+
+	f.addRange(imageRatingExpr, filters.Rating)
+	f.addRange(imageOCountExpr, filters.OCount)
+	f.addRange(imageViewCountExpr, filters.ViewCount)
+	f.addRange(`COALESCE(i.width, 0)`, filters.Width)
+	f.addRange(`COALESCE(i.height, 0)`, filters.Height)
+	f.addRange(`COALESCE(i.file_size, 0)`, filters.FileSize)
+	f.addFloatRange(imageAspectRatioExpr, filters.AspectRatio)
+
+	if filters.Favorite != nil {
+		if *filters.Favorite {
+			f.add(`i.id IN (SELECT image_id FROM favorite_images WHERE user_id = ` + currentUser + `)`)
+		} else {
+			f.add(`i.id NOT IN (SELECT image_id FROM favorite_images WHERE user_id = ` + currentUser + `)`)
+		}
+	}
+
+	var formats []string
+	for _, format := range nonEmpty(filters.Formats) {
+		// the gallery uses "all" to mean no format filter
+		if strings.EqualFold(format, "all") {
+			formats = nil
+			break
+		}
+		formats = append(formats, strings.ToLower(format))
+		if strings.EqualFold(format, "jpg") || strings.EqualFold(format, "jpeg") {
+			formats = append(formats, "jpg", "jpeg")
+		}
+	}
+	if len(formats) > 0 {
+		f.add(`LOWER(i.format) IN (`+placeholders(len(formats))+`)`, stringArgs(formats)...)
+	}
+
+	return f
+}
+
+var imageSortColumns = map[string]sortColumn{
+	"uploaded":  {`COALESCE(CAST(strftime('%s', i.uploaded) AS INTEGER), 0)`, "desc"},
+	"filename":  {`i.filename COLLATE NOCASE`, "asc"},
+	"rating":    {imageRatingExpr, "desc"},
+	"oCount":    {imageOCountExpr, "desc"},
+	"viewCount": {imageViewCountExpr, "desc"},
+	"fileSize":  {`COALESCE(i.file_size, 0)`, "desc"},
+	"width":     {`COALESCE(i.width, 0)`, "desc"},
+	"height":    {`COALESCE(i.height, 0)`, "desc"},
+}
+
+func init() {
+	imageSortColumns["date"] = imageSortColumns["uploaded"]
+	imageSortColumns["ocount"] = imageSortColumns["oCount"]
+}
+
+type ImagePage struct {
+	Images     []Image `json:"images"`
+	Total      int     `json:"total"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+	Seed       int64   `json:"seed,omitempty"`
+}
+
+// ListImages returns one page of the images matching the filters, along with
+// the total number of matches. Ratings, counts and favorites are the user's.
+func ListImages(db *sql.DB, userID int64, filters types.ImageFilters, opts ListOptions) (ImagePage, error) {
+	var page ImagePage
+
+	opts, err := opts.paged()
+	if err != nil {
+		return page, err
+	}
+	sorting, err := resolveSort(opts, imageSortColumns, "uploaded", "i.id")
+	if err != nil {
+		return page, err
+	}
+	page.Seed = sorting.seed
+
+	clause := buildImageFilter(filters)
+	if page.Total, err = countImages(db, userID, clause); err != nil {
+		return page, err
+	}
+
+	if opts.Cursor != "" {
+		if err := sorting.addCursor(&clause, opts.Cursor, "i.id"); err != nil {
+			return page, err
+		}
+	}
+
+	// fetch one extra row to know if there is a next page
+	pageOpts := opts
+	if pageOpts.Limit > 0 {
+		pageOpts.Limit++
+	}
+	limit := pageOpts.limitOffset()
+
+	rows, err := db.Query(withUser+`
+	SELECT
+		i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
+		i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
+		COALESCE(i.hash, '') as hash,
+		`+sorting.expr+` AS sort_key
+	FROM images i
+	`+imageProgressJoin+`
+	`+clause.where()+`
+	`+sorting.orderBy("i.id")+`
+	`+limit, userArgs(userID, clause.args)...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	var imageList []Image
+	var sortKeys []interface{}
+	for rows.Next() {
+		var img Image
+		var sortKey interface{}
+		err := rows.Scan(
+			&img.ID, &img.Source, &img.ExternalID, &img.Filename, &img.FilePath,
+			&img.FileSize, &img.Width, &img.Height, &img.Format, &img.Uploaded,
+			&img.Hash, &sortKey,
+		)
+		if err != nil {
+			return page, err
+		}
+		imageList = append(imageList, img)
+		sortKeys = append(sortKeys, sortKey)
+	}
+	if err := rows.Err(); err != nil {
+		return page, err
+	}
+
+	if opts.Limit > 0 && len(imageList) > opts.Limit {
+		imageList = imageList[:opts.Limit]
+		last := imageList[len(imageList)-1]
+		page.NextCursor = sorting.cursorAfter(sortKeys[opts.Limit-1], last.ID)
+	}
+
+	if err := hydrateImages(db, userID, imageList); err != nil {
+		return page, err
+	}
+	page.Images = imageList
+	return page, nil
+}
+
+// CountImages returns how many images match the filters for the user.
+func CountImages(db *sql.DB, userID int64, filters types.ImageFilters) (int, error) {
+	return countImages(db, userID, buildImageFilter(filters))
+}
+
+func countImages(db *sql.DB, userID int64, clause filterClause) (int, error) {
+	var total int
+	err := db.QueryRow(withUser+`
+	SELECT COUNT(*)
+	FROM images i
+	`+imageProgressJoin+`
+	`+clause.where(), userArgs(userID, clause.args)...).Scan(&total)
+	return total, err
+}
@@ -12,71 +12,20 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 	"time"
-)
-
-func GetAllImages(db *sql.DB) ([]Image, error) {
-	rows, err := db.Query(`
-	SELECT
-		i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
-		i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
-		COALESCE(i.hash, '') as hash,
-		COALESCE(p.rating, 0) as rating,
-		COALESCE(p.o_count, 0) as o_count,
-		COALESCE(p.view_count, 0) as view_count
-	FROM images i
-	LEFT JOIN image_progress p ON i.id = p.image_id
-	ORDER BY i.uploaded DESC
-	`)
-
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var imageList []Image
-	for rows.Next() {
-		var img Image
-		err := rows.Scan(
-			&img.ID, &img.Source, &img.ExternalID, &img.Filename, &img.FilePath,
-			&img.FileSize, &img.Width, &img.Height, &img.Format, &img.Uploaded,
-			&img.Hash, &img.Rating, &img.OCount, &img.ViewCount,
-		)
-		if err != nil {
-			return nil, err
-		}
-		imageList = append(imageList, img)
-	}
-
-	// Process each image's metadata concurrently
-	results := make([]Image, len(imageList))
-	semaphore := make(chan struct{}, 50)
-
-	var wg sync.WaitGroup
-	for i, img := range imageList {
-		wg.Add(1)
-		go func(index int, image Image) {
-			defer wg.Done()
-			semaphore <- struct{}{}
-			defer func() { <-semaphore }()
-
-			populateImageDetails(db, &image)
-			results[index] = image
-		}(i, img)
-	}
 
-	wg.Wait()
-	return results, nil
-}
+	"github.com/brayanMuniz/h_save/library"
+)
 
-func GetImage(db *sql.DB, id string) (Image, error) {
+// GetImage returns the image with the user's progress, or sql.ErrNoRows when
+// it is hidden from them.
+func GetImage(db *sql.DB, userID int64, id string) (Image, error) {
 	var img Image
-	err := db.QueryRow(`
+	err := db.QueryRow(withUser+`
 		SELECT i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
 			i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
 			COALESCE(i.hash, '') as hash
-		FROM images i WHERE i.id = ?`, id,
+		FROM images i WHERE i.id = ? AND `+imageVisible("i.id"), userID, id,
 	).Scan(&img.ID, &img.Source, &img.ExternalID, &img.Filename, &img.FilePath,
 		&img.FileSize, &img.Width, &img.Height, &img.Format, &img.Uploaded, &img.Hash)
 
@@ -84,101 +33,8 @@ func GetImage(db *sql.DB, id string) (Image, error) {
 		return img, err
 	}
 
-	populateImageDetails(db, &img)
-	return img, nil
-}
-
-func GetSimilarImagesByMetaData(
-	db *sql.DB,
-	excludedImageID string,
-	characters []string,
-	tags []string,
-	parodies []string,
-) ([]Image, error) {
-	var args []interface{}
-	var conditions []string
-
-	if len(characters) > 0 {
-		charPlaceholders := make([]string, len(characters))
-		for i, char := range characters {
-			charPlaceholders[i] = "?"
-			args = append(args, char)
-		}
-		conditions = append(conditions, `
-            i.id IN (
-                SELECT image_id FROM image_characters ic
-                JOIN characters c ON ic.character_id = c.id
-                WHERE c.name IN (`+strings.Join(charPlaceholders, ",")+`)
-            )
-        `)
-	}
-
-	if len(tags) > 0 {
-		tagPlaceholders := make([]string, len(tags))
-		for i, tag := range tags {
-			tagPlaceholders[i] = "?"
-			args = append(args, tag)
-		}
-		conditions = append(conditions, `
-            i.id IN (
-                SELECT image_id FROM image_tags it
-                JOIN tags t ON it.tag_id = t.id
-                WHERE t.name IN (`+strings.Join(tagPlaceholders, ",")+`)
-            )
-        `)
-	}
-
-	if len(parodies) > 0 {
-		parodyPlaceholders := make([]string, len(parodies))
-		for i, parody := range parodies {
-			parodyPlaceholders[i] = "?"
-			args = append(args, parody)
-		}
-		conditions = append(conditions, `
-            i.id IN (
-                SELECT image_id FROM image_parodies ip
-                JOIN parodies p ON ip.parody_id = p.id
-                WHERE p.name IN (`+strings.Join(parodyPlaceholders, ",")+`)
-            )
-        `)
-	}
-
-	var whereClause string
-	if len(conditions) > 0 {
-		whereClause = "(" + strings.Join(conditions, " OR ") + ") AND "
-	} else {
-		return []Image{}, nil
-	}
-
-	args = append(args, excludedImageID)
-
-	query := `
-	SELECT i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
-		i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
-		COALESCE(i.hash, '') as hash
-	FROM images i
-	WHERE ` + whereClause + `i.id != ?
-	ORDER BY i.uploaded DESC
-	`
-
-	rows, err := db.Query(query, args...)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var results []Image
-	for rows.Next() {
-		var img Image
-		if err := rows.Scan(&img.ID, &img.Source, &img.ExternalID,
-			&img.Filename, &img.FilePath, &img.FileSize, &img.Width, &img.Height,
-			&img.Format, &img.Uploaded, &img.Hash); err != nil {
-			return nil, err
-		}
-		populateImageDetails(db, &img)
-		results = append(results, img)
-	}
-	return results, nil
+	err = populateImageDetails(db, userID, &img)
+	return img, err
 }
 
 func ImageExists(db *sql.DB, filePath string) (bool, error) {
@@ -262,92 +118,13 @@ func linkImageManyToMany(tx *sql.Tx, imageID int64, values []string, entityTable
 	return nil
 }
 
-func populateImageDetails(db *sql.DB, img *Image) {
-	// Get progress data
-	err := db.QueryRow(`
-		SELECT COALESCE(rating, 0), COALESCE(o_count, 0), COALESCE(view_count, 0)
-		FROM image_progress WHERE image_id = ?`, img.ID,
-	).Scan(&img.Rating, &img.OCount, &img.ViewCount)
-	if err != nil && err != sql.ErrNoRows {
-		img.Rating = 0
-		img.OCount = 0
-		img.ViewCount = 0
-	}
-
-	type result struct {
-		field string
-		data  []string
-	}
-
-	resultsChan := make(chan result, 6)
-
-	queries := []struct {
-		field       string
-		entityTable string
-		joinTable   string
-		entityIDCol string
-	}{
-		{"tags", "tags", "image_tags", "tag_id"},
-		{"artists", "artists", "image_artists", "artist_id"},
-		{"characters", "characters", "image_characters", "character_id"},
-		{"parodies", "parodies", "image_parodies", "parody_id"},
-		{"groups", "groups", "image_groups", "group_id"},
-		{"categories", "categories", "image_categories", "category_id"},
-	}
-
-	for _, q := range queries {
-		go func(query struct {
-			field       string
-			entityTable string
-			joinTable   string
-			entityIDCol string
-		}) {
-			data, _ := getImageRelatedNames(db, img.ID, query.entityTable, query.joinTable, query.entityIDCol)
-			resultsChan <- result{field: query.field, data: data}
-		}(q)
-	}
-
-	for i := 0; i < len(queries); i++ {
-		res := <-resultsChan
-		switch res.field {
-		case "tags":
-			img.Tags = res.data
-		case "artists":
-			img.Artists = res.data
-		case "characters":
-			img.Characters = res.data
-		case "parodies":
-			img.Parodies = res.data
-		case "groups":
-			img.Groups = res.data
-		case "categories":
-			img.Categories = res.data
-		}
-	}
-}
-
-func getImageRelatedNames(db *sql.DB, imageID int64, entityTable, joinTable, entityIDCol string) ([]string, error) {
-	query := `
-        SELECT e.name
-        FROM ` + entityTable + ` e
-        JOIN ` + joinTable + ` j ON e.id = j.` + entityIDCol + `
-        WHERE j.image_id = ?
-    `
-	rows, err := db.Query(query, imageID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var names []string
-	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
-			return nil, err
-		}
-		names = append(names, name)
+func populateImageDetails(db *sql.DB, userID int64, img *Image) error {
+	list := []Image{*img}
+	if err := hydrateImages(db, userID, list); err != nil {
+		return err
 	}
-	return names, nil
+	*img = list[0]
+	return nil
 }
 
 type ScanResult struct {
@@ -357,14 +134,16 @@ type ScanResult struct {
 	Errors       []string `json:"errors"`
 }
 
-func ScanImagesFolder(db *sql.DB, folderPath string) (ScanResult, error) {
+// ScanImagesFolder adds the new images in folderPath, a real path inside
+// root. Files leading out of the root through symlinks are skipped.
+func ScanImagesFolder(db *sql.DB, root *library.Root, folderPath string) (ScanResult, error) {
 	result := ScanResult{
 		Errors: make([]string, 0),
 	}
 
-	err := filepath.Walk(folderPath, func(path string, info os.FileInfo, err error) error {
+	err := filepath.Walk(folderPath, func(realPath string, info os.FileInfo, err error) error {
 		if err != nil {
-			result.Errors = append(result.Errors, fmt.Sprintf("Error accessing %s: %v", path, err))
+			result.Errors = append(result.Errors, fmt.Sprintf("Error accessing %s: %v", realPath, err))
 			return nil // Continue walking
 		}
 
@@ -378,6 +157,24 @@ func ScanImagesFolder(db *sql.DB, folderPath string) (ScanResult, error) {
 			return nil
 		}
 
+		if info.Mode()&os.ModeSymlink != 0 {
+			if _, err := root.Confine(realPath); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("Skipped %s: %v", realPath, err))
+				return nil
+			}
+			if info, err = os.Stat(realPath); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("Error accessing %s: %v", realPath, err))
+				return nil
+			}
+		}
+
+		// paths are stored starting with the root folder, as configured
+		path, err := root.StoredPath(realPath)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Skipped %s: %v", realPath, err))
+			return nil
+		}
+
 		result.TotalScanned++
 
 		// Check if image already exists by file path
@@ -753,13 +550,13 @@ func RemoveImageGroups(db *sql.DB, imageID int64, groups []string) error {
 	return err
 }
 
-func GetImageByFilePath(db *sql.DB, filePath string) (Image, error) {
+func GetImageByFilePath(db *sql.DB, userID int64, filePath string) (Image, error) {
 	var img Image
-	err := db.QueryRow(`
+	err := db.QueryRow(withUser+`
 		SELECT i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
 			i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
 			COALESCE(i.hash, '') as hash
-		FROM images i WHERE i.file_path = ?`, filePath,
+		FROM images i WHERE i.file_path = ? AND `+imageVisible("i.id"), userID, filePath,
 	).Scan(&img.ID, &img.Source, &img.ExternalID, &img.Filename, &img.FilePath,
 		&img.FileSize, &img.Width, &img.Height, &img.Format, &img.Uploaded, &img.Hash)
 
@@ -767,6 +564,6 @@ func GetImageByFilePath(db *sql.DB, filePath string) (Image, error) {
 		return img, err
 	}
 
-	populateImageDetails(db, &img)
-	return img, nil
+	err = populateImageDetails(db, userID, &img)
+	return img, err
 }
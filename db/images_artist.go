@@ -13,10 +13,10 @@ type ImageArtistData struct {
 	AverageRating *float64 `json:"averageRating"`
 }
 
-func GetAllImageArtists(db *sql.DB) ([]ImageArtistData, error) {
+func GetAllImageArtists(db *sql.DB, userID int64) ([]ImageArtistData, error) {
 	favoriteArtistsSet := make(map[int64]bool)
-	favQuery := `SELECT artist_id FROM favorite_artists`
-	favRows, err := db.Query(favQuery)
+	favQuery := `SELECT artist_id FROM favorite_artists WHERE user_id = ?`
+	favRows, err := db.Query(favQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +30,7 @@ func GetAllImageArtists(db *sql.DB) ([]ImageArtistData, error) {
 		favoriteArtistsSet[favID] = true
 	}
 
-	mainQuery := `
+	mainQuery := withUser + `
 	SELECT
 		a.id, 
 		a.name,
@@ -42,16 +42,18 @@ func GetAllImageArtists(db *sql.DB) ([]ImageArtistData, error) {
 	LEFT JOIN
 		image_artists ia ON a.id = ia.artist_id
 	LEFT JOIN
-		images i ON ia.image_id = i.id
+		images i ON ia.image_id = i.id AND ` + imageVisible("i.id") + `
 	LEFT JOIN
-		image_progress ip ON i.id = ip.image_id
+		image_progress ip ON i.id = ip.image_id AND ip.user_id = ` + currentUser + `
+	WHERE
+		` + entityVisible("artists", "a.id") + `
 	GROUP BY
 		a.id, a.name
 	ORDER BY
 		a.name ASC;
 	`
 
-	rows, err := db.Query(mainQuery)
+	rows, err := db.Query(mainQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -88,17 +90,17 @@ func GetAllImageArtists(db *sql.DB) ([]ImageArtistData, error) {
 	return results, nil
 }
 
-func GetImagesByArtist(db *sql.DB, artistID int64) ([]Image, error) {
-	query := `
+func GetImagesByArtist(db *sql.DB, userID, artistID int64) ([]Image, error) {
+	query := withUser + `
 		SELECT i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
 			i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
 			COALESCE(i.hash, '') as hash
 		FROM images i
 		JOIN image_artists ia ON i.id = ia.image_id
-		WHERE ia.artist_id = ?
+		WHERE ia.artist_id = ? AND ` + imageVisible("i.id") + `
 		ORDER BY i.uploaded DESC
 	`
-	rows, err := db.Query(query, artistID)
+	rows, err := db.Query(query, userID, artistID)
 	if err != nil {
 		return nil, err
 	}
@@ -112,8 +114,14 @@ func GetImagesByArtist(db *sql.DB, artistID int64) ([]Image, error) {
 			&img.Format, &img.Uploaded, &img.Hash); err != nil {
 			return nil, err
 		}
-		populateImageDetails(db, &img)
 		results = append(results, img)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateImages(db, userID, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
@@ -5,33 +5,34 @@ import (
 	"time"
 )
 
-func AddFavoriteImage(db *sql.DB, imageID int64) error {
-	_, err := db.Exec(`INSERT OR IGNORE INTO favorite_images (image_id, added_at) VALUES (?, ?)`,
-		imageID, time.Now())
+func AddFavoriteImage(db *sql.DB, userID, imageID int64) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO favorite_images (user_id, image_id, added_at) VALUES (?, ?, ?)`,
+		userID, imageID, time.Now())
 	return err
 }
 
-func RemoveFavoriteImage(db *sql.DB, imageID int64) error {
-	_, err := db.Exec(`DELETE FROM favorite_images WHERE image_id = ?`, imageID)
+func RemoveFavoriteImage(db *sql.DB, userID, imageID int64) error {
+	_, err := db.Exec(`DELETE FROM favorite_images WHERE user_id = ? AND image_id = ?`, userID, imageID)
 	return err
 }
 
-func IsImageFavorited(db *sql.DB, imageID int64) (bool, error) {
+func IsImageFavorited(db *sql.DB, userID, imageID int64) (bool, error) {
 	var exists bool
-	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM favorite_images WHERE image_id = ?)`,
-		imageID).Scan(&exists)
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM favorite_images WHERE user_id = ? AND image_id = ?)`,
+		userID, imageID).Scan(&exists)
 	return exists, err
 }
 
-func GetFavoriteImages(db *sql.DB) ([]Image, error) {
+func GetFavoriteImages(db *sql.DB, userID int64) ([]Image, error) {
 	rows, err := db.Query(`
 		SELECT i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
 			i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
 			COALESCE(i.hash, '') as hash
 		FROM images i
 		JOIN favorite_images fi ON i.id = fi.image_id
+		WHERE fi.user_id = ?
 		ORDER BY fi.added_at DESC
-	`)
+	`, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -45,8 +46,14 @@ func GetFavoriteImages(db *sql.DB) ([]Image, error) {
 			&img.Format, &img.Uploaded, &img.Hash); err != nil {
 			return nil, err
 		}
-		populateImageDetails(db, &img)
 		results = append(results, img)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateImages(db, userID, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
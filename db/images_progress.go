@@ -4,44 +4,43 @@ import (
 	"database/sql"
 )
 
-func UpdateImageRating(db *sql.DB, imageID int64, rating int) error {
+func UpdateImageRating(db *sql.DB, userID, imageID int64, rating int) error {
 	_, err := db.Exec(`
-		INSERT OR REPLACE INTO image_progress (image_id, rating, o_count, view_count, last_viewed)
-		VALUES (?, ?, COALESCE((SELECT o_count FROM image_progress WHERE image_id = ?), 0),
-		        COALESCE((SELECT view_count FROM image_progress WHERE image_id = ?), 0),
-		        COALESCE((SELECT last_viewed FROM image_progress WHERE image_id = ?), datetime('now')))
-	`, imageID, rating, imageID, imageID, imageID)
+		INSERT INTO image_progress (user_id, image_id, rating, o_count, view_count, last_viewed, updated_at)
+		VALUES (?, ?, ?, 0, 0, datetime('now'), CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, image_id) DO UPDATE SET rating = excluded.rating, updated_at = excluded.updated_at
+	`, userID, imageID, rating)
 	return err
 }
 
-func UpdateImageOCount(db *sql.DB, imageID int64, oCount int) error {
+func UpdateImageOCount(db *sql.DB, userID, imageID int64, oCount int) error {
 	_, err := db.Exec(`
-		INSERT OR REPLACE INTO image_progress (image_id, o_count, rating, view_count, last_viewed)
-		VALUES (?, ?, COALESCE((SELECT rating FROM image_progress WHERE image_id = ?), 0),
-		        COALESCE((SELECT view_count FROM image_progress WHERE image_id = ?), 0),
-		        COALESCE((SELECT last_viewed FROM image_progress WHERE image_id = ?), datetime('now')))
-	`, imageID, oCount, imageID, imageID, imageID)
+		INSERT INTO image_progress (user_id, image_id, o_count, rating, view_count, last_viewed, updated_at)
+		VALUES (?, ?, ?, 0, 0, datetime('now'), CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, image_id) DO UPDATE SET o_count = excluded.o_count, updated_at = excluded.updated_at
+	`, userID, imageID, oCount)
 	return err
 }
 
-func IncrementImageViewCount(db *sql.DB, imageID int64) error {
+func IncrementImageViewCount(db *sql.DB, userID, imageID int64) error {
 	_, err := db.Exec(`
-		INSERT OR REPLACE INTO image_progress (image_id, view_count, last_viewed, rating, o_count)
-		VALUES (?, COALESCE((SELECT view_count FROM image_progress WHERE image_id = ?), 0) + 1,
-		        datetime('now'),
-		        COALESCE((SELECT rating FROM image_progress WHERE image_id = ?), 0),
-		        COALESCE((SELECT o_count FROM image_progress WHERE image_id = ?), 0))
-	`, imageID, imageID, imageID, imageID)
+		INSERT INTO image_progress (user_id, image_id, view_count, last_viewed, rating, o_count, updated_at)
+		VALUES (?, ?, 1, datetime('now'), 0, 0, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, image_id) DO UPDATE SET
+			view_count = COALESCE(view_count, 0) + 1,
+			last_viewed = excluded.last_viewed,
+			updated_at = excluded.updated_at
+	`, userID, imageID)
 	return err
 }
 
-func GetImageProgress(db *sql.DB, imageID int64) (*ImageProgress, error) {
+func GetImageProgress(db *sql.DB, userID, imageID int64) (*ImageProgress, error) {
 	var progress ImageProgress
 	err := db.QueryRow(`
 		SELECT image_id, COALESCE(rating, 0), COALESCE(o_count, 0), 
 		       COALESCE(view_count, 0), last_viewed
-		FROM image_progress WHERE image_id = ?
-	`, imageID).Scan(&progress.ImageID, &progress.Rating, &progress.OCount,
+		FROM image_progress WHERE user_id = ? AND image_id = ?
+	`, userID, imageID).Scan(&progress.ImageID, &progress.Rating, &progress.OCount,
 		&progress.ViewCount, &progress.LastViewed)
 
 	if err == sql.ErrNoRows {
@@ -2,335 +2,212 @@ package db
 
 import (
 	"database/sql"
-	"log"
+	"fmt"
+	"strings"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+// connectionParams are set on every connection of the pool: foreign keys
+// are enforced so the ON DELETE CASCADEs fire, WAL lets reads go on while
+// something is written, and a busy database is waited on instead of failing
+// right away. Transactions take the write lock when they start, as two
+// deferred ones upgrading to writers at once would fail despite the timeout.
+const connectionParams = "_foreign_keys=on&_journal_mode=WAL&_busy_timeout=5000&_txlock=immediate"
+
+// InitDB opens the database and migrates it to the latest schema.
 func InitDB(filepath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", filepath)
+	db, err := sql.Open("sqlite3", filepath+"?"+connectionParams)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := createDoujinshiAndMetadataTables(db); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := createUserAndProgressTables(db); err != nil {
-		log.Fatal(err)
-	}
-
-	if err := createImageAndMetadataTables(db); err != nil {
-		log.Fatal(err)
+	if err := setupDB(db, filepath); err != nil {
+		db.Close()
+		return nil, err
 	}
+	return db, nil
+}
 
-	if err := createImageProgressTables(db); err != nil {
-		log.Fatal(err)
+// OpenUnmigrated opens the database without migrating it, to look at one
+// that may be damaged. With readOnly nothing can be written to it.
+func OpenUnmigrated(filepath string, readOnly bool) (*sql.DB, error) {
+	if readOnly {
+		return openReadOnly(filepath)
 	}
-
-	// Set default password
-	var count int
-	err = db.QueryRow(`SELECT COUNT(*) FROM user`).Scan(&count)
+	db, err := sql.Open("sqlite3", filepath+"?"+connectionParams)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	if count == 0 {
-		hash, err := bcrypt.GenerateFromPassword([]byte("ecchi"), bcrypt.DefaultCost)
-		if err != nil {
-			log.Fatal(err)
-		}
-		_, err = db.Exec(`INSERT INTO user (id, password_hash) VALUES (1, ?)`, string(hash))
-		if err != nil {
-			log.Fatal(err)
-		}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
 	}
-
 	return db, nil
 }
 
-func createDoujinshiAndMetadataTables(db *sql.DB) error {
-	_, err := db.Exec(`
-	CREATE TABLE IF NOT EXISTS doujinshi (
-	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-	    source TEXT NOT NULL,
-	    external_id TEXT NOT NULL,
-	    title TEXT,
-	    second_title TEXT,
-	    pages TEXT,
-	    uploaded DATETIME,
-	    folder_name TEXT,
-	    UNIQUE(source, external_id)
-	);
-
-	CREATE TABLE IF NOT EXISTS tags (
-	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-	    name TEXT UNIQUE
-	);
-	CREATE TABLE IF NOT EXISTS doujinshi_tags (
-	    doujinshi_id INTEGER,
-	    tag_id INTEGER,
-	    PRIMARY KEY (doujinshi_id, tag_id),
-	    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
-	    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS artists (
-	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-	    name TEXT UNIQUE
-	);
-	CREATE TABLE IF NOT EXISTS doujinshi_artists (
-	    doujinshi_id INTEGER,
-	    artist_id INTEGER,
-	    PRIMARY KEY (doujinshi_id, artist_id),
-	    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
-	    FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS characters (
-	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-	    name TEXT UNIQUE
-	);
-	CREATE TABLE IF NOT EXISTS doujinshi_characters (
-	    doujinshi_id INTEGER,
-	    character_id INTEGER,
-	    PRIMARY KEY (doujinshi_id, character_id),
-	    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
-	    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS parodies (
-	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-	    name TEXT UNIQUE
-	);
-	CREATE TABLE IF NOT EXISTS doujinshi_parodies (
-	    doujinshi_id INTEGER,
-	    parody_id INTEGER,
-	    PRIMARY KEY (doujinshi_id, parody_id),
-	    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
-	    FOREIGN KEY (parody_id) REFERENCES parodies(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS groups (
-	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-	    name TEXT UNIQUE
-	);
-	CREATE TABLE IF NOT EXISTS doujinshi_groups (
-	    doujinshi_id INTEGER,
-	    group_id INTEGER,
-	    PRIMARY KEY (doujinshi_id, group_id),
-	    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
-	    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS languages (
-	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-	    name TEXT UNIQUE
-	);
-	CREATE TABLE IF NOT EXISTS doujinshi_languages (
-	    doujinshi_id INTEGER,
-	    language_id INTEGER,
-	    PRIMARY KEY (doujinshi_id, language_id),
-	    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
-	    FOREIGN KEY (language_id) REFERENCES languages(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS categories (
-	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-	    name TEXT UNIQUE
-	);
-	CREATE TABLE IF NOT EXISTS doujinshi_categories (
-	    doujinshi_id INTEGER,
-	    category_id INTEGER,
-	    PRIMARY KEY (doujinshi_id, category_id),
-	    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
-	    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
-	);
-	`)
-	return err
+func setupDB(db *sql.DB, filepath string) error {
+	if err := migrate(db, filepath); err != nil {
+		return err
+	}
+	// the index depends on how SQLite was built, so it isn't a migration
+	if err := createSearchIndex(db); err != nil {
+		return fmt.Errorf("creating the search index: %w", err)
+	}
+	if err := ensureDefaultUser(db); err != nil {
+		return fmt.Errorf("creating the default user: %w", err)
+	}
+	return nil
 }
 
-func createUserAndProgressTables(db *sql.DB) error {
-	_, err := db.Exec(`
-	CREATE TABLE IF NOT EXISTS user (
-	    id INTEGER PRIMARY KEY CHECK (id = 1),
-	    password_hash TEXT NOT NULL
-	);
-
-	CREATE TABLE IF NOT EXISTS favorite_tags (
-	    tag_id INTEGER PRIMARY KEY,
-	    FOREIGN KEY (tag_id) REFERENCES tags(id)
-	);
-	CREATE TABLE IF NOT EXISTS favorite_artists (
-	    artist_id INTEGER PRIMARY KEY,
-	    FOREIGN KEY (artist_id) REFERENCES artists(id)
-	);
-	CREATE TABLE IF NOT EXISTS favorite_characters (
-	    character_id INTEGER PRIMARY KEY,
-	    FOREIGN KEY (character_id) REFERENCES characters(id)
-	);
-	CREATE TABLE IF NOT EXISTS favorite_parodies (
-	    parody_id INTEGER PRIMARY KEY,
-	    FOREIGN KEY (parody_id) REFERENCES parodies(id)
-	);
-	CREATE TABLE IF NOT EXISTS favorite_groups (
-	    group_id INTEGER PRIMARY KEY,
-	    FOREIGN KEY (group_id) REFERENCES groups(id)
-	);
-	CREATE TABLE IF NOT EXISTS favorite_languages (
-	    language_id INTEGER PRIMARY KEY,
-	    FOREIGN KEY (language_id) REFERENCES languages(id)
-	);
-	CREATE TABLE IF NOT EXISTS favorite_categories (
-	    category_id INTEGER PRIMARY KEY,
-	    FOREIGN KEY (category_id) REFERENCES categories(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS doujinshi_progress (
-	    doujinshi_id INTEGER PRIMARY KEY,
-	    rating INTEGER,
-	    last_page INTEGER,
-	    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id)
-	);
-
-	CREATE TABLE IF NOT EXISTS doujinshi_page_o (
-	    doujinshi_id INTEGER,
-	    filename TEXT NOT NULL,
-	    o_count INTEGER DEFAULT 0,
-	    PRIMARY KEY (doujinshi_id, filename),
-	    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id)
-	);
+// perUserTables hold rows owned by a user, they are cleared when the user is
+// deleted.
+var perUserTables = []string{
+	"favorite_tags", "favorite_artists", "favorite_characters", "favorite_parodies",
+	"favorite_groups", "favorite_languages", "favorite_categories",
+	"doujinshi_progress", "doujinshi_page_o", "doujinshi_bookmarks", "saved_filters",
+	"image_progress", "favorite_images", "sessions", "api_tokens",
+	"provider_credentials",
+}
 
-	CREATE TABLE IF NOT EXISTS doujinshi_bookmarks (
-	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-	    doujinshi_id INTEGER NOT NULL,
-	    filename TEXT NOT NULL,
-	    name TEXT,
-	    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-	    UNIQUE(doujinshi_id, filename),
-	    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id)
-	);
+// upgradeUnversioned brings a database created before migrations up to the
+// baseline, adding what older versions lacked.
+func upgradeUnversioned(tx *sql.Tx) error {
+	if err := migrateToUsers(tx); err != nil {
+		return err
+	}
+	if err := ensureColumn(tx, "doujinshi", "library_root", "TEXT NOT NULL DEFAULT 'doujinshi'"); err != nil {
+		return err
+	}
+	return ensureColumn(tx, "users", "is_guest", "INTEGER NOT NULL DEFAULT 0")
+}
 
-	CREATE TABLE IF NOT EXISTS saved_filters (
-	    id INTEGER PRIMARY KEY AUTOINCREMENT,
-	    name TEXT NOT NULL UNIQUE,
-	    filters_json TEXT NOT NULL,
-	    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
+// ensureColumn adds a column to a table created by an older version. Missing
+// tables are left to the baseline.
+func ensureColumn(tx *sql.Tx, table, column, definition string) error {
+	columns, err := tableColumns(tx, table)
+	if err != nil || len(columns) == 0 {
+		return err
+	}
+	for _, name := range columns {
+		if name == column {
+			return nil
+		}
+	}
 
-`)
+	_, err = tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + definition)
 	return err
 }
 
-func createImageAndMetadataTables(db *sql.DB) error {
-	_, err := db.Exec(`
-    CREATE TABLE IF NOT EXISTS images (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        source TEXT, -- nullable for local imports
-        external_id TEXT, -- nullable for local imports
-        filename TEXT NOT NULL,
-        file_path TEXT NOT NULL UNIQUE,
-        file_size INTEGER,
-        width INTEGER,
-        height INTEGER,
-        format TEXT, -- jpg, png, webp, etc.
-        uploaded DATETIME DEFAULT CURRENT_TIMESTAMP,
-        hash TEXT, -- for duplicate detection
-        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-    );
-
-    CREATE TABLE IF NOT EXISTS image_tags (
-        image_id INTEGER,
-        tag_id INTEGER,
-        PRIMARY KEY (image_id, tag_id),
-        FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
-        FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
-    );
-
-    CREATE TABLE IF NOT EXISTS image_artists (
-        image_id INTEGER,
-        artist_id INTEGER,
-        PRIMARY KEY (image_id, artist_id),
-        FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
-        FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE
-    );
-
-    CREATE TABLE IF NOT EXISTS image_characters (
-        image_id INTEGER,
-        character_id INTEGER,
-        PRIMARY KEY (image_id, character_id),
-        FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
-        FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE
-    );
+// migrateToUsers upgrades a database from the single user era, when there was
+// one row in user and no user_id columns. The per-user tables are rebuilt
+// with every existing row given to that user, who becomes the admin.
+func migrateToUsers(tx *sql.Tx) error {
+	var legacyUser bool
+	err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'user')`).Scan(&legacyUser)
+	if err != nil || !legacyUser {
+		return err
+	}
 
-    CREATE TABLE IF NOT EXISTS image_parodies (
-        image_id INTEGER,
-        parody_id INTEGER,
-        PRIMARY KEY (image_id, parody_id),
-        FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
-        FOREIGN KEY (parody_id) REFERENCES parodies(id) ON DELETE CASCADE
-    );
+	// move the old tables out of the way and create the new ones
+	var legacyTables []string
+	for _, table := range perUserTables {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)`, table).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if _, err := tx.Exec(`ALTER TABLE ` + table + ` RENAME TO legacy_` + table); err != nil {
+			return err
+		}
+		legacyTables = append(legacyTables, table)
+	}
+	if _, err := tx.Exec(migrations[0].sql); err != nil {
+		return err
+	}
 
-    CREATE TABLE IF NOT EXISTS image_groups (
-        image_id INTEGER,
-        group_id INTEGER,
-        PRIMARY KEY (image_id, group_id),
-        FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
-        FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE
-    );
+	if _, err := tx.Exec(`
+		INSERT INTO users (id, username, password_hash, is_admin)
+		SELECT id, 'admin', password_hash, 1 FROM user
+	`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DROP TABLE user`); err != nil {
+		return err
+	}
 
-    CREATE TABLE IF NOT EXISTS image_categories (
-        image_id INTEGER,
-        category_id INTEGER,
-        PRIMARY KEY (image_id, category_id),
-        FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
-        FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
-    );
-    `)
-	return err
+	for _, table := range legacyTables {
+		columns, err := tableColumns(tx, "legacy_"+table)
+		if err != nil {
+			return err
+		}
+		list := strings.Join(columns, ", ")
+		_, err = tx.Exec(`INSERT INTO ` + table + ` (user_id, ` + list + `)
+			SELECT (SELECT MIN(id) FROM users), ` + list + ` FROM legacy_` + table)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DROP TABLE legacy_` + table); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func createImageProgressTables(db *sql.DB) error {
-	_, err := db.Exec(`
-    CREATE TABLE IF NOT EXISTS image_progress (
-        image_id INTEGER PRIMARY KEY,
-        rating INTEGER,
-        o_count INTEGER DEFAULT 0,
-        view_count INTEGER DEFAULT 0,
-        last_viewed DATETIME,
-        FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE
-    );
-
-    CREATE TABLE IF NOT EXISTS favorite_images (
-        image_id INTEGER PRIMARY KEY,
-        added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-        FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE
-    );
+func tableColumns(tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-    CREATE TABLE IF NOT EXISTS image_collections (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        name TEXT NOT NULL,
-        description TEXT,
-        created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-    );
+	var columns []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns = append(columns, name)
+	}
+	return columns, rows.Err()
+}
 
-    CREATE TABLE IF NOT EXISTS image_collection_items (
-        collection_id INTEGER,
-        image_id INTEGER,
-        order_index INTEGER DEFAULT 0,
-        added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-        PRIMARY KEY (collection_id, image_id),
-        FOREIGN KEY (collection_id) REFERENCES image_collections(id) ON DELETE CASCADE,
-        FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE
-    );
+// ensureDefaultUser creates the admin with the default password on the first
+// run, and flags users still using it so the password gets changed.
+func ensureDefaultUser(db *sql.DB) error {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		hash, err := bcrypt.GenerateFromPassword([]byte(DefaultPassword), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec(`
+			INSERT INTO users (username, password_hash, must_change_password, is_admin)
+			VALUES (?, ?, 1, 1)
+		`, DefaultUsername, string(hash))
+		return err
+	}
 
-    CREATE TABLE IF NOT EXISTS collection_bookmarks (
-        id INTEGER PRIMARY KEY AUTOINCREMENT,
-        collection_id INTEGER NOT NULL,
-        name TEXT,
-        created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-        FOREIGN KEY (collection_id) REFERENCES image_collections(id) ON DELETE CASCADE
-    );
-    `)
-	return err
+	users, err := ListUsers(db)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		isDefault, err := CheckPassword(db, u.ID, DefaultPassword)
+		if err != nil {
+			return err
+		}
+		if isDefault {
+			if _, err := db.Exec(`UPDATE users SET must_change_password = 1 WHERE id = ?`, u.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
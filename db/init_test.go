@@ -0,0 +1,188 @@
+package db
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// createLegacyDB writes a database that was never migrated, built by setup,
+// and returns its path.
+func createLegacyDB(t *testing.T, setup func(db *sql.DB) error) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := setup(db); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func execAll(db *sql.DB, statements ...string) error {
+	for _, s := range statements {
+		if _, err := db.Exec(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// singleUserDB creates the schema from testdata/baseline.sql, with a
+// password of "secret" and one row in every table the users split up.
+func singleUserDB(db *sql.DB) error {
+	schema, err := os.ReadFile(filepath.Join("testdata", "baseline.sql"))
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	if err := execAll(db, string(schema)); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`INSERT INTO user (id, password_hash) VALUES (1, ?)`, string(hash)); err != nil {
+		return err
+	}
+	return execAll(db,
+		`INSERT INTO doujinshi (id, source, external_id, title, pages, folder_name) VALUES (1, 'nhentai', '1', 'old', '20', 'old')`,
+		`INSERT INTO tags (id, name) VALUES (1, 'vanilla')`,
+		`INSERT INTO doujinshi_tags (doujinshi_id, tag_id) VALUES (1, 1)`,
+		`INSERT INTO artists (id, name) VALUES (1, 'someone')`,
+		`INSERT INTO favorite_tags (tag_id) VALUES (1)`,
+		`INSERT INTO favorite_artists (artist_id) VALUES (1)`,
+		`INSERT INTO doujinshi_progress (doujinshi_id, rating, last_page) VALUES (1, 4, 12)`,
+		`INSERT INTO doujinshi_page_o (doujinshi_id, filename, o_count) VALUES (1, '01.jpg', 2)`,
+		`INSERT INTO doujinshi_bookmarks (doujinshi_id, filename, name) VALUES (1, '02.jpg', 'page')`,
+		`INSERT INTO saved_filters (name, filters_json) VALUES ('mine', '{}')`,
+		`INSERT INTO images (id, filename, file_path, hash) VALUES (1, 'a.jpg', 'a.jpg', 'abc')`,
+		`INSERT INTO image_progress (image_id, rating, o_count, view_count) VALUES (1, 5, 1, 3)`,
+		`INSERT INTO favorite_images (image_id) VALUES (1)`,
+	)
+}
+
+// usersWithoutLaterColumnsDB creates the first schema with users, without
+// the columns that were added to it before migrations existed.
+func usersWithoutLaterColumnsDB(db *sql.DB) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	if err := execAll(db, migrations[0].sql,
+		`ALTER TABLE doujinshi DROP COLUMN library_root`,
+		`ALTER TABLE users DROP COLUMN is_guest`,
+	); err != nil {
+		return err
+	}
+	_, err = db.Exec(`INSERT INTO users (id, username, password_hash, is_admin) VALUES (1, 'admin', ?, 1)`, string(hash))
+	if err != nil {
+		return err
+	}
+	return execAll(db,
+		`INSERT INTO doujinshi (id, source, external_id, title, pages, folder_name) VALUES (1, 'nhentai', '1', 'old', '20', 'old')`,
+		`INSERT INTO doujinshi_progress (user_id, doujinshi_id, rating, last_page) VALUES (1, 1, 4, 12)`,
+	)
+}
+
+func TestUpgradeUnversioned(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(db *sql.DB) error
+		// wantRows is the number of rows of user 1 in each per-user table
+		wantRows map[string]int
+	}{
+		{
+			"single user",
+			singleUserDB,
+			map[string]int{
+				"favorite_tags": 1, "favorite_artists": 1, "favorite_characters": 0,
+				"doujinshi_progress": 1, "doujinshi_page_o": 1, "doujinshi_bookmarks": 1,
+				"saved_filters": 1, "image_progress": 1, "favorite_images": 1,
+			},
+		},
+		{
+			"users without later columns",
+			usersWithoutLaterColumnsDB,
+			map[string]int{"favorite_tags": 0, "doujinshi_progress": 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := createLegacyDB(t, tt.setup)
+			db, err := InitDB(path)
+			if err != nil {
+				t.Fatalf("InitDB() error = %v", err)
+			}
+			defer db.Close()
+
+			var version int
+			if err := db.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version); err != nil {
+				t.Fatal(err)
+			}
+			if version != len(migrations) {
+				t.Errorf("schema version = %d, want %d", version, len(migrations))
+			}
+
+			var leftovers int
+			err = db.QueryRow(`SELECT COUNT(*) FROM sqlite_master
+				WHERE type = 'table' AND (name = 'user' OR name LIKE 'legacy_%')`).Scan(&leftovers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if leftovers != 0 {
+				t.Errorf("%d old tables are left", leftovers)
+			}
+
+			var username string
+			var isAdmin, isGuest bool
+			err = db.QueryRow(`SELECT username, is_admin, is_guest FROM users WHERE id = 1`).Scan(&username, &isAdmin, &isGuest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if username != "admin" || !isAdmin || isGuest {
+				t.Errorf("user 1 = %s, admin %v, guest %v, want an admin called admin", username, isAdmin, isGuest)
+			}
+			if ok, err := CheckPassword(db, 1, "secret"); err != nil || !ok {
+				t.Errorf("CheckPassword(secret) = %v, %v, want the old password to still work", ok, err)
+			}
+
+			for table, want := range tt.wantRows {
+				var got int
+				if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table + ` WHERE user_id = 1`).Scan(&got); err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Errorf("%s has %d rows of user 1, want %d", table, got, want)
+				}
+			}
+
+			var root string
+			var rating, lastPage int
+			err = db.QueryRow(`SELECT d.library_root, p.rating, p.last_page FROM doujinshi d
+				JOIN doujinshi_progress p ON p.doujinshi_id = d.id AND p.user_id = 1
+				WHERE d.id = 1`).Scan(&root, &rating, &lastPage)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if root != "doujinshi" || rating != 4 || lastPage != 12 {
+				t.Errorf("doujinshi 1 = root %q, rating %d, page %d, want doujinshi, 4, 12", root, rating, lastPage)
+			}
+
+			backups, err := filepath.Glob(path + ".pre-v0-*.bak")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != 1 {
+				t.Errorf("backups before migrating = %v, want one", backups)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrCorrupt is returned when repairing a database that integrity_check
+// found damaged, deleting rows could make it worse. Restore a backup instead.
+var ErrCorrupt = errors.New("the database file is damaged, restore a backup instead of repairing it")
+
+// IsMissingReference tells whether err comes from a write pointing at a
+// doujinshi, image, tag, ... that doesn't exist.
+func IsMissingReference(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintForeignKey
+}
+
+type IntegrityReport struct {
+	OK bool `json:"ok"`
+	// Corruption is what PRAGMA integrity_check found wrong with the file
+	Corruption []string `json:"corruption"`
+	// Orphans are rows pointing at a deleted doujinshi, image, user, tag, ...
+	Orphans []OrphanedRows `json:"orphans"`
+	// Repaired is the number of orphaned rows deleted
+	Repaired int `json:"repaired"`
+}
+
+type OrphanedRows struct {
+	Table string `json:"table"`
+	// Parent is the table the rows point at
+	Parent string `json:"parent"`
+	Count  int    `json:"count"`
+
+	rowIDs []int64
+}
+
+// CheckIntegrity runs PRAGMA integrity_check and looks for orphaned rows:
+// the ones failing PRAGMA foreign_key_check, left from before foreign keys
+// were enforced, and hidden content whose tag, doujinshi, ... is gone. With
+// repair the orphans are deleted and the report describes the database
+// before that.
+func CheckIntegrity(db *sql.DB, repair bool) (IntegrityReport, error) {
+	report := IntegrityReport{Corruption: []string{}, Orphans: []OrphanedRows{}}
+
+	rows, err := db.Query(`PRAGMA integrity_check(100)`)
+	if err != nil {
+		return report, err
+	}
+	for rows.Next() {
+		var problem string
+		if err := rows.Scan(&problem); err != nil {
+			rows.Close()
+			return report, err
+		}
+		if problem != "ok" {
+			report.Corruption = append(report.Corruption, problem)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	if report.Orphans, err = foreignKeyOrphans(db); err != nil {
+		return report, err
+	}
+	hidden, err := hiddenContentOrphans(db)
+	if err != nil {
+		return report, err
+	}
+	report.Orphans = append(report.Orphans, hidden...)
+	report.OK = len(report.Corruption) == 0 && len(report.Orphans) == 0
+
+	if !repair || len(report.Orphans) == 0 {
+		return report, nil
+	}
+	if len(report.Corruption) > 0 {
+		return report, ErrCorrupt
+	}
+	report.Repaired, err = deleteOrphans(db, report.Orphans)
+	return report, err
+}
+
+func foreignKeyOrphans(db *sql.DB) ([]OrphanedRows, error) {
+	rows, err := db.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	byTable := make(map[[2]string]*OrphanedRows)
+	for rows.Next() {
+		var table, parent string
+		var rowID sql.NullInt64
+		var fkID int
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return nil, err
+		}
+		key := [2]string{table, parent}
+		o, ok := byTable[key]
+		if !ok {
+			o = &OrphanedRows{Table: table, Parent: parent}
+			byTable[key] = o
+		}
+		// a row can miss several parents, it is counted for each
+		o.Count++
+		if rowID.Valid {
+			o.rowIDs = append(o.rowIDs, rowID.Int64)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	orphans := []OrphanedRows{}
+	for _, o := range byTable {
+		orphans = append(orphans, *o)
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].Table != orphans[j].Table {
+			return orphans[i].Table < orphans[j].Table
+		}
+		return orphans[i].Parent < orphans[j].Parent
+	})
+	return orphans, nil
+}
+
+// hiddenContentOrphans finds none in a database from before hidden content,
+// check doesn't migrate it.
+func hiddenContentOrphans(db *sql.DB) ([]OrphanedRows, error) {
+	var exists bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'hidden_content')`).Scan(&exists)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	parents := []struct{ kind, table string }{{"doujinshi", "doujinshi"}, {"images", "images"}}
+	for _, k := range hiddenEntityKinds {
+		parents = append(parents, struct{ kind, table string }{k.kind, k.doujinshi.entityTable})
+	}
+
+	var orphans []OrphanedRows
+	for _, p := range parents {
+		rows, err := db.Query(`SELECT rowid FROM hidden_content
+			WHERE kind = ? AND entity_id NOT IN (SELECT id FROM `+p.table+`)`, p.kind)
+		if err != nil {
+			return nil, err
+		}
+		o := OrphanedRows{Table: "hidden_content", Parent: p.table}
+		for rows.Next() {
+			var rowID int64
+			if err := rows.Scan(&rowID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			o.rowIDs = append(o.rowIDs, rowID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if o.Count = len(o.rowIDs); o.Count > 0 {
+			orphans = append(orphans, o)
+		}
+	}
+	return orphans, nil
+}
+
+func deleteOrphans(db *sql.DB, orphans []OrphanedRows) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	deleted := 0
+	for _, o := range orphans {
+		for _, rowID := range o.rowIDs {
+			// the table names come from the schema, not from the request
+			result, err := tx.Exec(`DELETE FROM "`+o.Table+`" WHERE rowid = ?`, rowID)
+			if err != nil {
+				return 0, err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return 0, err
+			}
+			deleted += int(n)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
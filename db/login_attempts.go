@@ -0,0 +1,195 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Login attempts are both the audit trail and the state of the rate limit,
+// so lockouts survive restarts. Once an IP has failed IPThreshold times in a
+// row it is locked out for BaseLockout, doubling with every further failure
+// up to MaxLockout. The same happens to every IP when all of them together
+// failed GlobalThreshold times in a row, which stops guessing spread over
+// many addresses. Failures older than Window are forgotten.
+
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+	// LoginLocked is a login rejected without checking the password. It
+	// doesn't extend the lockout.
+	LoginLocked = "locked"
+	// loginPending is an attempt whose password is being checked
+	loginPending = "pending"
+
+	// loginAttemptRetention is how long the audit trail goes back
+	loginAttemptRetention = 90 * 24 * time.Hour
+)
+
+type LoginLimits struct {
+	IPThreshold     int
+	GlobalThreshold int
+	BaseLockout     time.Duration
+	MaxLockout      time.Duration
+	Window          time.Duration
+}
+
+var DefaultLoginLimits = LoginLimits{
+	IPThreshold:     5,
+	GlobalThreshold: 50,
+	BaseLockout:     30 * time.Second,
+	MaxLockout:      time.Hour,
+	Window:          24 * time.Hour,
+}
+
+type LoginAttempt struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	UserID    *int64    `json:"userId"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	Result    string    `json:"result"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// BeginLoginAttempt checks the lockout of ip and records the attempt in the
+// same transaction, so attempts made at the same time can't all pass the
+// check. The attempt is pending, and counts as a failure, until
+// FinishLoginAttempt sets its result. When ip is locked out the attempt is
+// recorded as LoginLocked and the remaining wait is returned instead.
+func BeginLoginAttempt(db *sql.DB, limits LoginLimits, username, ip, userAgent string) (int64, time.Duration, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	wait, err := loginLockout(tx, limits, ip)
+	if err != nil {
+		return 0, 0, err
+	}
+	result := loginPending
+	if wait > 0 {
+		result = LoginLocked
+	}
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(`
+		INSERT INTO login_attempts (username, user_id, ip, user_agent, result, created_at)
+		VALUES (?, NULL, ?, ?, ?, ?)
+	`, username, ip, userAgent, result, now)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := tx.Exec(`DELETE FROM login_attempts WHERE created_at < ?`, now.Add(-loginAttemptRetention)); err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	if wait > 0 {
+		return 0, wait, nil
+	}
+	return id, 0, nil
+}
+
+// FinishLoginAttempt sets the result of an attempt started with
+// BeginLoginAttempt. userID is 0 when the login failed.
+func FinishLoginAttempt(db *sql.DB, id int64, username string, userID int64, result string) error {
+	var user interface{}
+	if userID != 0 {
+		user = userID
+	}
+	_, err := db.Exec(`UPDATE login_attempts SET username = ?, user_id = ?, result = ? WHERE id = ?`,
+		username, user, result, id)
+	return err
+}
+
+// loginLockout returns how long logins from ip have to wait, 0 when they
+// aren't locked out.
+func loginLockout(q queryer, limits LoginLimits, ip string) (time.Duration, error) {
+	ipWait, err := failureLockout(q, limits, limits.IPThreshold, `AND ip = ?`, ip)
+	if err != nil {
+		return 0, err
+	}
+	globalWait, err := failureLockout(q, limits, limits.GlobalThreshold, ``)
+	if err != nil {
+		return 0, err
+	}
+	return max(ipWait, globalWait), nil
+}
+
+// failureLockout counts the failures and pending attempts since the last
+// success among the attempts matching where, and returns the remaining
+// lockout for them.
+func failureLockout(q queryer, limits LoginLimits, threshold int, where string, args ...interface{}) (time.Duration, error) {
+	if threshold <= 0 {
+		return 0, nil
+	}
+	now := time.Now().UTC()
+
+	queryArgs := append([]interface{}{LoginFailure, loginPending, now.Add(-limits.Window)}, args...)
+	queryArgs = append(queryArgs, LoginSuccess)
+	queryArgs = append(queryArgs, args...)
+	recentFailures := `FROM login_attempts
+		WHERE result IN (?, ?) AND created_at > ? ` + where + `
+		AND id > COALESCE((SELECT MAX(id) FROM login_attempts WHERE result = ? ` + where + `), 0)`
+
+	var failures int
+	if err := q.QueryRow(`SELECT COUNT(*) `+recentFailures, queryArgs...).Scan(&failures); err != nil {
+		return 0, err
+	}
+	if failures < threshold {
+		return 0, nil
+	}
+
+	var last time.Time
+	if err := q.QueryRow(`SELECT created_at `+recentFailures+` ORDER BY id DESC LIMIT 1`, queryArgs...).Scan(&last); err != nil {
+		return 0, err
+	}
+
+	lockout := limits.MaxLockout
+	if extra := failures - threshold; extra < 30 {
+		lockout = min(limits.BaseLockout<<extra, limits.MaxLockout)
+	}
+	if wait := last.Add(lockout).Sub(now); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// ListLoginAttempts returns the most recent attempts first, optionally only
+// those from ip.
+func ListLoginAttempts(db *sql.DB, ip string, limit int) ([]LoginAttempt, error) {
+	query := `SELECT id, username, user_id, ip, user_agent, result, created_at FROM login_attempts`
+	var args []interface{}
+	if ip != "" {
+		query += ` WHERE ip = ?`
+		args = append(args, ip)
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []LoginAttempt{}
+	for rows.Next() {
+		var a LoginAttempt
+		var userID sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.Username, &userID, &a.IP, &a.UserAgent, &a.Result, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			a.UserID = &userID.Int64
+		}
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testLoginLimits = LoginLimits{
+	IPThreshold:     3,
+	GlobalThreshold: 5,
+	BaseLockout:     30 * time.Second,
+	MaxLockout:      2 * time.Minute,
+	Window:          time.Hour,
+}
+
+type pastAttempt struct {
+	ip     string
+	result string
+	ago    time.Duration
+}
+
+// failures returns n failures from ip, made ago.
+func failures(n int, ip string, ago time.Duration) []pastAttempt {
+	var attempts []pastAttempt
+	for i := 0; i < n; i++ {
+		attempts = append(attempts, pastAttempt{ip, LoginFailure, ago})
+	}
+	return attempts
+}
+
+func TestLoginLockout(t *testing.T) {
+	const ip = "10.0.0.1"
+	tests := []struct {
+		name     string
+		attempts []pastAttempt
+		want     time.Duration
+	}{
+		{"no attempts", nil, 0},
+		{"below the threshold", failures(2, ip, 0), 0},
+		{"at the threshold", failures(3, ip, 0), 30 * time.Second},
+		{"doubling", failures(4, ip, 0), time.Minute},
+		{"capped", failures(6, ip, 0), 2 * time.Minute},
+		{"counted from the last failure", failures(3, ip, 10*time.Second), 20 * time.Second},
+		{"expired", failures(3, ip, 40*time.Second), 0},
+		{"outside the window", append(failures(2, ip, 2*time.Hour), failures(1, ip, 0)...), 0},
+		{"reset by a success", append(failures(3, ip, 0), pastAttempt{ip, LoginSuccess, 0}), 0},
+		{"pending attempts count", []pastAttempt{{ip, loginPending, 0}, {ip, loginPending, 0}, {ip, LoginFailure, 0}}, 30 * time.Second},
+		{"locked attempts don't extend it", append(failures(3, ip, 40*time.Second), pastAttempt{ip, LoginLocked, 0}), 0},
+		{"other IP", failures(3, "10.0.0.2", 0), 0},
+		{
+			"every IP together",
+			[]pastAttempt{
+				{"10.0.0.2", LoginFailure, 0}, {"10.0.0.3", LoginFailure, 0}, {"10.0.0.4", LoginFailure, 0},
+				{"10.0.0.5", LoginFailure, 0}, {"10.0.0.6", LoginFailure, 0},
+			},
+			30 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			now := time.Now().UTC()
+			for _, a := range tt.attempts {
+				_, err := db.Exec(`INSERT INTO login_attempts (username, ip, user_agent, result, created_at)
+					VALUES ('admin', ?, '', ?, ?)`, a.ip, a.result, now.Add(-a.ago))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := loginLockout(db, testLoginLimits, ip)
+			if err != nil {
+				t.Fatalf("loginLockout() error = %v", err)
+			}
+			// the wait shrinks while the test runs
+			if got > tt.want || (tt.want > 0 && got < tt.want-5*time.Second) || (tt.want == 0 && got != 0) {
+				t.Errorf("loginLockout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBeginLoginAttempt(t *testing.T) {
+	const ip = "10.0.0.1"
+	db := newTestDB(t)
+
+	// two pending attempts and a failure reach the threshold
+	for i := 0; i < 3; i++ {
+		id, wait, err := BeginLoginAttempt(db, testLoginLimits, "admin", ip, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id == 0 || wait != 0 {
+			t.Fatalf("attempt %d = %d, wait %v, want it to go ahead", i+1, id, wait)
+		}
+		if i == 2 {
+			if err := FinishLoginAttempt(db, id, "admin", 0, LoginFailure); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	id, wait, err := BeginLoginAttempt(db, testLoginLimits, "admin", ip, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 0 || wait <= 0 || wait > testLoginLimits.BaseLockout {
+		t.Errorf("locked attempt = %d, wait %v, want 0 and up to %v", id, wait, testLoginLimits.BaseLockout)
+	}
+
+	attempts, err := ListLoginAttempts(db, ip, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []string
+	for _, a := range attempts {
+		results = append(results, a.Result)
+	}
+	want := []string{LoginLocked, LoginFailure, loginPending, loginPending}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("recorded results = %v, want %v", results, want)
+	}
+}
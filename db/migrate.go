@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations are the files in migrations/ named NNNN_description.sql. They
+// are applied in order, each in a transaction that also records it in
+// schema_version, so a failed one leaves the database as it was. Never edit
+// a migration that was released, add a new one. Foreign keys are off while
+// they run, so a table can be rebuilt without its rows cascading away.
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	sql     string
+}
+
+var migrations []migration
+
+func init() {
+	var err error
+	if migrations, err = loadMigrations(migrationFiles); err != nil {
+		panic(err)
+	}
+}
+
+func loadMigrations(files fs.FS) ([]migration, error) {
+	names, err := fs.Glob(files, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	var list []migration
+	for _, name := range names {
+		base := strings.TrimSuffix(path.Base(name), ".sql")
+		number, description, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil || description == "" {
+			return nil, fmt.Errorf("migration %s must be named NNNN_description.sql", name)
+		}
+		content, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, migration{version, description, string(content)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].version < list[j].version })
+	for i, m := range list {
+		if m.version != i+1 {
+			return nil, fmt.Errorf("migration %d %s is out of sequence, expected version %d", m.version, m.name, i+1)
+		}
+	}
+	return list, nil
+}
+
+// currentVersion returns the version of the database, 0 when it is new or was
+// created before migrations. unversioned tells the latter apart.
+func currentVersion(db *sql.DB) (version int, unversioned bool, err error) {
+	var tracked, hasTables bool
+	err = db.QueryRow(`SELECT
+		EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version'),
+		EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%')`,
+	).Scan(&tracked, &hasTables)
+	if err != nil || !tracked {
+		return 0, hasTables, err
+	}
+	err = db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	return version, false, err
+}
+
+// migrate applies the pending migrations. A database that already has data
+// is first copied next to dbPath, see BackupTo.
+func migrate(db *sql.DB, dbPath string) error {
+	version, unversioned, err := currentVersion(db)
+	if err != nil {
+		return fmt.Errorf("reading the schema version: %w", err)
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("the database is at schema version %d but this build only knows up to %d, use a newer build",
+			version, len(migrations))
+	}
+	if version == len(migrations) {
+		return nil
+	}
+
+	if version > 0 || unversioned {
+		backup, err := backupBeforeMigration(db, dbPath, version)
+		if err != nil {
+			return fmt.Errorf("backing up the database before migrating it: %w", err)
+		}
+		if backup != "" {
+			log.Printf("Migrating the database from schema version %d to %d, backup at %s", version, len(migrations), backup)
+		}
+	}
+
+	if _, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_version (
+	    version INTEGER PRIMARY KEY,
+	    name TEXT NOT NULL,
+	    applied_at DATETIME NOT NULL
+	);
+	`); err != nil {
+		return fmt.Errorf("creating schema_version: %w", err)
+	}
+
+	for _, m := range migrations[version:] {
+		if err := applyMigration(db, m, unversioned); err != nil {
+			return fmt.Errorf("migration %04d_%s failed, the database is left at version %d: %w",
+				m.version, m.name, m.version-1, err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration, unversioned bool) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// can't be changed inside a transaction
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if m.version == 1 && unversioned {
+		if err := upgradeUnversioned(tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.name, time.Now().UTC()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// backupBeforeMigration copies the database to dbPath.pre-vN-timestamp.bak.
+// In-memory databases aren't copied.
+func backupBeforeMigration(db *sql.DB, dbPath string, version int) (string, error) {
+	if dbPath == "" || dbPath == ":memory:" {
+		return "", nil
+	}
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	backup := fmt.Sprintf("%s.pre-v%d-%s.bak", dbPath, version, stamp)
+	// a restored backup can be migrated again within the same second
+	for n := 2; ; n++ {
+		if _, err := os.Stat(backup); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		backup = fmt.Sprintf("%s.pre-v%d-%s-%d.bak", dbPath, version, stamp, n)
+	}
+	return backup, BackupTo(db, backup)
+}
@@ -0,0 +1,376 @@
+-- The schema before migrations were introduced. Databases created by those
+-- versions are brought up to it before this runs, so every statement has to
+-- work on them too.
+
+-- The library: doujinshi and their metadata
+CREATE TABLE IF NOT EXISTS doujinshi (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source TEXT NOT NULL,
+    external_id TEXT NOT NULL,
+    title TEXT,
+    second_title TEXT,
+    pages TEXT,
+    uploaded DATETIME,
+    folder_name TEXT,
+    -- the library root folder_name is in, see library.Roots
+    library_root TEXT NOT NULL DEFAULT 'doujinshi',
+    UNIQUE(source, external_id)
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE
+);
+CREATE TABLE IF NOT EXISTS doujinshi_tags (
+    doujinshi_id INTEGER,
+    tag_id INTEGER,
+    PRIMARY KEY (doujinshi_id, tag_id),
+    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS artists (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE
+);
+CREATE TABLE IF NOT EXISTS doujinshi_artists (
+    doujinshi_id INTEGER,
+    artist_id INTEGER,
+    PRIMARY KEY (doujinshi_id, artist_id),
+    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
+    FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS characters (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE
+);
+CREATE TABLE IF NOT EXISTS doujinshi_characters (
+    doujinshi_id INTEGER,
+    character_id INTEGER,
+    PRIMARY KEY (doujinshi_id, character_id),
+    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
+    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS parodies (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE
+);
+CREATE TABLE IF NOT EXISTS doujinshi_parodies (
+    doujinshi_id INTEGER,
+    parody_id INTEGER,
+    PRIMARY KEY (doujinshi_id, parody_id),
+    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
+    FOREIGN KEY (parody_id) REFERENCES parodies(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS groups (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE
+);
+CREATE TABLE IF NOT EXISTS doujinshi_groups (
+    doujinshi_id INTEGER,
+    group_id INTEGER,
+    PRIMARY KEY (doujinshi_id, group_id),
+    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
+    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS languages (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE
+);
+CREATE TABLE IF NOT EXISTS doujinshi_languages (
+    doujinshi_id INTEGER,
+    language_id INTEGER,
+    PRIMARY KEY (doujinshi_id, language_id),
+    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
+    FOREIGN KEY (language_id) REFERENCES languages(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS categories (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT UNIQUE
+);
+CREATE TABLE IF NOT EXISTS doujinshi_categories (
+    doujinshi_id INTEGER,
+    category_id INTEGER,
+    PRIMARY KEY (doujinshi_id, category_id),
+    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+);
+
+-- Users and everything that belongs to one of them: favorites, progress,
+-- bookmarks, saved filters, sessions, tokens and provider credentials
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+    password_hash TEXT NOT NULL,
+    must_change_password INTEGER NOT NULL DEFAULT 0,
+    is_admin INTEGER NOT NULL DEFAULT 0,
+    -- guests can only read, and don't see the hidden content
+    is_guest INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS favorite_tags (
+    user_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, tag_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(id)
+);
+CREATE TABLE IF NOT EXISTS favorite_artists (
+    user_id INTEGER NOT NULL,
+    artist_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, artist_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (artist_id) REFERENCES artists(id)
+);
+CREATE TABLE IF NOT EXISTS favorite_characters (
+    user_id INTEGER NOT NULL,
+    character_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, character_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (character_id) REFERENCES characters(id)
+);
+CREATE TABLE IF NOT EXISTS favorite_parodies (
+    user_id INTEGER NOT NULL,
+    parody_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, parody_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (parody_id) REFERENCES parodies(id)
+);
+CREATE TABLE IF NOT EXISTS favorite_groups (
+    user_id INTEGER NOT NULL,
+    group_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, group_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (group_id) REFERENCES groups(id)
+);
+CREATE TABLE IF NOT EXISTS favorite_languages (
+    user_id INTEGER NOT NULL,
+    language_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, language_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (language_id) REFERENCES languages(id)
+);
+CREATE TABLE IF NOT EXISTS favorite_categories (
+    user_id INTEGER NOT NULL,
+    category_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, category_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES categories(id)
+);
+
+CREATE TABLE IF NOT EXISTS doujinshi_progress (
+    user_id INTEGER NOT NULL,
+    doujinshi_id INTEGER NOT NULL,
+    rating INTEGER,
+    last_page INTEGER,
+    PRIMARY KEY (user_id, doujinshi_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id)
+);
+
+CREATE TABLE IF NOT EXISTS doujinshi_page_o (
+    user_id INTEGER NOT NULL,
+    doujinshi_id INTEGER NOT NULL,
+    filename TEXT NOT NULL,
+    o_count INTEGER DEFAULT 0,
+    PRIMARY KEY (user_id, doujinshi_id, filename),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id)
+);
+
+CREATE TABLE IF NOT EXISTS doujinshi_bookmarks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    doujinshi_id INTEGER NOT NULL,
+    filename TEXT NOT NULL,
+    name TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, doujinshi_id, filename),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (doujinshi_id) REFERENCES doujinshi(id)
+);
+
+CREATE TABLE IF NOT EXISTS saved_filters (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    filters_json TEXT NOT NULL,
+    kind TEXT NOT NULL DEFAULT 'doujinshi',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS image_progress (
+    user_id INTEGER NOT NULL,
+    image_id INTEGER NOT NULL,
+    rating INTEGER,
+    o_count INTEGER DEFAULT 0,
+    view_count INTEGER DEFAULT 0,
+    last_viewed DATETIME,
+    PRIMARY KEY (user_id, image_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS favorite_images (
+    user_id INTEGER NOT NULL,
+    image_id INTEGER NOT NULL,
+    added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, image_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    last_seen_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    prefix TEXT NOT NULL,
+    scopes TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    last_used_at DATETIME,
+    UNIQUE (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS provider_credentials (
+    user_id INTEGER NOT NULL,
+    provider TEXT NOT NULL,
+    encrypted BLOB NOT NULL,
+    status TEXT NOT NULL,
+    account_name TEXT NOT NULL DEFAULT '',
+    validated_at DATETIME,
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (user_id, provider),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Every login attempt, for the lockouts and the audit trail
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL DEFAULT '',
+    user_id INTEGER,
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    result TEXT NOT NULL,
+    created_at DATETIME NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts(ip, id);
+
+-- Content guests can't see, see hidden.go
+CREATE TABLE IF NOT EXISTS hidden_content (
+    kind TEXT NOT NULL,
+    entity_id INTEGER NOT NULL,
+    PRIMARY KEY (kind, entity_id)
+);
+
+-- Images and their metadata
+CREATE TABLE IF NOT EXISTS images (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    source TEXT, -- nullable for local imports
+    external_id TEXT, -- nullable for local imports
+    filename TEXT NOT NULL,
+    file_path TEXT NOT NULL UNIQUE,
+    file_size INTEGER,
+    width INTEGER,
+    height INTEGER,
+    format TEXT, -- jpg, png, webp, etc.
+    uploaded DATETIME DEFAULT CURRENT_TIMESTAMP,
+    hash TEXT, -- for duplicate detection
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS image_tags (
+    image_id INTEGER,
+    tag_id INTEGER,
+    PRIMARY KEY (image_id, tag_id),
+    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS image_artists (
+    image_id INTEGER,
+    artist_id INTEGER,
+    PRIMARY KEY (image_id, artist_id),
+    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
+    FOREIGN KEY (artist_id) REFERENCES artists(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS image_characters (
+    image_id INTEGER,
+    character_id INTEGER,
+    PRIMARY KEY (image_id, character_id),
+    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
+    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS image_parodies (
+    image_id INTEGER,
+    parody_id INTEGER,
+    PRIMARY KEY (image_id, parody_id),
+    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
+    FOREIGN KEY (parody_id) REFERENCES parodies(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS image_groups (
+    image_id INTEGER,
+    group_id INTEGER,
+    PRIMARY KEY (image_id, group_id),
+    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
+    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS image_categories (
+    image_id INTEGER,
+    category_id INTEGER,
+    PRIMARY KEY (image_id, category_id),
+    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE CASCADE
+);
+
+-- Image collections
+CREATE TABLE IF NOT EXISTS image_collections (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    description TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS image_collection_items (
+    collection_id INTEGER,
+    image_id INTEGER,
+    order_index INTEGER DEFAULT 0,
+    added_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (collection_id, image_id),
+    FOREIGN KEY (collection_id) REFERENCES image_collections(id) ON DELETE CASCADE,
+    FOREIGN KEY (image_id) REFERENCES images(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS collection_bookmarks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    collection_id INTEGER NOT NULL,
+    name TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (collection_id) REFERENCES image_collections(id) ON DELETE CASCADE
+);
@@ -0,0 +1,7 @@
+-- When each row of user data last changed, so imports can keep the newest
+-- value. Rows written before have none and count as older than any change.
+ALTER TABLE doujinshi_progress ADD COLUMN updated_at DATETIME;
+ALTER TABLE doujinshi_page_o ADD COLUMN updated_at DATETIME;
+ALTER TABLE doujinshi_bookmarks ADD COLUMN updated_at DATETIME;
+ALTER TABLE saved_filters ADD COLUMN updated_at DATETIME;
+ALTER TABLE image_progress ADD COLUMN updated_at DATETIME;
@@ -7,7 +7,9 @@ type Doujinshi struct {
 	Source     string `json:"source"`
 	ExternalID string `json:"externalId"`
 	FolderName string `json:"folderName"`
-	OCount     int    `json:"oCount"`
+	// LibraryRoot names the root FolderName is in
+	LibraryRoot string `json:"libraryRoot"`
+	OCount      int    `json:"oCount"`
 
 	Title       string    `json:"title"`
 	SecondTitle string    `json:"secondTitle"`
@@ -4,33 +4,33 @@ import (
 	"database/sql"
 )
 
-func SetOCount(database *sql.DB, doujinshiID int64, filename string, oCount int) error {
+func SetOCount(database *sql.DB, userID, doujinshiID int64, filename string, oCount int) error {
 	_, err := database.Exec(`
-		INSERT INTO doujinshi_page_o (doujinshi_id, filename, o_count)
-		VALUES (?, ?, ?)
-		ON CONFLICT(doujinshi_id, filename) DO UPDATE SET o_count=excluded.o_count
-	`, doujinshiID, filename, oCount)
+		INSERT INTO doujinshi_page_o (user_id, doujinshi_id, filename, o_count, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(user_id, doujinshi_id, filename) DO UPDATE SET o_count=excluded.o_count, updated_at=excluded.updated_at
+	`, userID, doujinshiID, filename, oCount)
 	return err
 }
 
-func GetOCount(db *sql.DB, doujinshiID int64, filename string) (int, error) {
+func GetOCount(db *sql.DB, userID, doujinshiID int64, filename string) (int, error) {
 	var oCount int
 	err := db.QueryRow(`
 		SELECT o_count FROM doujinshi_page_o
-		WHERE doujinshi_id = ? AND filename = ?
-	`, doujinshiID, filename).Scan(&oCount)
+		WHERE user_id = ? AND doujinshi_id = ? AND filename = ?
+	`, userID, doujinshiID, filename).Scan(&oCount)
 	if err == sql.ErrNoRows {
 		return 0, nil // Default to 0 if not set
 	}
 	return oCount, err
 }
 
-func GetTotalOCount(db *sql.DB, doujinshiID int64) (int, error) {
+func GetTotalOCount(db *sql.DB, userID, doujinshiID int64) (int, error) {
 	var total int
 	err := db.QueryRow(`
 		SELECT SUM(o_count) FROM doujinshi_page_o
-		WHERE doujinshi_id = ?
-	`, doujinshiID).Scan(&total)
+		WHERE user_id = ? AND doujinshi_id = ?
+	`, userID, doujinshiID).Scan(&total)
 	if err == sql.ErrNoRows {
 		return 0, nil
 	}
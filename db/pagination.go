@@ -0,0 +1,176 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// Page sizes of list queries when no limit is given, and at most.
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// ListOptions controls sorting and pagination of list queries. Limit defaults
+// to DefaultPageSize and is capped at MaxPageSize, All returns every row
+// instead. When a Cursor is given it takes precedence over Offset.
+type ListOptions struct {
+	Sort   string `json:"sort"`
+	Order  string `json:"order"`
+	Seed   int64  `json:"seed"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+	Cursor string `json:"cursor"`
+	All    bool   `json:"all"`
+}
+
+// listCursor points just past the last row of a page. It records the sort it
+// was created for so a cursor can't be replayed against a different ordering.
+type listCursor struct {
+	Sort  string      `json:"s"`
+	Order string      `json:"o"`
+	Seed  int64       `json:"r,omitempty"`
+	Key   interface{} `json:"k"`
+	ID    int64       `json:"id"`
+}
+
+func encodeCursor(c listCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (listCursor, error) {
+	var c listCursor
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	return c, nil
+}
+
+// sortColumn describes one sort mode: the SQL expression rows are ordered by
+// and the direction used when the caller doesn't pick one.
+type sortColumn struct {
+	expr         string
+	defaultOrder string
+}
+
+// shuffleExpr orders rows pseudo-randomly but deterministically for a seed,
+// so every page of a shuffled listing comes from the same permutation.
+func shuffleExpr(idColumn string, seed int64) string {
+	const prime = "2147483647"
+	mixed := "((" + idColumn + " * 2654435761 + " + strconv.FormatInt(seed, 10) + ") % " + prime + ")"
+	return "((" + mixed + " * " + mixed + " + " + idColumn + ") % " + prime + ")"
+}
+
+// resolvedSort is a validated ListOptions sort against a set of sort columns.
+type resolvedSort struct {
+	name  string
+	expr  string
+	order string
+	seed  int64
+}
+
+func resolveSort(opts ListOptions, columns map[string]sortColumn, defaultSort, idColumn string) (resolvedSort, error) {
+	name := opts.Sort
+	if name == "" {
+		name = defaultSort
+	}
+
+	var s resolvedSort
+	s.name = name
+	if name == "random" {
+		s.seed = opts.Seed
+		if s.seed <= 0 {
+			s.seed = rand.Int63n(1 << 31)
+		}
+		s.seed %= 1 << 31
+		s.expr = shuffleExpr(idColumn, s.seed)
+		s.order = "asc"
+	} else {
+		column, ok := columns[name]
+		if !ok {
+			return s, fmt.Errorf("%w: unknown sort %q", ErrInvalidListOptions, name)
+		}
+		s.expr = column.expr
+		s.order = column.defaultOrder
+	}
+
+	switch strings.ToLower(opts.Order) {
+	case "":
+	case "asc", "desc":
+		s.order = strings.ToLower(opts.Order)
+	default:
+		return s, fmt.Errorf("%w: order must be asc or desc", ErrInvalidListOptions)
+	}
+
+	return s, nil
+}
+
+func (s resolvedSort) orderBy(idColumn string) string {
+	dir := strings.ToUpper(s.order)
+	return "ORDER BY " + s.expr + " " + dir + ", " + idColumn + " " + dir
+}
+
+// addCursor restricts the clause to rows after the cursor position.
+func (s resolvedSort) addCursor(f *filterClause, cursor string, idColumn string) error {
+	c, err := decodeCursor(cursor)
+	if err != nil {
+		return err
+	}
+	if c.Sort != s.name || c.Order != s.order || c.Seed != s.seed {
+		return fmt.Errorf("%w: cursor does not match the requested sort", ErrInvalidListOptions)
+	}
+
+	cmp := ">"
+	if s.order == "desc" {
+		cmp = "<"
+	}
+	f.add("("+s.expr+" "+cmp+" ? OR ("+s.expr+" = ? AND "+idColumn+" "+cmp+" ?))", c.Key, c.Key, c.ID)
+	return nil
+}
+
+func (s resolvedSort) cursorAfter(key interface{}, id int64) string {
+	if b, ok := key.([]byte); ok {
+		key = string(b)
+	}
+	return encodeCursor(listCursor{Sort: s.name, Order: s.order, Seed: s.seed, Key: key, ID: id})
+}
+
+// paged checks the limit and offset and sets the page size. Limit is zero
+// when every row is wanted.
+func (opts ListOptions) paged() (ListOptions, error) {
+	if opts.Limit < 0 || opts.Offset < 0 {
+		return opts, fmt.Errorf("%w: limit and offset can't be negative", ErrInvalidListOptions)
+	}
+	switch {
+	case opts.All:
+		opts.Limit = 0
+	case opts.Limit == 0:
+		opts.Limit = DefaultPageSize
+	case opts.Limit > MaxPageSize:
+		opts.Limit = MaxPageSize
+	}
+	return opts, nil
+}
+
+func (opts ListOptions) limitOffset() string {
+	clause := "LIMIT -1"
+	if opts.Limit > 0 {
+		clause = "LIMIT " + strconv.Itoa(opts.Limit)
+	}
+	if opts.Cursor == "" && opts.Offset > 0 {
+		clause += " OFFSET " + strconv.Itoa(opts.Offset)
+	}
+	return clause
+}
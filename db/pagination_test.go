@@ -0,0 +1,214 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/brayanMuniz/h_save/types"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor listCursor
+	}{
+		{"text key", listCursor{Sort: "title", Order: "asc", Key: "Some Title", ID: 3}},
+		{"number key", listCursor{Sort: "uploaded", Order: "desc", Key: float64(1704067200), ID: 12}},
+		{"null key", listCursor{Sort: "rating", Order: "desc", Key: nil, ID: 1}},
+		{"shuffled", listCursor{Sort: "random", Order: "asc", Seed: 42, Key: float64(7), ID: 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeCursor(encodeCursor(tt.cursor))
+			if err != nil {
+				t.Fatalf("decodeCursor() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.cursor) {
+				t.Errorf("decodeCursor(encodeCursor(%+v)) = %+v", tt.cursor, got)
+			}
+		})
+	}
+}
+
+func TestDecodeCursorErrors(t *testing.T) {
+	for _, cursor := range []string{"not a cursor!", "bm90IGpzb24", "W10"} {
+		t.Run(cursor, func(t *testing.T) {
+			if _, err := decodeCursor(cursor); !errors.Is(err, ErrInvalidListOptions) {
+				t.Errorf("decodeCursor(%q) error = %v, want ErrInvalidListOptions", cursor, err)
+			}
+		})
+	}
+}
+
+func TestListOptionsPaged(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      ListOptions
+		wantLimit int
+		wantErr   bool
+	}{
+		{"default", ListOptions{}, DefaultPageSize, false},
+		{"limit", ListOptions{Limit: 10}, 10, false},
+		{"capped", ListOptions{Limit: MaxPageSize + 1}, MaxPageSize, false},
+		{"all", ListOptions{All: true, Limit: 10}, 0, false},
+		{"negative limit", ListOptions{Limit: -1}, 0, true},
+		{"negative offset", ListOptions{Offset: -1}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.opts.paged()
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidListOptions) {
+					t.Fatalf("paged() error = %v, want ErrInvalidListOptions", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("paged() error = %v", err)
+			}
+			if got.Limit != tt.wantLimit {
+				t.Errorf("paged().Limit = %d, want %d", got.Limit, tt.wantLimit)
+			}
+		})
+	}
+}
+
+// seedPagedLibrary adds seven doujinshi, some uploaded on the same day and
+// one sharing its title with another, so pages have ties to break.
+func seedPagedLibrary(t *testing.T) *sql.DB {
+	t.Helper()
+	db := newTestDB(t)
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	books := []Doujinshi{
+		{Title: "alpha", Uploaded: day(1)},
+		{Title: "bravo", Uploaded: day(2)},
+		{Title: "charlie", Uploaded: day(2)},
+		{Title: "delta", Uploaded: day(2)},
+		{Title: "echo", Uploaded: day(3)},
+		{Title: "echo", ExternalID: "echo-2", Uploaded: day(4)},
+		{Title: "foxtrot", Uploaded: day(5)},
+	}
+	for _, d := range books {
+		addTestDoujinshi(t, db, d)
+	}
+	return db
+}
+
+func pageIDs(list []Doujinshi) []int64 {
+	ids := []int64{}
+	for _, d := range list {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func TestListDoujinshiCursorPages(t *testing.T) {
+	db := seedPagedLibrary(t)
+
+	tests := []struct {
+		name string
+		opts ListOptions
+	}{
+		{"title", ListOptions{Sort: "title"}},
+		{"title desc", ListOptions{Sort: "title", Order: "desc"}},
+		{"uploaded", ListOptions{Sort: "uploaded"}},
+		{"uploaded asc", ListOptions{Sort: "uploaded", Order: "asc"}},
+		{"shuffled", ListOptions{Sort: "random", Seed: 42}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			all := tt.opts
+			all.All = true
+			want, err := ListDoujinshi(db, testUserID, types.BrowseFilters{}, all)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := []int64{}
+			opts := tt.opts
+			opts.Limit = 3
+			for pages := 0; ; pages++ {
+				if pages > len(want.Doujinshi) {
+					t.Fatalf("the cursors never ran out, got %v so far", got)
+				}
+				page, err := ListDoujinshi(db, testUserID, types.BrowseFilters{}, opts)
+				if err != nil {
+					t.Fatalf("ListDoujinshi() page %d error = %v", pages, err)
+				}
+				if page.Total != len(want.Doujinshi) {
+					t.Errorf("page %d Total = %d, want %d", pages, page.Total, len(want.Doujinshi))
+				}
+				got = append(got, pageIDs(page.Doujinshi)...)
+				if page.NextCursor == "" {
+					break
+				}
+				opts.Cursor = page.NextCursor
+			}
+
+			if !reflect.DeepEqual(got, pageIDs(want.Doujinshi)) {
+				t.Errorf("paged IDs = %v, want %v", got, pageIDs(want.Doujinshi))
+			}
+		})
+	}
+}
+
+func TestListDoujinshiShuffle(t *testing.T) {
+	db := seedPagedLibrary(t)
+	list := func(seed int64) DoujinshiPage {
+		t.Helper()
+		page, err := ListDoujinshi(db, testUserID, types.BrowseFilters{}, ListOptions{Sort: "random", Seed: seed, All: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+
+	first, again := list(42), list(42)
+	if first.Seed != 42 {
+		t.Errorf("Seed = %d, want 42", first.Seed)
+	}
+	if !reflect.DeepEqual(pageIDs(first.Doujinshi), pageIDs(again.Doujinshi)) {
+		t.Errorf("seed 42 gave %v, then %v", pageIDs(first.Doujinshi), pageIDs(again.Doujinshi))
+	}
+	if other := list(7); reflect.DeepEqual(pageIDs(first.Doujinshi), pageIDs(other.Doujinshi)) {
+		t.Errorf("seeds 42 and 7 gave the same order %v", pageIDs(first.Doujinshi))
+	}
+	if random := list(0); random.Seed <= 0 {
+		t.Errorf("without a seed Seed = %d, want one to be picked", random.Seed)
+	}
+}
+
+func TestListDoujinshiCursorErrors(t *testing.T) {
+	db := seedPagedLibrary(t)
+	page, err := ListDoujinshi(db, testUserID, types.BrowseFilters{}, ListOptions{Sort: "random", Seed: 42, Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts ListOptions
+	}{
+		{"malformed", ListOptions{Cursor: "not a cursor!"}},
+		{"other sort", ListOptions{Sort: "title", Cursor: page.NextCursor}},
+		{"other order", ListOptions{Sort: "random", Seed: 42, Order: "desc", Cursor: page.NextCursor}},
+		{"other seed", ListOptions{Sort: "random", Seed: 7, Cursor: page.NextCursor}},
+		{"unknown sort", ListOptions{Sort: "colour"}},
+		{"unknown order", ListOptions{Order: "up"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ListDoujinshi(db, testUserID, types.BrowseFilters{}, tt.opts)
+			if !errors.Is(err, ErrInvalidListOptions) {
+				t.Errorf("ListDoujinshi() error = %v, want ErrInvalidListOptions", err)
+			}
+		})
+	}
+}
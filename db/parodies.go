@@ -14,10 +14,10 @@ type ParodyData struct {
 	ImageCount    int      `json:"imageCount"`
 }
 
-func GetAllParodies(db *sql.DB) ([]ParodyData, error) {
+func GetAllParodies(db *sql.DB, userID int64) ([]ParodyData, error) {
 	favoriteParodiesSet := make(map[int64]bool)
-	favQuery := `SELECT parody_id FROM favorite_parodies`
-	favRows, err := db.Query(favQuery)
+	favQuery := `SELECT parody_id FROM favorite_parodies WHERE user_id = ?`
+	favRows, err := db.Query(favQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +34,7 @@ func GetAllParodies(db *sql.DB) ([]ParodyData, error) {
 		return nil, err
 	}
 
-	mainQuery := `
+	mainQuery := withUser + `
 	SELECT
 		p.id,
 		p.name,
@@ -47,25 +47,28 @@ func GetAllParodies(db *sql.DB) ([]ParodyData, error) {
 	LEFT JOIN
 		doujinshi_parodies dpd ON p.id = dpd.parody_id
 	LEFT JOIN
-		doujinshi d ON dpd.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != ''
+		doujinshi d ON dpd.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != '' AND ` + doujinshiVisible("d.id") + `
 	LEFT JOIN
 		(SELECT
 			 po.doujinshi_id,
 			 SUM(po.o_count) AS total_o_for_doujin
 		 FROM doujinshi_page_o po
+		 WHERE po.user_id = ` + currentUser + `
 		 GROUP BY po.doujinshi_id
 		) d_ocount ON d.id = d_ocount.doujinshi_id
 	LEFT JOIN
-		doujinshi_progress dp ON d.id = dp.doujinshi_id
+		doujinshi_progress dp ON d.id = dp.doujinshi_id AND dp.user_id = ` + currentUser + `
 	LEFT JOIN
-		image_parodies ip ON p.id = ip.parody_id
+		image_parodies ip ON p.id = ip.parody_id AND ` + imageVisible("ip.image_id") + `
+	WHERE
+		` + entityVisible("parodies", "p.id") + `
 	GROUP BY
 		p.id, p.name
 	ORDER BY
 		p.name ASC;
 	`
 
-	allRows, err := db.Query(mainQuery)
+	allRows, err := db.Query(mainQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -108,17 +111,17 @@ func GetAllParodies(db *sql.DB) ([]ParodyData, error) {
 	return results, nil
 }
 
-func GetParodyDetails(db *sql.DB, parodyID int64) (*ParodyData, error) {
+func GetParodyDetails(db *sql.DB, userID, parodyID int64) (*ParodyData, error) {
 	var isFavorite bool
 	err := db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM favorite_parodies WHERE parody_id = ?)`,
-		parodyID,
+		`SELECT EXISTS(SELECT 1 FROM favorite_parodies WHERE user_id = ? AND parody_id = ?)`,
+		userID, parodyID,
 	).Scan(&isFavorite)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 
-	query := `
+	query := withUser + `
 	SELECT
 		p.id,
 		p.name,
@@ -130,25 +133,26 @@ func GetParodyDetails(db *sql.DB, parodyID int64) (*ParodyData, error) {
 	LEFT JOIN
 		doujinshi_parodies dpd ON p.id = dpd.parody_id
 	LEFT JOIN
-		doujinshi d ON dpd.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != ''
+		doujinshi d ON dpd.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != '' AND ` + doujinshiVisible("d.id") + `
 	LEFT JOIN
 		(SELECT
 			 po.doujinshi_id,
 			 SUM(po.o_count) AS total_o_for_doujin
 		 FROM doujinshi_page_o po
+		 WHERE po.user_id = ` + currentUser + `
 		 GROUP BY po.doujinshi_id
 		) d_ocount ON d.id = d_ocount.doujinshi_id
 	LEFT JOIN
-		doujinshi_progress dp ON d.id = dp.doujinshi_id
+		doujinshi_progress dp ON d.id = dp.doujinshi_id AND dp.user_id = ` + currentUser + `
 	WHERE
-		p.id = ?
+		p.id = ? AND ` + entityVisible("parodies", "p.id") + `
 	GROUP BY
 		p.id, p.name;
 	`
 	var parodyInfo ParodyData
 	var avgRating sql.NullFloat64
 
-	err = db.QueryRow(query, parodyID).Scan(
+	err = db.QueryRow(query, userID, parodyID).Scan(
 		&parodyInfo.ID,
 		&parodyInfo.Name,
 		&parodyInfo.DoujinCount,
@@ -170,15 +174,16 @@ func GetParodyDetails(db *sql.DB, parodyID int64) (*ParodyData, error) {
 	return &parodyInfo, nil
 }
 
-func GetDoujinshiByParody(db *sql.DB, parodyID int64) ([]Doujinshi, error) {
-	query := `
-		SELECT d.id, d.source, d.external_id, d.title, d.pages, d.uploaded, d.folder_name
+func GetDoujinshiByParody(db *sql.DB, userID, parodyID int64) ([]Doujinshi, error) {
+	query := withUser + `
+		SELECT d.id, d.source, d.external_id, d.title, d.pages, d.uploaded, d.folder_name, d.library_root
 		FROM doujinshi d
 		JOIN doujinshi_parodies dpd ON d.id = dpd.doujinshi_id
 		WHERE dpd.parody_id = ? AND d.folder_name IS NOT NULL AND d.folder_name != ''
+			AND ` + doujinshiVisible("d.id") + `
 		ORDER BY d.uploaded DESC, d.title ASC
 	`
-	rows, err := db.Query(query, parodyID)
+	rows, err := db.Query(query, userID, parodyID)
 	if err != nil {
 		return nil, err
 	}
@@ -188,12 +193,18 @@ func GetDoujinshiByParody(db *sql.DB, parodyID int64) ([]Doujinshi, error) {
 	for rows.Next() {
 		var d Doujinshi
 		if err := rows.Scan(&d.ID, &d.Source, &d.ExternalID, &d.Title, &d.Pages,
-			&d.Uploaded, &d.FolderName); err != nil {
+			&d.Uploaded, &d.FolderName, &d.LibraryRoot); err != nil {
 			return nil, err
 		}
-		populateDoujinshiDetails(db, &d)
 		results = append(results, d)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateDoujinshi(db, userID, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
 
@@ -210,27 +221,27 @@ func GetParodyIDByName(db *sql.DB, parodyName string) (int64, error) {
 	return parodyID, nil
 }
 
-func AddFavoriteParody(db *sql.DB, parodyID int64) error {
-	_, err := db.Exec(`INSERT OR IGNORE INTO favorite_parodies (parody_id) VALUES (?)`, parodyID)
+func AddFavoriteParody(db *sql.DB, userID, parodyID int64) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO favorite_parodies (user_id, parody_id) VALUES (?, ?)`, userID, parodyID)
 	return err
 }
 
-func RemoveFavoriteParody(db *sql.DB, parodyID int64) error {
-	_, err := db.Exec(`DELETE FROM favorite_parodies WHERE parody_id = ?`, parodyID)
+func RemoveFavoriteParody(db *sql.DB, userID, parodyID int64) error {
+	_, err := db.Exec(`DELETE FROM favorite_parodies WHERE user_id = ? AND parody_id = ?`, userID, parodyID)
 	return err
 }
 
-func GetImagesByParody(db *sql.DB, parodyID int64) ([]Image, error) {
-	query := `
+func GetImagesByParody(db *sql.DB, userID, parodyID int64) ([]Image, error) {
+	query := withUser + `
 		SELECT i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
 			i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
 			COALESCE(i.hash, '') as hash
 		FROM images i
 		JOIN image_parodies ip ON i.id = ip.image_id
-		WHERE ip.parody_id = ?
+		WHERE ip.parody_id = ? AND ` + imageVisible("i.id") + `
 		ORDER BY i.uploaded DESC
 	`
-	rows, err := db.Query(query, parodyID)
+	rows, err := db.Query(query, userID, parodyID)
 	if err != nil {
 		return nil, err
 	}
@@ -244,8 +255,14 @@ func GetImagesByParody(db *sql.DB, parodyID int64) ([]Image, error) {
 			&img.Format, &img.Uploaded, &img.Hash); err != nil {
 			return nil, err
 		}
-		populateImageDetails(db, &img)
 		results = append(results, img)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateImages(db, userID, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
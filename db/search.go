@@ -0,0 +1,294 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"html"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// ErrSearchUnavailable is returned when SQLite was built without FTS5. Build
+// with `-tags sqlite_fts5` to enable full-text search.
+var ErrSearchUnavailable = errors.New("full-text search is unavailable, build with -tags sqlite_fts5")
+
+// searchIndexAvailable is set by InitDB once the FTS5 table exists.
+var searchIndexAvailable bool
+
+// FullTextSearchAvailable tells whether searches go through the FTS5 index.
+// Without it titles are matched with LIKE and related names aren't searched.
+func FullTextSearchAvailable() bool {
+	return searchIndexAvailable
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// The metadata column holds every tag, artist, character, parody and group
+// name of the doujinshi separated by spaces.
+const searchIndexSelect = `
+	SELECT d.id, COALESCE(d.title, ''), COALESCE(d.second_title, ''),
+		COALESCE((
+			SELECT group_concat(name, ' ') FROM (
+				SELECT e.name FROM tags e JOIN doujinshi_tags j ON j.tag_id = e.id WHERE j.doujinshi_id = d.id
+				UNION ALL
+				SELECT e.name FROM artists e JOIN doujinshi_artists j ON j.artist_id = e.id WHERE j.doujinshi_id = d.id
+				UNION ALL
+				SELECT e.name FROM characters e JOIN doujinshi_characters j ON j.character_id = e.id WHERE j.doujinshi_id = d.id
+				UNION ALL
+				SELECT e.name FROM parodies e JOIN doujinshi_parodies j ON j.parody_id = e.id WHERE j.doujinshi_id = d.id
+				UNION ALL
+				SELECT e.name FROM groups e JOIN doujinshi_groups j ON j.group_id = e.id WHERE j.doujinshi_id = d.id
+			)
+		), '')
+	FROM doujinshi d`
+
+func createSearchIndex(db *sql.DB) error {
+	var fts5 bool
+	if err := db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return err
+	}
+	if !fts5 {
+		log.Println("SQLite was built without FTS5, falling back to LIKE search")
+		return nil
+	}
+
+	_, err := db.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS doujinshi_fts USING fts5(
+	    title,
+	    second_title,
+	    metadata,
+	    tokenize = 'unicode61 remove_diacritics 2'
+	);
+	`)
+	if err != nil {
+		return err
+	}
+	searchIndexAvailable = true
+
+	// Fill the index for libraries created before it existed
+	var indexed, total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM doujinshi_fts`).Scan(&indexed); err != nil {
+		return err
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM doujinshi`).Scan(&total); err != nil {
+		return err
+	}
+	if indexed != total {
+		return RebuildSearchIndex(db)
+	}
+	return nil
+}
+
+// RebuildSearchIndex re-indexes every doujinshi.
+func RebuildSearchIndex(db *sql.DB) error {
+	if !searchIndexAvailable {
+		return ErrSearchUnavailable
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM doujinshi_fts`); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO doujinshi_fts (rowid, title, second_title, metadata)` + searchIndexSelect)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// refreshSearchIndex re-indexes a single doujinshi. It must be called after
+// the title or any related names of the doujinshi change.
+func refreshSearchIndex(q queryer, doujinshiID int64) error {
+	if !searchIndexAvailable {
+		return nil
+	}
+
+	if _, err := q.Exec(`DELETE FROM doujinshi_fts WHERE rowid = ?`, doujinshiID); err != nil {
+		return err
+	}
+	_, err := q.Exec(`INSERT INTO doujinshi_fts (rowid, title, second_title, metadata)`+
+		searchIndexSelect+` WHERE d.id = ?`, doujinshiID)
+	return err
+}
+
+// isCJK tells whether r is Chinese, Japanese or Korean script. unicode61
+// only splits text on spaces and punctuation, so a Japanese title is one long
+// token and a word inside it never matches through the index.
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// searchTerms turns free text into an FTS5 query where every word must match
+// the start of a token, and the words containing CJK characters, which have
+// to be matched as substrings instead. Quotes are dropped so user input
+// can't inject FTS5 syntax.
+func searchTerms(text string) (match string, substrings []string) {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		word = strings.ReplaceAll(word, `"`, "")
+		if word == "" {
+			continue
+		}
+		if strings.IndexFunc(word, isCJK) >= 0 {
+			substrings = append(substrings, word)
+			continue
+		}
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " "), substrings
+}
+
+// substringConditions requires every substring to be in one of the columns.
+func substringConditions(substrings []string, columns ...string) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	escape := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	for _, s := range substrings {
+		var alternatives []string
+		for _, column := range columns {
+			alternatives = append(alternatives, column+` LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+escape.Replace(s)+"%")
+		}
+		conds = append(conds, "("+strings.Join(alternatives, " OR ")+")")
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// highlight() and snippet() put these around the matched words. They are
+// control characters so they survive escaping the text and become <mark>
+// tags afterwards.
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+var markTags = strings.NewReplacer(markStart, "<mark>", markEnd, "</mark>")
+
+// highlightHTML escapes text, which holds titles and names as they were
+// stored, and turns the matches into <mark> tags. The substrings are marked
+// too, like highlight() does for the words matched through the index.
+func highlightHTML(text string, substrings []string) string {
+	text = html.EscapeString(text)
+	for _, s := range substrings {
+		s = html.EscapeString(s)
+		text = strings.ReplaceAll(text, s, markStart+s+markEnd)
+	}
+	return markTags.Replace(text)
+}
+
+type SearchHighlights struct {
+	Title       string `json:"title"`
+	SecondTitle string `json:"secondTitle"`
+	Metadata    string `json:"metadata"`
+}
+
+type DoujinshiSearchResult struct {
+	Doujinshi  Doujinshi        `json:"doujinshi"`
+	Rank       float64          `json:"rank"`
+	Highlights SearchHighlights `json:"highlights"`
+}
+
+// SearchDoujinshiText runs a ranked full-text search over titles and related
+// names of synced doujinshi. The highlights are HTML with the matches wrapped
+// in <mark> tags. Words with CJK characters are found anywhere in the titles
+// and names, see isCJK, and don't add to the rank.
+func SearchDoujinshiText(db *sql.DB, userID int64, text string, limit int) ([]DoujinshiSearchResult, error) {
+	if !searchIndexAvailable {
+		return nil, ErrSearchUnavailable
+	}
+
+	match, substrings := searchTerms(text)
+	if match == "" && len(substrings) == 0 {
+		return []DoujinshiSearchResult{}, nil
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+
+	// the ranking and highlight functions only work with MATCH
+	rank := `bm25(doujinshi_fts, 10.0, 5.0, 1.0)`
+	highlights := `highlight(doujinshi_fts, 0, char(2), char(3)),
+		highlight(doujinshi_fts, 1, char(2), char(3)),
+		snippet(doujinshi_fts, 2, char(2), char(3), '…', 12)`
+	conds := []string{`d.folder_name IS NOT NULL AND d.folder_name != ''`, doujinshiVisible("d.id")}
+	args := []interface{}{userID}
+	if match != "" {
+		conds = append(conds, `doujinshi_fts MATCH ?`)
+		args = append(args, match)
+	} else {
+		rank = `0`
+		highlights = `doujinshi_fts.title, doujinshi_fts.second_title, doujinshi_fts.metadata`
+	}
+	if len(substrings) > 0 {
+		cond, condArgs := substringConditions(substrings,
+			"doujinshi_fts.title", "doujinshi_fts.second_title", "doujinshi_fts.metadata")
+		conds = append(conds, cond)
+		args = append(args, condArgs...)
+	}
+	args = append(args, limit)
+
+	rows, err := db.Query(withUser+`
+	SELECT
+		d.id, d.source, d.external_id, d.title, COALESCE(d.second_title, '') as second_title,
+		d.pages, d.uploaded, d.folder_name, d.library_root,
+		`+doujinshiOCountExpr+` AS o_count,
+		`+doujinshiBookmarkCountExpr+` AS bookmark_count,
+		`+rank+` AS rank,
+		`+highlights+`
+	FROM doujinshi_fts
+	JOIN doujinshi d ON d.id = doujinshi_fts.rowid
+	WHERE `+strings.Join(conds, " AND ")+`
+	ORDER BY rank, length(d.title), d.id
+	LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []DoujinshiSearchResult
+	var doujinshiList []Doujinshi
+	for rows.Next() {
+		var r DoujinshiSearchResult
+		d := &r.Doujinshi
+		err := rows.Scan(
+			&d.ID, &d.Source, &d.ExternalID, &d.Title, &d.SecondTitle, &d.Pages,
+			&d.Uploaded, &d.FolderName, &d.LibraryRoot, &d.OCount, &d.BookmarkCount,
+			&r.Rank, &r.Highlights.Title, &r.Highlights.SecondTitle, &r.Highlights.Metadata,
+		)
+		if err != nil {
+			return nil, err
+		}
+		h := &r.Highlights
+		h.Title = highlightHTML(h.Title, substrings)
+		h.SecondTitle = highlightHTML(h.SecondTitle, substrings)
+		h.Metadata = highlightHTML(h.Metadata, substrings)
+		results = append(results, r)
+		doujinshiList = append(doujinshiList, r.Doujinshi)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateDoujinshi(db, userID, doujinshiList); err != nil {
+		return nil, err
+	}
+	for i, d := range doujinshiList {
+		results[i].Doujinshi = d
+	}
+	if results == nil {
+		results = []DoujinshiSearchResult{}
+	}
+	return results, nil
+}
@@ -0,0 +1,85 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlightHTML(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		substrings []string
+		want       string
+	}{
+		{"plain", "Alpha Story", nil, "Alpha Story"},
+		{"matched word", "\x02Alpha\x03 Story", nil, "<mark>Alpha</mark> Story"},
+		{"markup", `Café <img src=x onerror=alert(1)>`, nil, `Café &lt;img src=x onerror=alert(1)&gt;`},
+		{"markup next to a match", "\x02Café\x03 <b>Love</b>", nil, "<mark>Café</mark> &lt;b&gt;Love&lt;/b&gt;"},
+		{"substring", "ぼくのエッチな彼女", []string{"彼女"}, "ぼくのエッチな<mark>彼女</mark>"},
+		{"substring with markup", `<i>彼女</i> & "彼女"`, []string{"彼女"}, `&lt;i&gt;<mark>彼女</mark>&lt;/i&gt; &amp; &#34;<mark>彼女</mark>&#34;`},
+		{"escaped substring", "a&b", []string{"&"}, "a<mark>&amp;</mark>b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := highlightHTML(tt.text, tt.substrings); got != tt.want {
+				t.Errorf("highlightHTML(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchDoujinshiTextEscapesMarkup(t *testing.T) {
+	db := newTestDB(t)
+	if !searchIndexAvailable {
+		t.Skip("full-text search needs -tags sqlite_fts5")
+	}
+	addTestDoujinshi(t, db, Doujinshi{
+		Title:       `Café Love <img src=x onerror=alert(1)>`,
+		SecondTitle: `<script>alert(2)</script> 彼女`,
+		Tags:        []string{`<b>vanilla</b>`},
+	})
+
+	tests := []struct {
+		query string
+		want  SearchHighlights
+	}{
+		{"cafe", SearchHighlights{
+			Title:       `<mark>Café</mark> Love &lt;img src=x onerror=alert(1)&gt;`,
+			SecondTitle: `&lt;script&gt;alert(2)&lt;/script&gt; 彼女`,
+			Metadata:    `&lt;b&gt;vanilla&lt;/b&gt;`,
+		}},
+		{"彼女", SearchHighlights{
+			Title:       `Café Love &lt;img src=x onerror=alert(1)&gt;`,
+			SecondTitle: `&lt;script&gt;alert(2)&lt;/script&gt; <mark>彼女</mark>`,
+			Metadata:    `&lt;b&gt;vanilla&lt;/b&gt;`,
+		}},
+		{"vanilla", SearchHighlights{
+			Title:       `Café Love &lt;img src=x onerror=alert(1)&gt;`,
+			SecondTitle: `&lt;script&gt;alert(2)&lt;/script&gt; 彼女`,
+			Metadata:    `&lt;b&gt;<mark>vanilla</mark>&lt;/b&gt;`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			results, err := SearchDoujinshiText(db, testUserID, tt.query, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			got := results[0].Highlights
+			if got != tt.want {
+				t.Errorf("highlights = %+v, want %+v", got, tt.want)
+			}
+			for _, h := range []string{got.Title, got.SecondTitle, got.Metadata} {
+				if strings.Contains(h, "<img") || strings.Contains(h, "<script") || strings.Contains(h, "<b>") {
+					t.Errorf("highlight %q contains unescaped markup", h)
+				}
+			}
+		})
+	}
+}
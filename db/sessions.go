@@ -0,0 +1,176 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	// SessionLifetime is how long a session lasts after logging in, however
+	// active it is.
+	SessionLifetime = 30 * 24 * time.Hour
+	// SessionIdleTimeout ends sessions that haven't been used for a while.
+	SessionIdleTimeout = 7 * 24 * time.Hour
+
+	// last_seen_at is only written when it is older than this, so browsing
+	// doesn't turn every request into a write
+	sessionTouchInterval = time.Minute
+)
+
+// ErrInvalidSession is returned for unknown, expired and idle sessions.
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"userId"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	// Current is set when listing sessions for the one making the request.
+	Current bool `json:"current"`
+}
+
+// Only a hash of the token is stored, so reading the database doesn't give
+// access to the sessions in it.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateSession starts a session and returns the token identifying it. The
+// token is not stored and can't be recovered later.
+func CreateSession(db *sql.DB, userID int64, userAgent, ip string) (string, Session, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	now := time.Now().UTC()
+	s := Session{
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(SessionLifetime),
+	}
+	result, err := db.Exec(`
+		INSERT INTO sessions (user_id, token_hash, user_agent, ip, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, s.UserID, hashToken(token), s.UserAgent, s.IP, s.CreatedAt, s.LastSeenAt, s.ExpiresAt)
+	if err != nil {
+		return "", Session{}, err
+	}
+	if s.ID, err = result.LastInsertId(); err != nil {
+		return "", Session{}, err
+	}
+	return token, s, nil
+}
+
+// ValidateSession returns the session for token and marks it as used. It
+// returns ErrInvalidSession when the token is unknown or the session expired,
+// deleting expired sessions.
+func ValidateSession(db *sql.DB, token string) (Session, error) {
+	var s Session
+	if token == "" {
+		return s, ErrInvalidSession
+	}
+
+	err := db.QueryRow(`
+		SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+		FROM sessions WHERE token_hash = ?
+	`, hashToken(token)).Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return s, ErrInvalidSession
+	}
+	if err != nil {
+		return s, err
+	}
+
+	now := time.Now().UTC()
+	if now.After(s.ExpiresAt) || now.After(s.LastSeenAt.Add(SessionIdleTimeout)) {
+		if err := DeleteSession(db, s.UserID, s.ID); err != nil {
+			return s, err
+		}
+		return s, ErrInvalidSession
+	}
+
+	if now.Sub(s.LastSeenAt) > sessionTouchInterval {
+		if _, err := db.Exec(`UPDATE sessions SET last_seen_at = ? WHERE id = ?`, now, s.ID); err != nil {
+			return s, err
+		}
+		s.LastSeenAt = now
+	}
+	return s, nil
+}
+
+// ListSessions returns the user's active sessions, most recently used first.
+func ListSessions(db *sql.DB, userID int64) ([]Session, error) {
+	if err := DeleteExpiredSessions(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, user_id, user_agent, ip, created_at, last_seen_at, expires_at
+		FROM sessions WHERE user_id = ? ORDER BY last_seen_at DESC, id DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteSession ends one of the user's sessions. It returns sql.ErrNoRows
+// when the user has no session with that ID.
+func DeleteSession(db *sql.DB, userID, id int64) error {
+	result, err := db.Exec(`DELETE FROM sessions WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteOtherSessions ends every session of the user except keepID, or all
+// of them when keepID is 0.
+func DeleteOtherSessions(db *sql.DB, userID, keepID int64) error {
+	_, err := db.Exec(`DELETE FROM sessions WHERE user_id = ? AND id != ?`, userID, keepID)
+	return err
+}
+
+// DeleteExpiredSessions removes sessions past their lifetime or idle timeout.
+func DeleteExpiredSessions(db *sql.DB) error {
+	now := time.Now().UTC()
+	_, err := db.Exec(`DELETE FROM sessions WHERE expires_at < ? OR last_seen_at < ?`,
+		now, now.Add(-SessionIdleTimeout))
+	return err
+}
@@ -0,0 +1,299 @@
+package db
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Similar items are ranked by IDF-weighted Jaccard similarity: the weight of
+// the attributes both items share divided by the weight of all attributes
+// either has. An attribute's weight is its kind weight times ln(1 + N/df),
+// where df is how many items have it, so a rare shared tag counts for more
+// than one most of the library has.
+
+// similarityKind weighs the attributes of one relation. Shared attributes are
+// keyed by the relation's entity table (tags, artists, ...).
+type similarityKind struct {
+	relation entityRelation
+	weight   float64
+}
+
+var doujinshiSimilarityKinds = []similarityKind{
+	{doujinshiTags, 1},
+	{doujinshiArtists, 2},
+	{doujinshiCharacters, 1.5},
+	{doujinshiParodies, 1},
+	{doujinshiGroups, 1.5},
+}
+
+var imageSimilarityKinds = []similarityKind{
+	{imageTags, 1},
+	{imageArtists, 2},
+	{imageCharacters, 1.5},
+	{imageParodies, 1},
+	{imageGroups, 1.5},
+}
+
+// SharedAttributes maps an attribute kind (tags, artists, ...) to the names
+// both items have.
+type SharedAttributes map[string][]string
+
+type similarMatch struct {
+	id     int64
+	score  float64
+	shared SharedAttributes
+}
+
+type similarityQuery struct {
+	kinds []similarityKind
+	// eligible selects the IDs of every item that can be recommended
+	eligible string
+	// visible is the condition for items the user may see, see hidden.go
+	visible func(idColumn string) string
+}
+
+// unionByKind runs body once per kind, tagging each row with the kind index.
+// In body {join} and {entities} stand for the kind's join and entity tables,
+// {owner} and {entity} for the join table columns.
+func (q similarityQuery) unionByKind(body string, argsPerKind ...interface{}) (string, []interface{}) {
+	var selects []string
+	var args []interface{}
+	for i, k := range q.kinds {
+		s := strings.NewReplacer(
+			"{join}", k.relation.joinTable,
+			"{entities}", k.relation.entityTable,
+			"{owner}", k.relation.ownerCol,
+			"{entity}", k.relation.entityIDCol,
+		).Replace(body)
+		selects = append(selects, `SELECT `+strconv.Itoa(i)+` AS kind, `+s)
+		args = append(args, argsPerKind...)
+	}
+	return strings.Join(selects, " UNION ALL "), args
+}
+
+func (q similarityQuery) rank(db *sql.DB, userID, sourceID int64, limit int) ([]similarMatch, error) {
+	type feature struct {
+		kind   int
+		entity int64
+	}
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM (` + q.eligible + `)`).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	// attributes of the source item
+	query, args := q.unionByKind(`j.{entity}, e.name FROM {join} j
+		JOIN {entities} e ON e.id = j.{entity}
+		WHERE j.{owner} = ?`, sourceID)
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	sourceNames := make(map[feature]string)
+	for rows.Next() {
+		var f feature
+		var name string
+		if err := rows.Scan(&f.kind, &f.entity, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sourceNames[f] = name
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(sourceNames) == 0 {
+		return []similarMatch{}, nil
+	}
+
+	// every attribute of every item sharing at least one with the source
+	query, args = q.unionByKind(`j.{owner}, j.{entity} FROM {join} j
+		WHERE j.{owner} != ? AND j.{owner} IN (`+q.eligible+`)
+		AND j.{owner} IN (`+q.sharingQuery()+`)
+		AND `+q.visible("j.{owner}"), q.sharingArgs(sourceID)...)
+	rows, err = db.Query(withUser+query, userArgs(userID, args)...)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make(map[int64][]feature)
+	for rows.Next() {
+		var f feature
+		var owner int64
+		if err := rows.Scan(&f.kind, &owner, &f.entity); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		candidates[owner] = append(candidates[owner], f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// the candidates have every attribute that needs a weight
+	query, args = q.unionByKind(`j.{entity}, COUNT(*) FROM {join} j
+		WHERE j.{owner} IN (` + q.eligible + `)
+		GROUP BY j.{entity}`)
+	rows, err = db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	weights := make(map[feature]float64)
+	for rows.Next() {
+		var f feature
+		var df int
+		if err := rows.Scan(&f.kind, &f.entity, &df); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		weights[f] = q.kinds[f.kind].weight * math.Log(1+float64(total)/float64(df))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var sourceWeight float64
+	for f := range sourceNames {
+		sourceWeight += weights[f]
+	}
+
+	matches := make([]similarMatch, 0, len(candidates))
+	for id, features := range candidates {
+		var sharedWeight, candidateWeight float64
+		shared := SharedAttributes{}
+		for _, f := range features {
+			w := weights[f]
+			candidateWeight += w
+			if name, ok := sourceNames[f]; ok {
+				sharedWeight += w
+				kind := q.kinds[f.kind].relation.entityTable
+				shared[kind] = append(shared[kind], name)
+			}
+		}
+		union := sourceWeight + candidateWeight - sharedWeight
+		if union <= 0 || sharedWeight <= 0 {
+			continue
+		}
+		for _, names := range shared {
+			sort.Strings(names)
+		}
+		matches = append(matches, similarMatch{id: id, score: sharedWeight / union, shared: shared})
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].id > matches[j].id
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches, nil
+}
+
+// sharingQuery selects the items sharing at least one attribute of any kind
+// with the source item.
+func (q similarityQuery) sharingQuery() string {
+	var selects []string
+	for _, k := range q.kinds {
+		r := k.relation
+		selects = append(selects, `SELECT m.`+r.ownerCol+` FROM `+r.joinTable+` m
+			JOIN `+r.joinTable+` s ON s.`+r.entityIDCol+` = m.`+r.entityIDCol+`
+			WHERE s.`+r.ownerCol+` = ?`)
+	}
+	return strings.Join(selects, " UNION ")
+}
+
+// sharingArgs returns the excluded ID followed by the sharingQuery args.
+func (q similarityQuery) sharingArgs(sourceID int64) []interface{} {
+	args := []interface{}{sourceID}
+	for range q.kinds {
+		args = append(args, sourceID)
+	}
+	return args
+}
+
+type SimilarDoujinshi struct {
+	Doujinshi
+	Score  float64          `json:"score"`
+	Shared SharedAttributes `json:"shared"`
+}
+
+// GetSimilarDoujinshi returns up to limit synced doujinshi ranked by how
+// similar their metadata is to the given one, with the user's progress.
+func GetSimilarDoujinshi(db *sql.DB, userID, doujinshiID int64, limit int) ([]SimilarDoujinshi, error) {
+	q := similarityQuery{
+		kinds:    doujinshiSimilarityKinds,
+		eligible: `SELECT id FROM doujinshi WHERE folder_name IS NOT NULL AND folder_name != ''`,
+		visible:  doujinshiVisible,
+	}
+	matches, err := q.rank(db, userID, doujinshiID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(matches))
+	for i, m := range matches {
+		ids[i] = m.id
+	}
+	list, err := getDoujinshiByIDs(db, userID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]similarMatch, len(matches))
+	for _, m := range matches {
+		byID[m.id] = m
+	}
+	results := make([]SimilarDoujinshi, len(list))
+	for i, d := range list {
+		results[i] = SimilarDoujinshi{Doujinshi: d, Score: byID[d.ID].score, Shared: byID[d.ID].shared}
+	}
+	return results, nil
+}
+
+type SimilarImage struct {
+	Image
+	Score  float64          `json:"score"`
+	Shared SharedAttributes `json:"shared"`
+}
+
+// GetSimilarImages returns up to limit images ranked by how similar their
+// metadata is to the given one, with the user's progress.
+func GetSimilarImages(db *sql.DB, userID, imageID int64, limit int) ([]SimilarImage, error) {
+	q := similarityQuery{
+		kinds:    imageSimilarityKinds,
+		eligible: `SELECT id FROM images`,
+		visible:  imageVisible,
+	}
+	matches, err := q.rank(db, userID, imageID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(matches))
+	for i, m := range matches {
+		ids[i] = m.id
+	}
+	list, err := getImagesByIDs(db, userID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[int64]similarMatch, len(matches))
+	for _, m := range matches {
+		byID[m.id] = m
+	}
+	results := make([]SimilarImage, len(list))
+	for i, img := range list {
+		results[i] = SimilarImage{Image: img, Score: byID[img.ID].score, Shared: byID[img.ID].shared}
+	}
+	return results, nil
+}
@@ -14,10 +14,10 @@ type TagData struct {
 	ImageCount    int      `json:"imageCount"`
 }
 
-func GetAllTags(db *sql.DB) ([]TagData, error) {
+func GetAllTags(db *sql.DB, userID int64) ([]TagData, error) {
 	favoriteTagsSet := make(map[int64]bool)
-	favQuery := `SELECT tag_id FROM favorite_tags`
-	favRows, err := db.Query(favQuery)
+	favQuery := `SELECT tag_id FROM favorite_tags WHERE user_id = ?`
+	favRows, err := db.Query(favQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -34,7 +34,7 @@ func GetAllTags(db *sql.DB) ([]TagData, error) {
 		return nil, err
 	}
 
-	mainQuery := `
+	mainQuery := withUser + `
 	SELECT
 		t.id,
 		t.name,
@@ -47,25 +47,28 @@ func GetAllTags(db *sql.DB) ([]TagData, error) {
 	LEFT JOIN
 		doujinshi_tags dt ON t.id = dt.tag_id
 	LEFT JOIN
-		doujinshi d ON dt.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != ''
+		doujinshi d ON dt.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != '' AND ` + doujinshiVisible("d.id") + `
 	LEFT JOIN
 		(SELECT
 			 po.doujinshi_id,
 			 SUM(po.o_count) AS total_o_for_doujin
 		 FROM doujinshi_page_o po
+		 WHERE po.user_id = ` + currentUser + `
 		 GROUP BY po.doujinshi_id
 		) d_ocount ON d.id = d_ocount.doujinshi_id
 	LEFT JOIN
-		doujinshi_progress dp ON d.id = dp.doujinshi_id
+		doujinshi_progress dp ON d.id = dp.doujinshi_id AND dp.user_id = ` + currentUser + `
     LEFT JOIN
-        image_tags it ON t.id = it.tag_id
+        image_tags it ON t.id = it.tag_id AND ` + imageVisible("it.image_id") + `
+	WHERE
+		` + entityVisible("tags", "t.id") + `
 	GROUP BY
 		t.id, t.name
 	ORDER BY
 		t.name ASC;
 	`
 
-	allRows, err := db.Query(mainQuery)
+	allRows, err := db.Query(mainQuery, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -121,17 +124,17 @@ func GetTagIDByName(db *sql.DB, tagName string) (int64, error) {
 	return tagID, nil
 }
 
-func GetTagDetails(db *sql.DB, tagID int64) (*TagData, error) {
+func GetTagDetails(db *sql.DB, userID, tagID int64) (*TagData, error) {
 	var isFavorite bool
 	err := db.QueryRow(
-		`SELECT EXISTS(SELECT 1 FROM favorite_tags WHERE tag_id = ?)`,
-		tagID,
+		`SELECT EXISTS(SELECT 1 FROM favorite_tags WHERE user_id = ? AND tag_id = ?)`,
+		userID, tagID,
 	).Scan(&isFavorite)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, err
 	}
 
-	query := `
+	query := withUser + `
 	SELECT
 		t.id,
 		t.name,
@@ -143,25 +146,26 @@ func GetTagDetails(db *sql.DB, tagID int64) (*TagData, error) {
 	LEFT JOIN
 		doujinshi_tags dt ON t.id = dt.tag_id
 	LEFT JOIN
-		doujinshi d ON dt.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != ''
+		doujinshi d ON dt.doujinshi_id = d.id AND d.folder_name IS NOT NULL AND d.folder_name != '' AND ` + doujinshiVisible("d.id") + `
 	LEFT JOIN
 		(SELECT
 			 po.doujinshi_id,
 			 SUM(po.o_count) AS total_o_for_doujin
 		 FROM doujinshi_page_o po
+		 WHERE po.user_id = ` + currentUser + `
 		 GROUP BY po.doujinshi_id
 		) d_ocount ON d.id = d_ocount.doujinshi_id
 	LEFT JOIN
-		doujinshi_progress dp ON d.id = dp.doujinshi_id
+		doujinshi_progress dp ON d.id = dp.doujinshi_id AND dp.user_id = ` + currentUser + `
 	WHERE
-		t.id = ?
+		t.id = ? AND ` + entityVisible("tags", "t.id") + `
 	GROUP BY
 		t.id, t.name;
 	`
 	var tagInfo TagData
 	var avgRating sql.NullFloat64
 
-	err = db.QueryRow(query, tagID).Scan(
+	err = db.QueryRow(query, userID, tagID).Scan(
 		&tagInfo.ID,
 		&tagInfo.Name,
 		&tagInfo.DoujinCount,
@@ -183,15 +187,16 @@ func GetTagDetails(db *sql.DB, tagID int64) (*TagData, error) {
 	return &tagInfo, nil
 }
 
-func GetDoujinshiByTag(db *sql.DB, tagID int64) ([]Doujinshi, error) {
-	query := `
-		SELECT d.id, d.source, d.external_id, d.title, d.pages, d.uploaded, d.folder_name
+func GetDoujinshiByTag(db *sql.DB, userID, tagID int64) ([]Doujinshi, error) {
+	query := withUser + `
+		SELECT d.id, d.source, d.external_id, d.title, d.pages, d.uploaded, d.folder_name, d.library_root
 		FROM doujinshi d
 		JOIN doujinshi_tags dt ON d.id = dt.doujinshi_id
 		WHERE dt.tag_id = ? AND d.folder_name IS NOT NULL AND d.folder_name != ''
+			AND ` + doujinshiVisible("d.id") + `
 		ORDER BY d.uploaded DESC, d.title ASC
 	`
-	rows, err := db.Query(query, tagID)
+	rows, err := db.Query(query, userID, tagID)
 	if err != nil {
 		return nil, err
 	}
@@ -201,27 +206,33 @@ func GetDoujinshiByTag(db *sql.DB, tagID int64) ([]Doujinshi, error) {
 	for rows.Next() {
 		var d Doujinshi
 		if err := rows.Scan(&d.ID, &d.Source, &d.ExternalID, &d.Title, &d.Pages,
-			&d.Uploaded, &d.FolderName); err != nil {
+			&d.Uploaded, &d.FolderName, &d.LibraryRoot); err != nil {
 			return nil, err
 		}
 
-		populateDoujinshiDetails(db, &d)
 		results = append(results, d)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateDoujinshi(db, userID, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
 
-func GetImagesByTag(db *sql.DB, tagID int64) ([]Image, error) {
-	query := `
+func GetImagesByTag(db *sql.DB, userID, tagID int64) ([]Image, error) {
+	query := withUser + `
 		SELECT i.id, COALESCE(i.source, '') as source, COALESCE(i.external_id, '') as external_id,
 			i.filename, i.file_path, i.file_size, i.width, i.height, i.format, i.uploaded,
 			COALESCE(i.hash, '') as hash
 		FROM images i
 		JOIN image_tags it ON i.id = it.image_id
-		WHERE it.tag_id = ?
+		WHERE it.tag_id = ? AND ` + imageVisible("i.id") + `
 		ORDER BY i.uploaded DESC
 	`
-	rows, err := db.Query(query, tagID)
+	rows, err := db.Query(query, userID, tagID)
 	if err != nil {
 		return nil, err
 	}
@@ -235,8 +246,14 @@ func GetImagesByTag(db *sql.DB, tagID int64) ([]Image, error) {
 			&img.Format, &img.Uploaded, &img.Hash); err != nil {
 			return nil, err
 		}
-		populateImageDetails(db, &img)
 		results = append(results, img)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := hydrateImages(db, userID, results); err != nil {
+		return nil, err
+	}
 	return results, nil
 }
@@ -0,0 +1,829 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// An export of a user's data is marked with UserDataFormat and
+// UserDataVersion. Bump the version when the format changes, imports refuse
+// versions newer than theirs.
+const (
+	UserDataFormat  = "h_save-user-data"
+	UserDataVersion = 1
+)
+
+// Conflict policies of ImportUserData, for values both the database and the
+// export have. Newest keeps the one changed last, max the larger number and
+// the newest of what isn't a number, overwrite the one of the export.
+const (
+	ConflictNewest    = "newest"
+	ConflictMax       = "max"
+	ConflictOverwrite = "overwrite"
+)
+
+var (
+	// ErrInvalidUserData wraps what is wrong with an export.
+	ErrInvalidUserData = errors.New("not a valid h_save user data export")
+	ErrUnknownPolicy   = errors.New("the conflict policy must be newest, max or overwrite")
+)
+
+// UserData is what a user did in the library, keyed so it can be imported in
+// another one: doujinshi by source and external ID, images by content hash
+// and tags, artists, ... by name. Images without a hash can't be exported.
+type UserData struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exportedAt"`
+	Username   string    `json:"username"`
+
+	Doujinshi []DoujinshiUserData `json:"doujinshi"`
+	Images    []ImageUserData     `json:"images"`
+	// Favorites has the names of the favorite tags, artists, characters,
+	// parodies, groups, languages and categories, by kind
+	Favorites    map[string][]string   `json:"favorites"`
+	SavedFilters []SavedFilterUserData `json:"savedFilters"`
+}
+
+type DoujinshiUserData struct {
+	Source     string `json:"source"`
+	ExternalID string `json:"externalId"`
+	// Title only tells which doujinshi is missing from the library
+	Title       string               `json:"title"`
+	Progress    *ProgressUserData    `json:"progress,omitempty"`
+	PageOCounts []PageOCountUserData `json:"pageOCounts,omitempty"`
+	Bookmarks   []BookmarkUserData   `json:"bookmarks,omitempty"`
+}
+
+type ProgressUserData struct {
+	Rating    *int       `json:"rating"`
+	LastPage  *int       `json:"lastPage"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+}
+
+type PageOCountUserData struct {
+	Filename  string     `json:"filename"`
+	OCount    int        `json:"oCount"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+}
+
+type BookmarkUserData struct {
+	Filename  string     `json:"filename"`
+	Name      string     `json:"name"`
+	CreatedAt *time.Time `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt"`
+}
+
+type ImageUserData struct {
+	Hash string `json:"hash"`
+	// Filename only tells which image is missing from the library
+	Filename    string                 `json:"filename"`
+	Progress    *ImageProgressUserData `json:"progress,omitempty"`
+	Favorite    bool                   `json:"favorite"`
+	FavoritedAt *time.Time             `json:"favoritedAt,omitempty"`
+}
+
+type ImageProgressUserData struct {
+	Rating     *int       `json:"rating"`
+	OCount     int        `json:"oCount"`
+	ViewCount  int        `json:"viewCount"`
+	LastViewed *time.Time `json:"lastViewed"`
+	UpdatedAt  *time.Time `json:"updatedAt"`
+}
+
+type SavedFilterUserData struct {
+	Name      string          `json:"name"`
+	Kind      string          `json:"kind"`
+	Filters   json.RawMessage `json:"filters"`
+	CreatedAt *time.Time      `json:"createdAt"`
+	UpdatedAt *time.Time      `json:"updatedAt"`
+}
+
+// favoriteRelations are the kinds of entities users can favorite, each in a
+// favorite_<entity table> table.
+var favoriteRelations = []entityRelation{
+	doujinshiTags, doujinshiArtists, doujinshiCharacters, doujinshiParodies,
+	doujinshiGroups, doujinshiLanguages, doujinshiCategories,
+}
+
+// ExportUserData collects the progress, o-counts, bookmarks, favorites and
+// saved filters of a user.
+func ExportUserData(db *sql.DB, userID int64) (UserData, error) {
+	user, err := GetUser(db, userID)
+	if err != nil {
+		return UserData{}, err
+	}
+	data := UserData{
+		Format:       UserDataFormat,
+		Version:      UserDataVersion,
+		ExportedAt:   time.Now().UTC(),
+		Username:     user.Username,
+		Doujinshi:    []DoujinshiUserData{},
+		Images:       []ImageUserData{},
+		Favorites:    map[string][]string{},
+		SavedFilters: []SavedFilterUserData{},
+	}
+
+	if err := exportDoujinshiUserData(db, userID, &data); err != nil {
+		return data, err
+	}
+	if err := exportImageUserData(db, userID, &data); err != nil {
+		return data, err
+	}
+
+	for _, r := range favoriteRelations {
+		names := []string{}
+		err := queryEach(db, `SELECT e.name FROM favorite_`+r.entityTable+` f
+			JOIN `+r.entityTable+` e ON e.id = f.`+r.entityIDCol+`
+			WHERE f.user_id = ? AND e.name IS NOT NULL ORDER BY e.name`,
+			[]interface{}{userID}, func(rows *sql.Rows) error {
+				var name string
+				if err := rows.Scan(&name); err != nil {
+					return err
+				}
+				names = append(names, name)
+				return nil
+			})
+		if err != nil {
+			return data, err
+		}
+		data.Favorites[r.entityTable] = names
+	}
+
+	err = queryEach(db, `SELECT name, kind, filters_json, created_at, updated_at
+		FROM saved_filters WHERE user_id = ? ORDER BY name`,
+		[]interface{}{userID}, func(rows *sql.Rows) error {
+			var sf SavedFilterUserData
+			var filtersJSON string
+			var createdAt, updatedAt sql.NullTime
+			if err := rows.Scan(&sf.Name, &sf.Kind, &filtersJSON, &createdAt, &updatedAt); err != nil {
+				return err
+			}
+			sf.Filters = json.RawMessage(filtersJSON)
+			sf.CreatedAt, sf.UpdatedAt = timePtr(createdAt), timePtr(updatedAt)
+			data.SavedFilters = append(data.SavedFilters, sf)
+			return nil
+		})
+	return data, err
+}
+
+func exportDoujinshiUserData(db *sql.DB, userID int64, data *UserData) error {
+	byID := make(map[int64]*DoujinshiUserData)
+	var ids []int64
+	doujinshi := func(id int64, source, externalID, title string) *DoujinshiUserData {
+		d, ok := byID[id]
+		if !ok {
+			d = &DoujinshiUserData{Source: source, ExternalID: externalID, Title: title}
+			byID[id] = d
+			ids = append(ids, id)
+		}
+		return d
+	}
+
+	const columns = `d.id, d.source, d.external_id, COALESCE(d.title, '')`
+	err := queryEach(db, `SELECT `+columns+`, p.rating, p.last_page, p.updated_at
+		FROM doujinshi_progress p JOIN doujinshi d ON d.id = p.doujinshi_id
+		WHERE p.user_id = ?`,
+		[]interface{}{userID}, func(rows *sql.Rows) error {
+			var id int64
+			var source, externalID, title string
+			var rating, lastPage sql.NullInt64
+			var updatedAt sql.NullTime
+			if err := rows.Scan(&id, &source, &externalID, &title, &rating, &lastPage, &updatedAt); err != nil {
+				return err
+			}
+			doujinshi(id, source, externalID, title).Progress = &ProgressUserData{
+				Rating: intPtr(rating), LastPage: intPtr(lastPage), UpdatedAt: timePtr(updatedAt),
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	err = queryEach(db, `SELECT `+columns+`, o.filename, COALESCE(o.o_count, 0), o.updated_at
+		FROM doujinshi_page_o o JOIN doujinshi d ON d.id = o.doujinshi_id
+		WHERE o.user_id = ? AND o.o_count > 0 ORDER BY o.filename`,
+		[]interface{}{userID}, func(rows *sql.Rows) error {
+			var id int64
+			var source, externalID, title string
+			var o PageOCountUserData
+			var updatedAt sql.NullTime
+			if err := rows.Scan(&id, &source, &externalID, &title, &o.Filename, &o.OCount, &updatedAt); err != nil {
+				return err
+			}
+			o.UpdatedAt = timePtr(updatedAt)
+			d := doujinshi(id, source, externalID, title)
+			d.PageOCounts = append(d.PageOCounts, o)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	err = queryEach(db, `SELECT `+columns+`, b.filename, COALESCE(b.name, ''), b.created_at, b.updated_at
+		FROM doujinshi_bookmarks b JOIN doujinshi d ON d.id = b.doujinshi_id
+		WHERE b.user_id = ? ORDER BY b.filename`,
+		[]interface{}{userID}, func(rows *sql.Rows) error {
+			var id int64
+			var source, externalID, title string
+			var b BookmarkUserData
+			var createdAt, updatedAt sql.NullTime
+			if err := rows.Scan(&id, &source, &externalID, &title, &b.Filename, &b.Name, &createdAt, &updatedAt); err != nil {
+				return err
+			}
+			b.CreatedAt, b.UpdatedAt = timePtr(createdAt), timePtr(updatedAt)
+			d := doujinshi(id, source, externalID, title)
+			d.Bookmarks = append(d.Bookmarks, b)
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		data.Doujinshi = append(data.Doujinshi, *byID[id])
+	}
+	return nil
+}
+
+// exportImageUserData merges images with the same hash, the first one's
+// progress is kept.
+func exportImageUserData(db *sql.DB, userID int64, data *UserData) error {
+	byHash := make(map[string]*ImageUserData)
+	var hashes []string
+	image := func(hash, filename string) *ImageUserData {
+		img, ok := byHash[hash]
+		if !ok {
+			img = &ImageUserData{Hash: hash, Filename: filename}
+			byHash[hash] = img
+			hashes = append(hashes, hash)
+		}
+		return img
+	}
+
+	err := queryEach(db, `SELECT i.hash, i.filename, p.rating, COALESCE(p.o_count, 0),
+			COALESCE(p.view_count, 0), p.last_viewed, p.updated_at
+		FROM image_progress p JOIN images i ON i.id = p.image_id
+		WHERE p.user_id = ? AND COALESCE(i.hash, '') != '' ORDER BY i.id`,
+		[]interface{}{userID}, func(rows *sql.Rows) error {
+			var hash, filename string
+			var p ImageProgressUserData
+			var rating sql.NullInt64
+			var lastViewed, updatedAt sql.NullTime
+			if err := rows.Scan(&hash, &filename, &rating, &p.OCount, &p.ViewCount, &lastViewed, &updatedAt); err != nil {
+				return err
+			}
+			p.Rating, p.LastViewed, p.UpdatedAt = intPtr(rating), timePtr(lastViewed), timePtr(updatedAt)
+			if img := image(hash, filename); img.Progress == nil {
+				img.Progress = &p
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	err = queryEach(db, `SELECT i.hash, i.filename, f.added_at
+		FROM favorite_images f JOIN images i ON i.id = f.image_id
+		WHERE f.user_id = ? AND COALESCE(i.hash, '') != '' ORDER BY i.id`,
+		[]interface{}{userID}, func(rows *sql.Rows) error {
+			var hash, filename string
+			var addedAt sql.NullTime
+			if err := rows.Scan(&hash, &filename, &addedAt); err != nil {
+				return err
+			}
+			if img := image(hash, filename); !img.Favorite {
+				img.Favorite, img.FavoritedAt = true, timePtr(addedAt)
+			}
+			return nil
+		})
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		data.Images = append(data.Images, *byHash[hash])
+	}
+	return nil
+}
+
+// UserDataImportReport counts what an import did, or would do on a dry run.
+type UserDataImportReport struct {
+	DryRun bool   `json:"dryRun"`
+	Policy string `json:"policy"`
+
+	Progress       ImportCounts `json:"progress"`
+	PageOCounts    ImportCounts `json:"pageOCounts"`
+	Bookmarks      ImportCounts `json:"bookmarks"`
+	ImageProgress  ImportCounts `json:"imageProgress"`
+	FavoriteImages ImportCounts `json:"favoriteImages"`
+	Favorites      ImportCounts `json:"favorites"`
+	SavedFilters   ImportCounts `json:"savedFilters"`
+
+	// What the export refers to but this library doesn't have, their data
+	// is skipped
+	MissingDoujinshi []MissingDoujinshi `json:"missingDoujinshi"`
+	MissingImages    []MissingImage     `json:"missingImages"`
+	// MissingEntities has the names of the favorites that don't exist, by
+	// kind
+	MissingEntities map[string][]string `json:"missingEntities"`
+}
+
+type ImportCounts struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	// Kept were already there and are left as they were
+	Kept int `json:"kept"`
+	// Skipped belong to something missing from the library
+	Skipped int `json:"skipped"`
+}
+
+type MissingDoujinshi struct {
+	Source     string `json:"source"`
+	ExternalID string `json:"externalId"`
+	Title      string `json:"title"`
+}
+
+type MissingImage struct {
+	Hash     string `json:"hash"`
+	Filename string `json:"filename"`
+}
+
+// ValidConflictPolicy tells whether policy is one of the Conflict* policies.
+func ValidConflictPolicy(policy string) bool {
+	return policy == ConflictNewest || policy == ConflictMax || policy == ConflictOverwrite
+}
+
+// ImportUserData merges an export into the data of a user. What only one
+// side has is kept, favorites are added up, and values both have are picked
+// by policy. Doujinshi, images and entities missing from the library are
+// skipped and reported. Everything is done in one transaction, which a dry
+// run rolls back.
+func ImportUserData(db *sql.DB, userID int64, data UserData, policy string, dryRun bool) (UserDataImportReport, error) {
+	report := UserDataImportReport{
+		DryRun:           dryRun,
+		Policy:           policy,
+		MissingDoujinshi: []MissingDoujinshi{},
+		MissingImages:    []MissingImage{},
+		MissingEntities:  map[string][]string{},
+	}
+	if !ValidConflictPolicy(policy) {
+		return report, ErrUnknownPolicy
+	}
+	if err := data.validate(); err != nil {
+		return report, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	imp := userDataImport{tx: tx, userID: userID, policy: policy, report: &report}
+	for _, d := range data.Doujinshi {
+		if err := imp.doujinshi(d); err != nil {
+			return report, fmt.Errorf("doujinshi %s %s: %w", d.Source, d.ExternalID, err)
+		}
+	}
+	for _, img := range data.Images {
+		if err := imp.image(img); err != nil {
+			return report, fmt.Errorf("image %s: %w", img.Hash, err)
+		}
+	}
+	for _, r := range favoriteRelations {
+		for _, name := range data.Favorites[r.entityTable] {
+			if err := imp.favorite(r, name); err != nil {
+				return report, fmt.Errorf("favorite %s %q: %w", r.entityTable, name, err)
+			}
+		}
+	}
+	for _, sf := range data.SavedFilters {
+		if err := imp.savedFilter(sf); err != nil {
+			return report, fmt.Errorf("saved filter %q: %w", sf.Name, err)
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+func (data UserData) validate() error {
+	if data.Format != UserDataFormat {
+		return fmt.Errorf("%w: the format is %q instead of %q", ErrInvalidUserData, data.Format, UserDataFormat)
+	}
+	if data.Version < 1 || data.Version > UserDataVersion {
+		return fmt.Errorf("%w: it is version %d but this build only reads up to %d",
+			ErrInvalidUserData, data.Version, UserDataVersion)
+	}
+	for _, d := range data.Doujinshi {
+		if d.Source == "" || d.ExternalID == "" {
+			return fmt.Errorf("%w: a doujinshi has no source or external ID", ErrInvalidUserData)
+		}
+		for _, o := range d.PageOCounts {
+			if o.Filename == "" {
+				return fmt.Errorf("%w: an o-count of %s %s has no filename", ErrInvalidUserData, d.Source, d.ExternalID)
+			}
+		}
+		for _, b := range d.Bookmarks {
+			if b.Filename == "" {
+				return fmt.Errorf("%w: a bookmark of %s %s has no filename", ErrInvalidUserData, d.Source, d.ExternalID)
+			}
+		}
+	}
+	for _, img := range data.Images {
+		if img.Hash == "" {
+			return fmt.Errorf("%w: an image has no hash", ErrInvalidUserData)
+		}
+	}
+	for kind := range data.Favorites {
+		known := false
+		for _, r := range favoriteRelations {
+			known = known || r.entityTable == kind
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown kind of favorites %q", ErrInvalidUserData, kind)
+		}
+	}
+	for _, sf := range data.SavedFilters {
+		if sf.Name == "" {
+			return fmt.Errorf("%w: a saved filter has no name", ErrInvalidUserData)
+		}
+		if _, err := sf.filtersJSON(); err != nil {
+			return fmt.Errorf("%w: saved filter %q: %v", ErrInvalidUserData, sf.Name, err)
+		}
+	}
+	return nil
+}
+
+// filtersJSON checks the filters against their kind and returns them as
+// stored in saved_filters.
+func (sf SavedFilterUserData) filtersJSON() (string, error) {
+	filter := SavedFilter{Kind: normalizeKind(sf.Kind)}
+	if filter.Kind != SavedFilterDoujinshi && filter.Kind != SavedFilterImages {
+		return "", fmt.Errorf("unknown kind %q", sf.Kind)
+	}
+	if err := filter.scanFilters(string(sf.Filters)); err != nil {
+		return "", err
+	}
+	return filter.filtersJSON()
+}
+
+type userDataImport struct {
+	tx     *sql.Tx
+	userID int64
+	policy string
+	report *UserDataImportReport
+}
+
+func (imp *userDataImport) doujinshi(d DoujinshiUserData) error {
+	var id int64
+	err := imp.tx.QueryRow(`SELECT id FROM doujinshi WHERE source = ? AND external_id = ?`,
+		d.Source, d.ExternalID).Scan(&id)
+	if err == sql.ErrNoRows {
+		imp.report.MissingDoujinshi = append(imp.report.MissingDoujinshi,
+			MissingDoujinshi{Source: d.Source, ExternalID: d.ExternalID, Title: d.Title})
+		if d.Progress != nil {
+			imp.report.Progress.Skipped++
+		}
+		imp.report.PageOCounts.Skipped += len(d.PageOCounts)
+		imp.report.Bookmarks.Skipped += len(d.Bookmarks)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if d.Progress != nil {
+		if err := imp.progress(id, *d.Progress); err != nil {
+			return err
+		}
+	}
+	for _, o := range d.PageOCounts {
+		if err := imp.pageOCount(id, o); err != nil {
+			return err
+		}
+	}
+	for _, b := range d.Bookmarks {
+		if err := imp.bookmark(id, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (imp *userDataImport) progress(doujinshiID int64, p ProgressUserData) error {
+	var rating, lastPage sql.NullInt64
+	var updatedAt sql.NullTime
+	err := imp.tx.QueryRow(`SELECT rating, last_page, updated_at FROM doujinshi_progress
+		WHERE user_id = ? AND doujinshi_id = ?`, imp.userID, doujinshiID).Scan(&rating, &lastPage, &updatedAt)
+	if err == sql.ErrNoRows {
+		imp.report.Progress.Added++
+		_, err = imp.tx.Exec(`INSERT INTO doujinshi_progress (user_id, doujinshi_id, rating, last_page, updated_at)
+			VALUES (?, ?, ?, ?, ?)`, imp.userID, doujinshiID, p.Rating, p.LastPage, p.UpdatedAt)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	takeImported := imp.takeImported(timePtr(updatedAt), p.UpdatedAt)
+	newRating := imp.pickInt(intPtr(rating), p.Rating, takeImported)
+	newLastPage := imp.pickInt(intPtr(lastPage), p.LastPage, takeImported)
+	if sameInt(newRating, intPtr(rating)) && sameInt(newLastPage, intPtr(lastPage)) {
+		imp.report.Progress.Kept++
+		return nil
+	}
+	imp.report.Progress.Updated++
+	_, err = imp.tx.Exec(`UPDATE doujinshi_progress SET rating = ?, last_page = ?, updated_at = ?
+		WHERE user_id = ? AND doujinshi_id = ?`,
+		newRating, newLastPage, laterTime(timePtr(updatedAt), p.UpdatedAt), imp.userID, doujinshiID)
+	return err
+}
+
+func (imp *userDataImport) pageOCount(doujinshiID int64, o PageOCountUserData) error {
+	var oCount int
+	var updatedAt sql.NullTime
+	err := imp.tx.QueryRow(`SELECT COALESCE(o_count, 0), updated_at FROM doujinshi_page_o
+		WHERE user_id = ? AND doujinshi_id = ? AND filename = ?`,
+		imp.userID, doujinshiID, o.Filename).Scan(&oCount, &updatedAt)
+	if err == sql.ErrNoRows {
+		imp.report.PageOCounts.Added++
+		_, err = imp.tx.Exec(`INSERT INTO doujinshi_page_o (user_id, doujinshi_id, filename, o_count, updated_at)
+			VALUES (?, ?, ?, ?, ?)`, imp.userID, doujinshiID, o.Filename, o.OCount, o.UpdatedAt)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	newOCount := *imp.pickInt(&oCount, &o.OCount, imp.takeImported(timePtr(updatedAt), o.UpdatedAt))
+	if newOCount == oCount {
+		imp.report.PageOCounts.Kept++
+		return nil
+	}
+	imp.report.PageOCounts.Updated++
+	_, err = imp.tx.Exec(`UPDATE doujinshi_page_o SET o_count = ?, updated_at = ?
+		WHERE user_id = ? AND doujinshi_id = ? AND filename = ?`,
+		newOCount, laterTime(timePtr(updatedAt), o.UpdatedAt), imp.userID, doujinshiID, o.Filename)
+	return err
+}
+
+// bookmark compares when the bookmarks were last renamed, or else created.
+func (imp *userDataImport) bookmark(doujinshiID int64, b BookmarkUserData) error {
+	var name sql.NullString
+	var createdAt, updatedAt sql.NullTime
+	err := imp.tx.QueryRow(`SELECT name, created_at, updated_at FROM doujinshi_bookmarks
+		WHERE user_id = ? AND doujinshi_id = ? AND filename = ?`,
+		imp.userID, doujinshiID, b.Filename).Scan(&name, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		imp.report.Bookmarks.Added++
+		_, err = imp.tx.Exec(`INSERT INTO doujinshi_bookmarks (user_id, doujinshi_id, filename, name, created_at, updated_at)
+			VALUES (?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP), ?)`,
+			imp.userID, doujinshiID, b.Filename, b.Name, b.CreatedAt, b.UpdatedAt)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	changedAt := laterTime(timePtr(createdAt), timePtr(updatedAt))
+	importedChangedAt := laterTime(b.CreatedAt, b.UpdatedAt)
+	if name.String == b.Name || !imp.takeImported(changedAt, importedChangedAt) {
+		imp.report.Bookmarks.Kept++
+		return nil
+	}
+	imp.report.Bookmarks.Updated++
+	_, err = imp.tx.Exec(`UPDATE doujinshi_bookmarks SET name = ?, updated_at = ?
+		WHERE user_id = ? AND doujinshi_id = ? AND filename = ?`,
+		b.Name, laterTime(changedAt, importedChangedAt), imp.userID, doujinshiID, b.Filename)
+	return err
+}
+
+func (imp *userDataImport) image(img ImageUserData) error {
+	var id int64
+	err := imp.tx.QueryRow(`SELECT id FROM images WHERE hash = ? ORDER BY id LIMIT 1`, img.Hash).Scan(&id)
+	if err == sql.ErrNoRows {
+		imp.report.MissingImages = append(imp.report.MissingImages, MissingImage{Hash: img.Hash, Filename: img.Filename})
+		if img.Progress != nil {
+			imp.report.ImageProgress.Skipped++
+		}
+		if img.Favorite {
+			imp.report.FavoriteImages.Skipped++
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if img.Progress != nil {
+		if err := imp.imageProgress(id, *img.Progress); err != nil {
+			return err
+		}
+	}
+	if img.Favorite {
+		result, err := imp.tx.Exec(`INSERT OR IGNORE INTO favorite_images (user_id, image_id, added_at)
+			VALUES (?, ?, COALESCE(?, CURRENT_TIMESTAMP))`, imp.userID, id, img.FavoritedAt)
+		if err != nil {
+			return err
+		}
+		countAdded(&imp.report.FavoriteImages, result)
+	}
+	return nil
+}
+
+func (imp *userDataImport) imageProgress(imageID int64, p ImageProgressUserData) error {
+	var rating sql.NullInt64
+	var oCount, viewCount int
+	var lastViewed, updatedAt sql.NullTime
+	err := imp.tx.QueryRow(`SELECT rating, COALESCE(o_count, 0), COALESCE(view_count, 0), last_viewed, updated_at
+		FROM image_progress WHERE user_id = ? AND image_id = ?`, imp.userID, imageID).
+		Scan(&rating, &oCount, &viewCount, &lastViewed, &updatedAt)
+	if err == sql.ErrNoRows {
+		imp.report.ImageProgress.Added++
+		_, err = imp.tx.Exec(`INSERT INTO image_progress (user_id, image_id, rating, o_count, view_count, last_viewed, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			imp.userID, imageID, p.Rating, p.OCount, p.ViewCount, p.LastViewed, p.UpdatedAt)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	takeImported := imp.takeImported(timePtr(updatedAt), p.UpdatedAt)
+	newRating := imp.pickInt(intPtr(rating), p.Rating, takeImported)
+	newOCount := *imp.pickInt(&oCount, &p.OCount, takeImported)
+	newViewCount := *imp.pickInt(&viewCount, &p.ViewCount, takeImported)
+	// the last view is a time, the later one is kept unless overwriting
+	newLastViewed := laterTime(timePtr(lastViewed), p.LastViewed)
+	if imp.policy == ConflictOverwrite && p.LastViewed != nil {
+		newLastViewed = p.LastViewed
+	}
+	if sameInt(newRating, intPtr(rating)) && newOCount == oCount && newViewCount == viewCount &&
+		sameTime(newLastViewed, timePtr(lastViewed)) {
+		imp.report.ImageProgress.Kept++
+		return nil
+	}
+	imp.report.ImageProgress.Updated++
+	_, err = imp.tx.Exec(`UPDATE image_progress SET rating = ?, o_count = ?, view_count = ?, last_viewed = ?, updated_at = ?
+		WHERE user_id = ? AND image_id = ?`,
+		newRating, newOCount, newViewCount, newLastViewed, laterTime(timePtr(updatedAt), p.UpdatedAt),
+		imp.userID, imageID)
+	return err
+}
+
+// favorite matches the name case-insensitively, like the filters.
+func (imp *userDataImport) favorite(r entityRelation, name string) error {
+	var id int64
+	err := imp.tx.QueryRow(`SELECT id FROM `+r.entityTable+` WHERE name = ? COLLATE NOCASE
+		ORDER BY name = ? DESC LIMIT 1`, name, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		imp.report.MissingEntities[r.entityTable] = append(imp.report.MissingEntities[r.entityTable], name)
+		imp.report.Favorites.Skipped++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := imp.tx.Exec(`INSERT OR IGNORE INTO favorite_`+r.entityTable+` (user_id, `+r.entityIDCol+`)
+		VALUES (?, ?)`, imp.userID, id)
+	if err != nil {
+		return err
+	}
+	countAdded(&imp.report.Favorites, result)
+	return nil
+}
+
+// savedFilter matches filters by name. What they select isn't a number, so
+// max keeps the newest like for bookmarks.
+func (imp *userDataImport) savedFilter(sf SavedFilterUserData) error {
+	filtersJSON, err := sf.filtersJSON()
+	if err != nil {
+		return err
+	}
+	kind := normalizeKind(sf.Kind)
+
+	var currentKind, currentJSON string
+	var createdAt, updatedAt sql.NullTime
+	err = imp.tx.QueryRow(`SELECT kind, filters_json, created_at, updated_at FROM saved_filters
+		WHERE user_id = ? AND name = ?`, imp.userID, sf.Name).Scan(&currentKind, &currentJSON, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows {
+		imp.report.SavedFilters.Added++
+		_, err = imp.tx.Exec(`INSERT INTO saved_filters (user_id, name, filters_json, kind, created_at, updated_at)
+			VALUES (?, ?, ?, ?, COALESCE(?, CURRENT_TIMESTAMP), ?)`,
+			imp.userID, sf.Name, filtersJSON, kind, sf.CreatedAt, sf.UpdatedAt)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	// compared as written by filtersJSON, rows saved by older versions may
+	// have been written differently
+	if normalized, err := (SavedFilterUserData{Kind: currentKind, Filters: json.RawMessage(currentJSON)}).filtersJSON(); err == nil {
+		currentJSON = normalized
+	}
+	changedAt := laterTime(timePtr(createdAt), timePtr(updatedAt))
+	importedChangedAt := laterTime(sf.CreatedAt, sf.UpdatedAt)
+	if (currentKind == kind && currentJSON == filtersJSON) || !imp.takeImported(changedAt, importedChangedAt) {
+		imp.report.SavedFilters.Kept++
+		return nil
+	}
+	imp.report.SavedFilters.Updated++
+	_, err = imp.tx.Exec(`UPDATE saved_filters SET kind = ?, filters_json = ?, updated_at = ?
+		WHERE user_id = ? AND name = ?`,
+		kind, filtersJSON, laterTime(changedAt, importedChangedAt), imp.userID, sf.Name)
+	return err
+}
+
+// takeImported tells whether a value of the export replaces the one in the
+// database, for what isn't compared as a number. A value without a time is
+// older than any other.
+func (imp *userDataImport) takeImported(changedAt, importedChangedAt *time.Time) bool {
+	if imp.policy == ConflictOverwrite {
+		return true
+	}
+	return importedChangedAt != nil && (changedAt == nil || importedChangedAt.After(*changedAt))
+}
+
+// pickInt returns the value to keep of a number, a missing one never
+// replaces the other.
+func (imp *userDataImport) pickInt(current, imported *int, takeImported bool) *int {
+	switch {
+	case imported == nil:
+		return current
+	case current == nil:
+		return imported
+	case imp.policy == ConflictMax:
+		if *imported > *current {
+			return imported
+		}
+		return current
+	case takeImported:
+		return imported
+	}
+	return current
+}
+
+func countAdded(counts *ImportCounts, result sql.Result) {
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		counts.Added++
+	} else {
+		counts.Kept++
+	}
+}
+
+func queryEach(q queryer, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func intPtr(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	v := int(n.Int64)
+	return &v
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	v := t.Time.UTC()
+	return &v
+}
+
+func sameInt(a, b *int) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func sameTime(a, b *time.Time) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && a.Equal(*b))
+}
+
+func laterTime(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
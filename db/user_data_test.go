@@ -0,0 +1,232 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/brayanMuniz/h_save/types"
+)
+
+func TestExportImportUserData(t *testing.T) {
+	source := newTestDB(t)
+	id := addTestDoujinshi(t, source, Doujinshi{Title: "kept", Tags: []string{"vanilla"}})
+
+	rating, lastPage := 4, 12
+	if err := SetDoujinshiProgress(source, testUserID, strconv.FormatInt(id, 10), &rating, &lastPage); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetOCount(source, testUserID, id, "03.jpg", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddBookmark(source, testUserID, id, "07.jpg", "best page"); err != nil {
+		t.Fatal(err)
+	}
+	var tagID int64
+	if err := source.QueryRow(`SELECT id FROM tags WHERE name = 'vanilla'`).Scan(&tagID); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddFavoriteTag(source, testUserID, tagID); err != nil {
+		t.Fatal(err)
+	}
+	_, err := CreateSavedFilter(source, testUserID, SavedFilter{Name: "search", Filters: types.BrowseFilters{Search: "kept"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID := addTestDoujinshi(t, source, Doujinshi{Title: "other"})
+	if err := SetDoujinshiProgress(source, testUserID, strconv.FormatInt(otherID, 10), &rating, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := ExportUserData(source, testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the target only has one of the two doujinshi with progress
+	target := newTestDB(t)
+	addTestDoujinshi(t, target, Doujinshi{Title: "kept", Tags: []string{"vanilla"}})
+	report, err := ImportUserData(target, testUserID, exported, ConflictNewest, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]ImportCounts{
+		"progress":     report.Progress,
+		"pageOCounts":  report.PageOCounts,
+		"bookmarks":    report.Bookmarks,
+		"favorites":    report.Favorites,
+		"savedFilters": report.SavedFilters,
+	}
+	wantCounts := map[string]ImportCounts{
+		"progress":     {Added: 1, Skipped: 1},
+		"pageOCounts":  {Added: 1},
+		"bookmarks":    {Added: 1},
+		"favorites":    {Added: 1},
+		"savedFilters": {Added: 1},
+	}
+	if !reflect.DeepEqual(counts, wantCounts) {
+		t.Errorf("import counts = %+v, want %+v", counts, wantCounts)
+	}
+	if len(report.MissingDoujinshi) != 1 || report.MissingDoujinshi[0].Title != "other" {
+		t.Errorf("MissingDoujinshi = %+v, want other", report.MissingDoujinshi)
+	}
+
+	imported, err := ExportUserData(target, testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported.Doujinshi) != 1 {
+		t.Fatalf("imported %d doujinshi, want 1", len(imported.Doujinshi))
+	}
+	got := imported.Doujinshi[0]
+	if *got.Progress.Rating != 4 || *got.Progress.LastPage != 12 {
+		t.Errorf("progress = %d, page %d, want 4, page 12", *got.Progress.Rating, *got.Progress.LastPage)
+	}
+	if len(got.PageOCounts) != 1 || got.PageOCounts[0].OCount != 2 {
+		t.Errorf("PageOCounts = %+v, want 03.jpg at 2", got.PageOCounts)
+	}
+	if len(got.Bookmarks) != 1 || got.Bookmarks[0].Name != "best page" {
+		t.Errorf("Bookmarks = %+v, want best page", got.Bookmarks)
+	}
+	if !reflect.DeepEqual(imported.Favorites["tags"], []string{"vanilla"}) {
+		t.Errorf("favorite tags = %v, want [vanilla]", imported.Favorites["tags"])
+	}
+	if len(imported.SavedFilters) != 1 || imported.SavedFilters[0].Name != "search" {
+		t.Errorf("SavedFilters = %+v, want search", imported.SavedFilters)
+	}
+}
+
+// userDataFor is an export with the progress, the o-count of 01.jpg and the
+// bookmark of 02.jpg of the doujinshi "book", all changed at the same time.
+func userDataFor(rating, lastPage, oCount int, bookmark string, changedAt time.Time) UserData {
+	return UserData{
+		Format:  UserDataFormat,
+		Version: UserDataVersion,
+		Doujinshi: []DoujinshiUserData{{
+			Source:      "nhentai",
+			ExternalID:  "book",
+			Progress:    &ProgressUserData{Rating: &rating, LastPage: &lastPage, UpdatedAt: &changedAt},
+			PageOCounts: []PageOCountUserData{{Filename: "01.jpg", OCount: oCount, UpdatedAt: &changedAt}},
+			Bookmarks:   []BookmarkUserData{{Filename: "02.jpg", Name: bookmark, CreatedAt: &changedAt}},
+		}},
+	}
+}
+
+func TestImportUserDataPolicies(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	current := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+
+	type state struct {
+		rating, lastPage, oCount int
+		bookmark                 string
+	}
+	tests := []struct {
+		name     string
+		policy   string
+		imported UserData
+		want     state
+		// wantUpdated is the number of progress, o-count and bookmark rows
+		// changed, out of 3
+		wantUpdated int
+	}{
+		{"newest keeps the current values", ConflictNewest, userDataFor(5, 2, 1, "imported", older), state{3, 10, 4, "current"}, 0},
+		{"newest takes newer values", ConflictNewest, userDataFor(5, 2, 1, "imported", newer), state{5, 2, 1, "imported"}, 3},
+		{"max takes the larger numbers", ConflictMax, userDataFor(5, 2, 1, "imported", older), state{5, 10, 4, "current"}, 1},
+		{"max takes a newer bookmark", ConflictMax, userDataFor(1, 20, 9, "imported", newer), state{3, 20, 9, "imported"}, 3},
+		{"overwrite takes older values", ConflictOverwrite, userDataFor(5, 2, 1, "imported", older), state{5, 2, 1, "imported"}, 3},
+		{"same values are kept", ConflictOverwrite, userDataFor(3, 10, 4, "current", newer), state{3, 10, 4, "current"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			id := addTestDoujinshi(t, db, Doujinshi{Title: "book"})
+			if _, err := ImportUserData(db, testUserID, userDataFor(3, 10, 4, "current", current), ConflictOverwrite, false); err != nil {
+				t.Fatal(err)
+			}
+
+			report, err := ImportUserData(db, testUserID, tt.imported, tt.policy, false)
+			if err != nil {
+				t.Fatalf("ImportUserData() error = %v", err)
+			}
+			updated := report.Progress.Updated + report.PageOCounts.Updated + report.Bookmarks.Updated
+			kept := report.Progress.Kept + report.PageOCounts.Kept + report.Bookmarks.Kept
+			if updated != tt.wantUpdated || kept != 3-tt.wantUpdated {
+				t.Errorf("updated %d and kept %d rows, want %d and %d", updated, kept, tt.wantUpdated, 3-tt.wantUpdated)
+			}
+
+			var got state
+			err = db.QueryRow(`SELECT p.rating, p.last_page, o.o_count, b.name
+				FROM doujinshi_progress p
+				JOIN doujinshi_page_o o ON o.user_id = p.user_id AND o.doujinshi_id = p.doujinshi_id
+				JOIN doujinshi_bookmarks b ON b.user_id = p.user_id AND b.doujinshi_id = p.doujinshi_id
+				WHERE p.user_id = ? AND p.doujinshi_id = ?`, testUserID, id).
+				Scan(&got.rating, &got.lastPage, &got.oCount, &got.bookmark)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("after import = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportUserDataDryRun(t *testing.T) {
+	db := newTestDB(t)
+	addTestDoujinshi(t, db, Doujinshi{Title: "book"})
+
+	data := userDataFor(5, 2, 1, "imported", time.Now().UTC())
+	report, err := ImportUserData(db, testUserID, data, ConflictNewest, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.Progress.Added != 1 || report.PageOCounts.Added != 1 || report.Bookmarks.Added != 1 {
+		t.Errorf("report = %+v, want a dry run adding a progress, an o-count and a bookmark", report)
+	}
+
+	for _, table := range []string{"doujinshi_progress", "doujinshi_page_o", "doujinshi_bookmarks"} {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%s has %d rows after a dry run, want 0", table, n)
+		}
+	}
+}
+
+func TestImportUserDataErrors(t *testing.T) {
+	valid := userDataFor(5, 2, 1, "imported", time.Now().UTC())
+	tests := []struct {
+		name    string
+		policy  string
+		edit    func(data *UserData)
+		wantErr error
+	}{
+		{"unknown policy", "oldest", nil, ErrUnknownPolicy},
+		{"wrong format", ConflictNewest, func(data *UserData) { data.Format = "other" }, ErrInvalidUserData},
+		{"newer version", ConflictNewest, func(data *UserData) { data.Version = UserDataVersion + 1 }, ErrInvalidUserData},
+		{"doujinshi without source", ConflictNewest, func(data *UserData) { data.Doujinshi[0].Source = "" }, ErrInvalidUserData},
+		{"unknown favorites", ConflictNewest, func(data *UserData) { data.Favorites = map[string][]string{"colours": {"red"}} }, ErrInvalidUserData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			data := valid
+			data.Doujinshi = []DoujinshiUserData{valid.Doujinshi[0]}
+			if tt.edit != nil {
+				tt.edit(&data)
+			}
+			_, err := ImportUserData(db, testUserID, data, tt.policy, false)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ImportUserData() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrFolderExists is returned when the destination root already has a
+// folder of that name.
+var ErrFolderExists = errors.New("the destination already has a folder with that name")
+
+// MoveFolder moves the folder name from src into dst, which can be on
+// another disk. The folder is copied then removed in that case, and a failed
+// copy leaves src untouched.
+func MoveFolder(src, dst *Root, name string) error {
+	from, err := src.ResolveFolder(name)
+	if err != nil {
+		return err
+	}
+	dstDir, err := dst.Resolve("")
+	if err != nil {
+		return err
+	}
+	to := filepath.Join(dstDir, name)
+	if _, err := os.Lstat(to); err == nil {
+		return ErrFolderExists
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	err = os.Rename(from, to)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyTree(from, to); err != nil {
+		os.RemoveAll(to)
+		return err
+	}
+	return os.RemoveAll(from)
+}
+
+// copyTree copies the folders and regular files under from. Anything else,
+// like a symlink, could point out of the library and fails the copy.
+func copyTree(from, to string) error {
+	return filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, rel)
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.Mkdir(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		default:
+			return fmt.Errorf("can't copy %s, it is not a regular file", path)
+		}
+	})
+}
+
+func copyFile(from, to string, perm fs.FileMode) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,154 @@
+// Package library confines file access to the folders the library lives in.
+// Paths coming from requests or the database are resolved through a Root,
+// which rejects absolute paths, ".." and symlinks leading out of it.
+package library
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrInvalidPath is returned for absolute paths, paths with ".." and
+	// folder names containing a separator.
+	ErrInvalidPath = errors.New("invalid path")
+	// ErrOutsideRoot is returned when a path leads out of the root, e.g.
+	// through a symlink.
+	ErrOutsideRoot = errors.New("path is outside the library")
+	// ErrUnknownRoot is returned for a root name that isn't configured.
+	ErrUnknownRoot = errors.New("unknown library root")
+)
+
+// DefaultDoujinshiRoot names the main doujinshi root. Entries synced before
+// there were several roots live in it.
+const DefaultDoujinshiRoot = "doujinshi"
+
+type Root struct {
+	Name string
+	// Dir is the folder as configured, paths stored in the database start
+	// with it.
+	Dir string
+}
+
+func NewRoot(name, dir string) *Root {
+	return &Root{Name: name, Dir: filepath.Clean(dir)}
+}
+
+// realDir is the absolute root with symlinks resolved. It is looked up on
+// every call so the folder can be created after the server starts.
+func (r *Root) realDir() (string, error) {
+	abs, err := filepath.Abs(r.Dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// Resolve returns the real path of rel, a path relative to the root. An empty
+// rel is the root itself. Missing files give an error matching
+// fs.ErrNotExist.
+func (r *Root) Resolve(rel string) (string, error) {
+	if filepath.IsAbs(rel) || strings.HasPrefix(rel, "/") || strings.HasPrefix(rel, `\`) {
+		return "", ErrInvalidPath
+	}
+	for _, part := range strings.FieldsFunc(rel, isSeparator) {
+		if part == ".." {
+			return "", ErrInvalidPath
+		}
+	}
+
+	root, err := r.realDir()
+	if err != nil {
+		return "", err
+	}
+	return confine(root, filepath.Join(root, rel))
+}
+
+// ResolveFolder returns the real path of a folder directly inside the root.
+func (r *Root) ResolveFolder(name string) (string, error) {
+	if name == "" || name == "." || strings.ContainsFunc(name, isSeparator) {
+		return "", ErrInvalidPath
+	}
+	path, err := r.Resolve(name)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return path, nil
+}
+
+// Confine checks a path that is not relative to the root, like the file
+// paths stored for images, and returns its real path when it is inside the
+// root.
+func (r *Root) Confine(path string) (string, error) {
+	root, err := r.realDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return confine(root, abs)
+}
+
+// StoredPath turns a real path inside the root into the path stored in the
+// database, which starts with Dir.
+func (r *Root) StoredPath(realPath string) (string, error) {
+	root, err := r.realDir()
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, realPath)
+	if err != nil || !isLocal(rel) {
+		return "", ErrOutsideRoot
+	}
+	return filepath.Join(r.Dir, rel), nil
+}
+
+func confine(root, path string) (string, error) {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, real)
+	if err != nil || !isLocal(rel) {
+		return "", ErrOutsideRoot
+	}
+	return real, nil
+}
+
+func isLocal(rel string) bool {
+	return rel == "." || filepath.IsLocal(rel)
+}
+
+func isSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// Roots are the folders the server reads from.
+type Roots struct {
+	// Doujinshi are the doujinshi roots, the default one first
+	Doujinshi []*Root
+	Images    *Root
+}
+
+// DoujinshiRoot returns the doujinshi root called name.
+func (r Roots) DoujinshiRoot(name string) (*Root, error) {
+	for _, root := range r.Doujinshi {
+		if root.Name == name {
+			return root, nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownRoot, name)
+}
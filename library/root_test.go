@@ -0,0 +1,128 @@
+package library
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRoot builds a root with a folder, a file and symlinks, next to a
+// folder outside of it:
+//
+//	root/book/01.jpg
+//	root/inside -> root/book
+//	root/outside -> secret
+//	root/notes.txt
+//	secret/key.txt
+func newTestRoot(t *testing.T) (*Root, string) {
+	t.Helper()
+	base := t.TempDir()
+	dir := filepath.Join(base, "root")
+	secret := filepath.Join(base, "secret")
+
+	for _, d := range []string{filepath.Join(dir, "book"), secret} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{filepath.Join(dir, "book", "01.jpg"), filepath.Join(dir, "notes.txt"), filepath.Join(secret, "key.txt")} {
+		if err := os.WriteFile(f, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "book"), filepath.Join(dir, "inside")); err != nil {
+		t.Skip("symlinks are not supported:", err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "outside")); err != nil {
+		t.Fatal(err)
+	}
+
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRoot("test", dir), real
+}
+
+func TestResolve(t *testing.T) {
+	root, real := newTestRoot(t)
+
+	tests := []struct {
+		name    string
+		rel     string
+		want    string
+		wantErr error
+	}{
+		{"root", "", real, nil},
+		{"file", "book/01.jpg", filepath.Join(real, "book", "01.jpg"), nil},
+		{"symlink inside", "inside/01.jpg", filepath.Join(real, "book", "01.jpg"), nil},
+		{"dot dot", "../secret/key.txt", "", ErrInvalidPath},
+		{"dot dot in the middle", "book/../../secret", "", ErrInvalidPath},
+		{"absolute", filepath.Join(real, "notes.txt"), "", ErrInvalidPath},
+		{"leading slash", "/etc/passwd", "", ErrInvalidPath},
+		{"leading backslash", `\etc\passwd`, "", ErrInvalidPath},
+		{"backslash dot dot", `book\..\..\secret`, "", ErrInvalidPath},
+		{"symlink outside", "outside/key.txt", "", ErrOutsideRoot},
+		{"missing", "book/02.jpg", "", fs.ErrNotExist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := root.Resolve(tt.rel)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Resolve(%q) error = %v, want %v", tt.rel, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Resolve(%q) error = %v", tt.rel, err)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve(%q) = %q, want %q", tt.rel, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveFolder(t *testing.T) {
+	root, real := newTestRoot(t)
+
+	tests := []struct {
+		name    string
+		folder  string
+		want    string
+		wantErr error
+	}{
+		{"folder", "book", filepath.Join(real, "book"), nil},
+		{"symlink inside", "inside", filepath.Join(real, "book"), nil},
+		{"empty", "", "", ErrInvalidPath},
+		{"dot", ".", "", ErrInvalidPath},
+		{"dot dot", "..", "", ErrInvalidPath},
+		{"slash", "book/01.jpg", "", ErrInvalidPath},
+		{"backslash", `book\01.jpg`, "", ErrInvalidPath},
+		{"symlink outside", "outside", "", ErrOutsideRoot},
+		{"file", "notes.txt", "", fs.ErrNotExist},
+		{"missing", "other", "", fs.ErrNotExist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := root.ResolveFolder(tt.folder)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ResolveFolder(%q) error = %v, want %v", tt.folder, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveFolder(%q) error = %v", tt.folder, err)
+			}
+			if got != tt.want {
+				t.Errorf("ResolveFolder(%q) = %q, want %q", tt.folder, got, tt.want)
+			}
+		})
+	}
+}
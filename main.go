@@ -1,21 +1,154 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/brayanMuniz/h_save/config"
 	"github.com/brayanMuniz/h_save/db"
-	"github.com/brayanMuniz/h_save/routes"
 	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/term"
 	"log"
+	"os"
+	"strings"
 )
 
 func main() {
-	database, err := db.InitDB("./h_save.db")
+	cfg, args, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		printCommands(os.Stderr)
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+
+	// without a command the server is started
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printCommands(os.Stdout)
+		return
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		printCommands(os.Stderr)
+		os.Exit(2)
+	}
+
+	var database *sql.DB
+	if commandDatabase[cmd.name] != noDatabase {
+		if database, err = db.InitDB(cfg.DBPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	err = cmd.run(cfg, database, args)
+	if database != nil {
+		database.Close()
+	}
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "h_save %s: %v\n", name, err)
+		os.Exit(1)
+	}
+}
+
+// serverSecret reads H_SAVE_SECRET, or else the secret file. The file is
+// created on the first run and has to be kept with the database, the saved
+// provider credentials can't be decrypted without it.
+func serverSecret(serverSecretFile string) ([]byte, error) {
+	if secret := os.Getenv("H_SAVE_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+
+	secret, err := os.ReadFile(serverSecretFile)
+	if err == nil {
+		return bytes.TrimSpace(secret), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	secret = []byte(hex.EncodeToString(random))
+	if err := os.WriteFile(serverSecretFile, append(secret, '\n'), 0600); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// resetPassword reads a new password twice from stdin, stores it and logs out
+// every session of the user. The username can be left out when there is only
+// one user.
+func resetPassword(database *sql.DB, username string) (db.User, error) {
+	user, err := pickUser(database, username)
+	if err != nil {
+		return user, err
+	}
+
+	// a terminal doesn't echo the password, piped input is read line by line
+	in := bufio.NewReader(os.Stdin)
+	readLine := func(prompt string) (string, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+			password, err := term.ReadPassword(fd)
+			fmt.Fprintln(os.Stderr)
+			return string(password), err
+		}
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	password, err := readLine("New password: ")
 	if err != nil {
-		log.Fatal(err)
+		return user, err
+	}
+	confirm, err := readLine("Repeat new password: ")
+	if err != nil {
+		return user, err
+	}
+	if password != confirm {
+		return user, errors.New("passwords don't match")
+	}
+
+	if err := db.ChangePassword(database, user.ID, password); err != nil {
+		return user, err
+	}
+	return user, db.DeleteOtherSessions(database, user.ID, 0)
+}
+
+func pickUser(database *sql.DB, username string) (db.User, error) {
+	if username != "" {
+		user, err := db.GetUserByUsername(database, username)
+		if err == sql.ErrNoRows {
+			return user, fmt.Errorf("no user named %q", username)
+		}
+		return user, err
 	}
-	defer database.Close()
 
-	r := routes.SetupRouter(database)
-	if err := r.Run(":8080"); err != nil {
-		log.Fatal("Failed to start server:", err)
+	users, err := db.ListUsers(database)
+	if err != nil {
+		return db.User{}, err
+	}
+	if len(users) != 1 {
+		return db.User{}, errors.New("there are several users, pass the username")
 	}
+	return users[0], nil
 }
@@ -1,6 +1,7 @@
 package n
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -15,7 +16,29 @@ type HTTPConfig struct {
 	CsrfToken string
 }
 
-const saveTorrentsFolder = "download_me_senpai"
+// ErrSessionExpired means the cookies no longer log in, the site shows pages
+// as to a visitor.
+var ErrSessionExpired = errors.New("the nhentai session expired, save new cookies")
+
+// CheckSession loads rootURL with the cookies and returns the username of
+// the account they log in to.
+func CheckSession(rootURL string, http_config HTTPConfig) (string, error) {
+	if http_config.SessionId == "" {
+		return "", ErrSessionExpired
+	}
+	htmlPage, err := GetPageHTML(rootURL, http_config)
+	if err != nil {
+		return "", err
+	}
+	userName, err := ReturnUserNameFromHTML(htmlPage)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(userName) == "" {
+		return "", ErrSessionExpired
+	}
+	return strings.TrimSpace(userName), nil
+}
 
 func GetPageHTML(route string, http_config HTTPConfig) (string, error) {
 	req, _ := http.NewRequest("GET", route, nil)
@@ -71,8 +94,8 @@ func GetPublicHTML(route string) (string, error) {
 
 }
 
-// Download the .torrent file in the ./download_me_senpai folder
-func DownloadTorrentFile(downloadRoute, titleName string, http_config HTTPConfig) error {
+// Download the .torrent file in the saveFolder folder, created if missing
+func DownloadTorrentFile(downloadRoute, titleName, saveFolder string, http_config HTTPConfig) error {
 	req, _ := http.NewRequest("GET", downloadRoute, nil)
 	req.AddCookie(&http.Cookie{Name: "sessionid", Value: http_config.SessionId})
 	req.AddCookie(&http.Cookie{Name: "csrftoken", Value: http_config.CsrfToken})
@@ -80,7 +103,6 @@ func DownloadTorrentFile(downloadRoute, titleName string, http_config HTTPConfig
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Print(err)
 		return err
 	}
 	defer resp.Body.Close()
@@ -98,13 +120,18 @@ func DownloadTorrentFile(downloadRoute, titleName string, http_config HTTPConfig
 		return fmt.Errorf("Extension type is not torrent")
 	}
 
-	err = os.MkdirAll(saveTorrentsFolder, 0755) // r/w/e user, r/e for others
+	err = os.MkdirAll(saveFolder, 0755) // r/w/e user, r/e for others
 	if err != nil {
 		return err
 	}
 
-	fileTitle := titleName + extensionType
-	saveRoute := filepath.Join(saveTorrentsFolder, fileTitle)
+	// the title comes from the site, keep it from naming another folder
+	fileTitle := strings.NewReplacer("/", "_", `\`, "_").Replace(titleName)
+	if fileTitle == "." || fileTitle == ".." {
+		fileTitle = "_"
+	}
+	fileTitle += extensionType
+	saveRoute := filepath.Join(saveFolder, fileTitle)
 	out, err := os.Create(saveRoute)
 	if err != nil {
 		return err
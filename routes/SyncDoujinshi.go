@@ -2,6 +2,8 @@ package routes
 
 import (
 	"database/sql"
+	"errors"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -9,12 +11,14 @@ import (
 	"strconv"
 
 	"github.com/brayanMuniz/h_save/db"
+	"github.com/brayanMuniz/h_save/library"
 	"github.com/gin-gonic/gin"
 )
 
 type SyncedEntry struct {
 	ID           int64  `json:"id"`
 	Title        string `json:"title"`
+	LibraryRoot  string `json:"libraryRoot"`
 	FolderName   string `json:"folderName"`
 	ThumbnailURL string `json:"thumbnailUrl"`
 }
@@ -27,47 +31,91 @@ type PendingEntry struct {
 }
 
 type SyncResponse struct {
-	Synced           []SyncedEntry  `json:"synced"`
-	StillPending     []PendingEntry `json:"stillPending"`
-	AvailableFolders []string       `json:"availableFolders"`
+	Synced       []SyncedEntry  `json:"synced"`
+	StillPending []PendingEntry `json:"stillPending"`
+	// AvailableFolders are the unused folder names of every root,
+	// FoldersByRoot the same by root
+	AvailableFolders []string            `json:"availableFolders"`
+	FoldersByRoot    map[string][]string `json:"foldersByRoot"`
+	// UnavailableRoots couldn't be read, e.g. an unmounted disk
+	UnavailableRoots []string `json:"unavailableRoots"`
 }
 
-func SyncDoujinshiHandler(c *gin.Context, database *sql.DB) {
-	pending, err := db.GetPendingDoujinshi(database)
+type rootFolder struct {
+	root *library.Root
+	name string
+}
+
+func SyncDoujinshiHandler(c *gin.Context, database *sql.DB, roots library.Roots) {
+	response, err := SyncLibrary(database, roots)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pending doujinshi"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, response)
+}
 
-	entries, err := os.ReadDir("./doujinshi")
+// SyncLibrary matches the pending doujinshi with the folders of every
+// library root by title. When several roots have a matching folder the
+// first root wins.
+func SyncLibrary(database *sql.DB, roots library.Roots) (SyncResponse, error) {
+	pending, err := db.GetPendingDoujinshi(database)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read doujinshi folder"})
-		return
+		return SyncResponse{}, errors.New("Failed to fetch pending doujinshi")
+	}
+
+	response := SyncResponse{
+		Synced:           []SyncedEntry{},
+		StillPending:     []PendingEntry{},
+		AvailableFolders: []string{},
+		FoldersByRoot:    map[string][]string{},
+		UnavailableRoots: []string{},
 	}
 
 	// maps for efficient lookups
-	folderMap := make(map[string]string)
-	for _, entry := range entries {
-		if entry.IsDir() {
-			folderMap[sanitizeToFilename(entry.Name())] = entry.Name()
+	var folders []rootFolder
+	folderMap := make(map[string]rootFolder)
+	for _, root := range roots.Doujinshi {
+		dir, err := root.Resolve("")
+		if err != nil {
+			response.UnavailableRoots = append(response.UnavailableRoots, root.Name)
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			response.UnavailableRoots = append(response.UnavailableRoots, root.Name)
+			continue
 		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			folder := rootFolder{root, entry.Name()}
+			folders = append(folders, folder)
+			if _, ok := folderMap[sanitizeToFilename(entry.Name())]; !ok {
+				folderMap[sanitizeToFilename(entry.Name())] = folder
+			}
+		}
+	}
+	if len(response.UnavailableRoots) == len(roots.Doujinshi) {
+		return response, errors.New("Failed to read doujinshi folder")
 	}
 
-	var response SyncResponse
-	usedFolders := make(map[string]bool)
+	usedFolders := make(map[rootFolder]bool)
 
 	// Attempt to auto-sync pending entries
 	for _, d := range pending {
 		sanitizedTitle := sanitizeToFilename(d.Title)
-		if folderName, ok := folderMap[sanitizedTitle]; ok {
-			_ = db.UpdateFolderName(database, d.ID, folderName)
+		if folder, ok := folderMap[sanitizedTitle]; ok {
+			_ = db.UpdateFolderName(database, d.ID, folder.root.Name, folder.name)
 			response.Synced = append(response.Synced, SyncedEntry{
 				ID:           d.ID,
 				Title:        d.Title,
-				FolderName:   folderName,
+				LibraryRoot:  folder.root.Name,
+				FolderName:   folder.name,
 				ThumbnailURL: "/api/doujinshi/" + strconv.FormatInt(d.ID, 10) + "/thumbnail",
 			})
-			usedFolders[folderName] = true
+			usedFolders[folder] = true
 		} else {
 			// No match found
 			response.StillPending = append(response.StillPending, PendingEntry{
@@ -80,37 +128,73 @@ func SyncDoujinshiHandler(c *gin.Context, database *sql.DB) {
 	}
 
 	// Determine which folders are available for manual assignment
-	for _, entry := range entries {
-		if entry.IsDir() && !usedFolders[entry.Name()] {
-			response.AvailableFolders = append(response.AvailableFolders, entry.Name())
+	listed := make(map[string]bool)
+	for _, folder := range folders {
+		if usedFolders[folder] {
+			continue
+		}
+		response.FoldersByRoot[folder.root.Name] = append(response.FoldersByRoot[folder.root.Name], folder.name)
+		if !listed[folder.name] {
+			listed[folder.name] = true
+			response.AvailableFolders = append(response.AvailableFolders, folder.name)
 		}
 	}
 
-	// no empty slices
-	if response.Synced == nil {
-		response.Synced = []SyncedEntry{}
+	return response, nil
+}
+
+// findDoujinshiFolder resolves a folder in the root called rootName, or when
+// that is empty in the only root that has it. It responds itself on errors.
+func findDoujinshiFolder(c *gin.Context, roots library.Roots, rootName, folderName string) (*library.Root, string, bool) {
+	if rootName != "" {
+		root, err := roots.DoujinshiRoot(rootName)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, "", false
+		}
+		dir, err := root.ResolveFolder(folderName)
+		if err != nil {
+			libraryPathError(c, err, "Folder not found")
+			return nil, "", false
+		}
+		return root, dir, true
 	}
-	if response.StillPending == nil {
-		response.StillPending = []PendingEntry{}
+
+	var found *library.Root
+	var foundDir string
+	for _, root := range roots.Doujinshi {
+		dir, err := root.ResolveFolder(folderName)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			libraryPathError(c, err, "Folder not found")
+			return nil, "", false
+		}
+		if found != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Several library roots have this folder, choose one with root"})
+			return nil, "", false
+		}
+		found, foundDir = root, dir
 	}
-	if response.AvailableFolders == nil {
-		response.AvailableFolders = []string{}
+	if found == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+		return nil, "", false
 	}
-
-	c.JSON(http.StatusOK, response)
+	return found, foundDir, true
 }
 
-func GetThumbnailByFolderHandler(c *gin.Context, database *sql.DB) {
+// GetThumbnailByFolderHandler serves the first image of a folder. ?root= is
+// only needed when several library roots have the folder.
+func GetThumbnailByFolderHandler(c *gin.Context, database *sql.DB, roots library.Roots) {
 	folderName := c.Query("folderName")
 	if folderName == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "folderName query parameter is required"})
 		return
 	}
 
-	dir := filepath.Join("doujinshi", folderName)
-
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Folder not found"})
+	root, dir, ok := findDoujinshiFolder(c, roots, c.Query("root"), folderName)
+	if !ok {
 		return
 	}
 
@@ -134,13 +218,19 @@ func GetThumbnailByFolderHandler(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	thumbnailPath := filepath.Join(dir, imageFiles[0])
+	thumbnailPath, err := root.Resolve(filepath.Join(folderName, imageFiles[0]))
+	if err != nil {
+		libraryPathError(c, err, "Thumbnail not found")
+		return
+	}
 
 	c.Header("Cache-Control", "no-store")
 	c.File(thumbnailPath)
 }
 
-func ManualSyncHandler(c *gin.Context, database *sql.DB) {
+// ManualSyncHandler assigns a folder of a library root to a doujinshi. The
+// root can be left out when only one has the folder.
+func ManualSyncHandler(c *gin.Context, database *sql.DB, roots library.Roots) {
 	id, ok := parseID(c, "id")
 	if !ok {
 		return
@@ -148,6 +238,7 @@ func ManualSyncHandler(c *gin.Context, database *sql.DB) {
 
 	var req struct {
 		FolderName string `json:"folderName"`
+		Root       string `json:"root"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil || req.FolderName == "" {
@@ -155,11 +246,64 @@ func ManualSyncHandler(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	err := db.UpdateFolderName(database, id, req.FolderName)
+	root, _, ok := findDoujinshiFolder(c, roots, req.Root, req.FolderName)
+	if !ok {
+		return
+	}
+
+	err := db.UpdateFolderName(database, id, root.Name, req.FolderName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update folder name in database"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Folder name updated successfully."})
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Folder name updated successfully.", "libraryRoot": root.Name})
+}
+
+// MoveDoujinshiHandler moves the doujinshi's folder to another library root.
+// Everything saved about it stays, as it is kept by ID.
+func MoveDoujinshiHandler(c *gin.Context, database *sql.DB, roots library.Roots) {
+	var req struct {
+		Root string `json:"root"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Root == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request, root is required"})
+		return
+	}
+	target, err := roots.DoujinshiRoot(req.Root)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	doujinshiData, root, _, ok := doujinshiFolder(c, database, roots)
+	if !ok {
+		return
+	}
+	if root == target {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The doujin is already in " + target.Name})
+		return
+	}
+
+	err = library.MoveFolder(root, target, doujinshiData.FolderName)
+	if errors.Is(err, library.ErrFolderExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		libraryPathError(c, err, "Folder not found")
+		return
+	}
+
+	if err := db.SetLibraryRoot(database, doujinshiData.ID, target.Name); err != nil {
+		// put the folder back so the entry still points at it
+		if moveErr := library.MoveFolder(target, root, doujinshiData.FolderName); moveErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the doujin, its folder is now in " + target.Name})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update the doujin"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": doujinshiData.ID, "libraryRoot": target.Name, "folderName": doujinshiData.FolderName})
 }
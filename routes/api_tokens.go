@@ -0,0 +1,64 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/brayanMuniz/h_save/db"
+	"github.com/gin-gonic/gin"
+)
+
+func ListAPITokensHandler(c *gin.Context, database *sql.DB) {
+	tokens, err := db.ListAPITokens(database, currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
+}
+
+// CreateAPITokenHandler returns the new token once, only its hash is kept.
+func CreateAPITokenHandler(c *gin.Context, database *sql.DB) {
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	token, apiToken, err := db.CreateAPIToken(database, currentUserID(c), req.Name, req.Scopes)
+	if errors.Is(err, db.ErrInvalidTokenName) || errors.Is(err, db.ErrInvalidScope) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, db.ErrTokenNameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"token": token, "apiToken": apiToken})
+}
+
+func RevokeAPITokenHandler(c *gin.Context, database *sql.DB) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	err := db.DeleteAPIToken(database, currentUserID(c), id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
@@ -28,7 +28,7 @@ func AddFavoriteArtist(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	if err := db.AddFavoriteArtist(database, artistID); err != nil {
+	if err := db.AddFavoriteArtist(database, currentUserID(c), artistID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add favorite artist"})
 		return
 	}
@@ -54,7 +54,7 @@ func RemoveFavoriteArtist(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	if err := db.RemoveFavoriteArtist(database, artistID); err != nil {
+	if err := db.RemoveFavoriteArtist(database, currentUserID(c), artistID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove favorite artist"})
 		return
 	}
@@ -18,7 +18,7 @@ type ArtistPageData struct {
 }
 
 func GetAllArtist(c *gin.Context, database *sql.DB) {
-	artists, err := db.GetAllArtist(database)
+	artists, err := db.GetAllArtist(database, currentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch doujinshi for artist"})
 		return
@@ -54,7 +54,7 @@ func GetArtistPageDataHandler(c *gin.Context, database *sql.DB) {
 		artistID = id
 	}
 
-	artistDetails, err := db.GetArtistDetails(database, artistID)
+	artistDetails, err := db.GetArtistDetails(database, currentUserID(c), artistID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch artist details"})
 		return
@@ -64,7 +64,7 @@ func GetArtistPageDataHandler(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	doujinshi, err := db.GetDoujinshiByArtist(database, artistID)
+	doujinshi, err := db.GetDoujinshiByArtist(database, currentUserID(c), artistID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch doujinshi for artist"})
 		return
@@ -82,7 +82,7 @@ func GetArtistPageDataHandler(c *gin.Context, database *sql.DB) {
 	}
 
 	// Fetch images associated with this artist
-	images, err := db.GetImagesByArtist(database, artistID)
+	images, err := db.GetImagesByArtist(database, currentUserID(c), artistID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images for artist"})
 		return
@@ -100,7 +100,7 @@ func GetArtistPageDataHandler(c *gin.Context, database *sql.DB) {
 	}
 
 	// Fetch bookmarks for doujinshi associated with this artist
-	bookmarks, err := db.GetBookmarksByEntity(database, "artist", artistID)
+	bookmarks, err := db.GetBookmarksByEntity(database, currentUserID(c), "artist", artistID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookmarks for artist"})
 		return
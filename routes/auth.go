@@ -2,52 +2,307 @@ package routes
 
 import (
 	"database/sql"
+	"errors"
 	"github.com/brayanMuniz/h_save/db"
-	"github.com/brayanMuniz/h_save/n"
 	"github.com/gin-gonic/gin"
+	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
-func LoginHandler(c *gin.Context, database *sql.DB) {
-	var req struct{ Password string }
+const (
+	sessionCookie = "session"
+	// sessionKey holds the request's db.Session in the gin context, or
+	// apiTokenKey its db.APIToken when it was made with a token
+	sessionKey  = "session"
+	apiTokenKey = "apiToken"
+	userIDKey   = "userID"
+)
+
+// LoginHandler starts a session. Every attempt is recorded, and IPs guessing
+// passwords get locked out for longer and longer, as set by limits.
+func LoginHandler(c *gin.Context, database *sql.DB, limits db.LoginLimits) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": "Invalid request"})
 		return
 	}
-	ok, err := db.CheckPassword(database, req.Password)
-	if err != nil || !ok {
+
+	ip, userAgent := c.ClientIP(), c.Request.UserAgent()
+	attemptID, wait, err := db.BeginLoginAttempt(database, limits, req.Username, ip, userAgent)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if wait > 0 {
+		seconds := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed logins, try again later", "retryAfter": seconds})
+		return
+	}
+
+	user, err := db.Authenticate(database, req.Username, req.Password)
+	if errors.Is(err, db.ErrInvalidCredentials) {
+		if err := db.FinishLoginAttempt(database, attemptID, req.Username, 0, db.LoginFailure); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record login attempt"})
+			return
+		}
 		c.JSON(401, gin.H{"error": "Unauthorized"})
 		return
 	}
-	// Set a session cookie (for example)
-	c.SetCookie("session", "your-session-token", 3600, "/", "", false, true)
-	c.JSON(200, gin.H{"message": "Logged in"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check password"})
+		return
+	}
+	if err := db.FinishLoginAttempt(database, attemptID, user.Username, user.ID, db.LoginSuccess); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record login attempt"})
+		return
+	}
+
+	token, _, err := db.CreateSession(database, user.ID, userAgent, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, token, int(db.SessionLifetime.Seconds()), "/", "", false, true)
+
+	c.JSON(200, gin.H{"message": "Logged in", "user": user, "mustChangePassword": user.MustChangePassword})
 }
 
-func AuthCheck(c *gin.Context, rootURL string) {
+// RequireAuth rejects requests without a valid session cookie or API token.
+// Tokens are sent as "Authorization: Bearer <token>" and are limited to their
+// scopes.
+func RequireAuth(database *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if bearer, ok := bearerToken(c); ok {
+			token, err := db.ValidateAPIToken(database, bearer)
+			if errors.Is(err, db.ErrInvalidAPIToken) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+				return
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check token"})
+				return
+			}
+			if !token.Allows(scopeGroup(c.Request.URL.Path), isWriteRequest(c.Request)) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "The token's scopes don't allow this request"})
+				return
+			}
+			c.Set(apiTokenKey, token)
+			c.Set(userIDKey, token.UserID)
+			c.Next()
+			return
+		}
+
+		token, _ := c.Cookie(sessionCookie)
+		session, err := db.ValidateSession(database, token)
+		if errors.Is(err, db.ErrInvalidSession) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check session"})
+			return
+		}
+		c.Set(sessionKey, session)
+		c.Set(userIDKey, session.UserID)
+		c.Next()
+	}
+}
+
+// RequireBrowserSession keeps API tokens away from account management, so a
+// leaked token can't change the password or create more tokens.
+func RequireBrowserSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := currentSession(c); !ok {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This route needs a logged in session, not an API token"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// scopeGroup maps a path to one of db.APITokenScopeGroups.
+func scopeGroup(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/nhentai/"):
+		return "nhentai"
+	case strings.HasPrefix(path, "/api/images/") || path == "/api/images":
+		return "images"
+	case strings.HasPrefix(path, "/api/user/"):
+		return "user"
+	default:
+		return "library"
+	}
+}
+
+// isWriteRequest treats searches sent as POST as reads.
+func isWriteRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	case http.MethodPost:
+		return !strings.HasSuffix(r.URL.Path, "/search") && !strings.HasSuffix(r.URL.Path, "/facets")
+	}
+	return true
+}
+
+// RequirePasswordChanged blocks every route behind it until the user has
+// replaced the default password or the one an admin gave them.
+func RequirePasswordChanged(database *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mustChange, err := db.MustChangePassword(database, currentUserID(c))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check password"})
+			return
+		}
+		if mustChange {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":              "The password must be changed first",
+				"mustChangePassword": true,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+func currentSession(c *gin.Context) (db.Session, bool) {
+	value, ok := c.Get(sessionKey)
+	if !ok {
+		return db.Session{}, false
+	}
+	session, ok := value.(db.Session)
+	return session, ok
+}
+
+// currentUserID returns the logged in user, it is only set behind
+// RequireAuth.
+func currentUserID(c *gin.Context) int64 {
+	return c.GetInt64(userIDKey)
+}
+
+func LogoutHandler(c *gin.Context, database *sql.DB) {
+	if session, ok := currentSession(c); ok {
+		if err := db.DeleteSession(database, session.UserID, session.ID); err != nil && err != sql.ErrNoRows {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+			return
+		}
+	}
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookie, "", -1, "/", "", false, true)
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out"})
+}
+
+// ChangePasswordHandler replaces the password after checking the current
+// one, and logs out every other session.
+func ChangePasswordHandler(c *gin.Context, database *sql.DB) {
 	var req struct {
-		SessionId string `json:"sessionId"`
-		CsrfToken string `json:"csrfToken"`
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	httpConfig := n.HTTPConfig{
-		SessionId: req.SessionId,
-		CsrfToken: req.CsrfToken,
+	session, _ := currentSession(c)
+	ok, err := db.CheckPassword(database, session.UserID, req.CurrentPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check password"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	err = db.ChangePassword(database, session.UserID, req.NewPassword)
+	if errors.Is(err, db.ErrWeakPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+
+	if err := db.DeleteOtherSessions(database, session.UserID, session.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password changed, but failed to log out other sessions"})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed"})
+}
 
-	html_page, err := n.GetPageHTML(rootURL, httpConfig)
+func ListSessionsHandler(c *gin.Context, database *sql.DB) {
+	sessions, err := db.ListSessions(database, currentUserID(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get favorites page"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list sessions"})
+		return
+	}
+	if current, ok := currentSession(c); ok {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == current.ID
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSessionHandler ends another session, e.g. one left open on a lost
+// device. Revoking the current session works like logging out.
+func RevokeSessionHandler(c *gin.Context, database *sql.DB) {
+	id, ok := parseID(c, "id")
+	if !ok {
 		return
 	}
 
-	userName, err := n.ReturnUserNameFromHTML(html_page)
+	err := db.DeleteSession(database, currentUserID(c), id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get username"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	if current, ok := currentSession(c); ok && current.ID == id {
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(sessionCookie, "", -1, "/", "", false, true)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// AuthCheck returns the nhentai username the cookies log in to, using the
+// saved ones when the request has none.
+func AuthCheck(c *gin.Context, database *sql.DB, key []byte) {
+	var req credentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	httpConfig, stored, ok := req.httpConfig(c, database, key)
+	if !ok {
+		return
+	}
+	userName, ok := checkSession(c, database, httpConfig, stored)
+	if !ok {
 		return
 	}
 
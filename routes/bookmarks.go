@@ -22,8 +22,8 @@ func AddBookmark(ctx *gin.Context, database *sql.DB) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
-	if err := db.AddBookmark(database, id, req.Filename, req.Name); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add bookmark"})
+	if err := db.AddBookmark(database, currentUserID(ctx), id, req.Filename, req.Name); err != nil {
+		writeFailed(ctx, err, "Failed to add bookmark")
 		return
 	}
 	ctx.JSON(http.StatusOK, gin.H{"success": true})
@@ -34,7 +34,7 @@ func ListBookmarks(ctx *gin.Context, database *sql.DB) {
 	if !ok {
 		return
 	}
-	bookmarks, err := db.GetBookmarks(database, id)
+	bookmarks, err := db.GetBookmarks(database, currentUserID(ctx), id)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bookmarks"})
 		return
@@ -49,7 +49,7 @@ func GetBookmarksByTag(ctx *gin.Context, database *sql.DB) {
 		return
 	}
 
-	bookmarks, err := db.GetBookmarksByEntity(database, "tag", tagID)
+	bookmarks, err := db.GetBookmarksByEntity(database, currentUserID(ctx), "tag", tagID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bookmarks"})
 		return
@@ -64,7 +64,7 @@ func GetBookmarksByCharacter(ctx *gin.Context, database *sql.DB) {
 		return
 	}
 
-	bookmarks, err := db.GetBookmarksByEntity(database, "character", characterID)
+	bookmarks, err := db.GetBookmarksByEntity(database, currentUserID(ctx), "character", characterID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bookmarks"})
 		return
@@ -79,7 +79,7 @@ func GetBookmarksByArtist(ctx *gin.Context, database *sql.DB) {
 		return
 	}
 
-	bookmarks, err := db.GetBookmarksByEntity(database, "artist", artistID)
+	bookmarks, err := db.GetBookmarksByEntity(database, currentUserID(ctx), "artist", artistID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bookmarks"})
 		return
@@ -94,7 +94,7 @@ func GetBookmarksByParody(ctx *gin.Context, database *sql.DB) {
 		return
 	}
 
-	bookmarks, err := db.GetBookmarksByEntity(database, "parody", parodyID)
+	bookmarks, err := db.GetBookmarksByEntity(database, currentUserID(ctx), "parody", parodyID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bookmarks"})
 		return
@@ -109,7 +109,7 @@ func GetBookmarksByGroup(ctx *gin.Context, database *sql.DB) {
 		return
 	}
 
-	bookmarks, err := db.GetBookmarksByEntity(database, "group", groupID)
+	bookmarks, err := db.GetBookmarksByEntity(database, currentUserID(ctx), "group", groupID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get bookmarks"})
 		return
@@ -137,7 +137,7 @@ func UpdateBookmark(ctx *gin.Context, database *sql.DB) {
 		return
 	}
 
-	if err := db.UpdateBookmark(database, bookmarkID, req.Name); err != nil {
+	if err := db.UpdateBookmark(database, currentUserID(ctx), bookmarkID, req.Name); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update bookmark"})
 		return
 	}
@@ -158,7 +158,7 @@ func RemoveBookmark(ctx *gin.Context, database *sql.DB) {
 		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Missing filename"})
 		return
 	}
-	if err := db.RemoveBookmark(database, id, filename); err != nil {
+	if err := db.RemoveBookmark(database, currentUserID(ctx), id, filename); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove bookmark"})
 		return
 	}
@@ -0,0 +1,208 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/brayanMuniz/h_save/db"
+	"github.com/brayanMuniz/h_save/types"
+	"github.com/gin-gonic/gin"
+)
+
+// bindBrowseFilters reads a BrowseFilters from the query string. A complete
+// payload can be passed as JSON in the filters param, the individual params
+// below are applied on top of it:
+//
+//	tag=a&tag=b&excludeTag=c      (same for artist, group, character, parody)
+//	language=english&genre=manga&search=text
+//	query=artist:"foo bar" -tag:netorare rating>=4
+//	minRating, maxRating, minOCount, maxOCount, minPages, maxPages,
+//	minBookmarks, maxBookmarks, currentlyReading=true
+func bindBrowseFilters(c *gin.Context) (types.BrowseFilters, error) {
+	var filters types.BrowseFilters
+
+	if raw := c.Query("filters"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &filters); err != nil {
+			return filters, fmt.Errorf("invalid filters param: %w", err)
+		}
+	}
+
+	bindFilterGroups(c, &filters.Artists, &filters.Groups, &filters.Tags, &filters.Characters, &filters.Parodies)
+
+	filters.Languages = append(filters.Languages, c.QueryArray("language")...)
+	filters.Genres = append(filters.Genres, c.QueryArray("genre")...)
+	if search := c.Query("search"); search != "" {
+		filters.Search = search
+	}
+	if query := c.Query("query"); query != "" {
+		filters.Query = query
+	}
+
+	err := bindInts(c, []intParam{
+		{"minRating", &filters.Rating.Min},
+		{"maxRating", &filters.Rating.Max},
+		{"minOCount", &filters.OCount.Min},
+		{"maxOCount", &filters.OCount.Max},
+		{"minPages", &filters.PageCount.Min},
+		{"maxPages", &filters.PageCount.Max},
+		{"minBookmarks", &filters.BookmarkCount.Min},
+		{"maxBookmarks", &filters.BookmarkCount.Max},
+	})
+	if err != nil {
+		return filters, err
+	}
+
+	if raw := c.Query("currentlyReading"); raw != "" {
+		reading, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filters, fmt.Errorf("invalid currentlyReading: %s", raw)
+		}
+		filters.CurrentlyReading = reading
+	}
+
+	return filters, nil
+}
+
+// bindImageFilters reads an ImageFilters from the query string, with the same
+// filters JSON param and tag/artist/... params as bindBrowseFilters, plus:
+//
+//	minRating, maxRating, minOCount, maxOCount, minViewCount, maxViewCount,
+//	minWidth, maxWidth, minHeight, maxHeight, minFileSize, maxFileSize,
+//	minAspectRatio, maxAspectRatio, format=png&format=gif, favorite=true
+func bindImageFilters(c *gin.Context) (types.ImageFilters, error) {
+	var filters types.ImageFilters
+
+	if raw := c.Query("filters"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &filters); err != nil {
+			return filters, fmt.Errorf("invalid filters param: %w", err)
+		}
+	}
+
+	bindFilterGroups(c, &filters.Artists, &filters.Groups, &filters.Tags, &filters.Characters, &filters.Parodies)
+	filters.Formats = append(filters.Formats, c.QueryArray("format")...)
+
+	err := bindInts(c, []intParam{
+		{"minRating", &filters.Rating.Min},
+		{"maxRating", &filters.Rating.Max},
+		{"minOCount", &filters.OCount.Min},
+		{"maxOCount", &filters.OCount.Max},
+		{"minViewCount", &filters.ViewCount.Min},
+		{"maxViewCount", &filters.ViewCount.Max},
+		{"minWidth", &filters.Width.Min},
+		{"maxWidth", &filters.Width.Max},
+		{"minHeight", &filters.Height.Min},
+		{"maxHeight", &filters.Height.Max},
+		{"minFileSize", &filters.FileSize.Min},
+		{"maxFileSize", &filters.FileSize.Max},
+	})
+	if err != nil {
+		return filters, err
+	}
+
+	aspect := []struct {
+		param string
+		value *float64
+	}{
+		{"minAspectRatio", &filters.AspectRatio.Min},
+		{"maxAspectRatio", &filters.AspectRatio.Max},
+	}
+	for _, a := range aspect {
+		raw := c.Query(a.param)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return filters, fmt.Errorf("invalid %s: %s", a.param, raw)
+		}
+		*a.value = v
+	}
+
+	if raw := c.Query("favorite"); raw != "" {
+		favorite, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filters, fmt.Errorf("invalid favorite: %s", raw)
+		}
+		filters.Favorite = &favorite
+	}
+
+	return filters, nil
+}
+
+// bindFilterGroups reads the include/exclude params of the artist, group,
+// tag, character and parody groups, in that order.
+func bindFilterGroups(c *gin.Context, artists, groups, tags, characters, parodies *types.FilterGroup) {
+	params := []struct {
+		param string
+		group *types.FilterGroup
+	}{
+		{"artist", artists},
+		{"group", groups},
+		{"tag", tags},
+		{"character", characters},
+		{"parody", parodies},
+	}
+	for _, g := range params {
+		g.group.Included = append(g.group.Included, c.QueryArray(g.param)...)
+		g.group.Excluded = append(g.group.Excluded,
+			c.QueryArray("exclude"+strings.ToUpper(g.param[:1])+g.param[1:])...)
+	}
+}
+
+type intParam struct {
+	param string
+	value *int
+}
+
+func bindInts(c *gin.Context, params []intParam) error {
+	for _, r := range params {
+		raw := c.Query(r.param)
+		if raw == "" {
+			continue
+		}
+		v, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", r.param, raw)
+		}
+		*r.value = v
+	}
+	return nil
+}
+
+// bindListOptions reads sort, order, seed, limit, offset, cursor and all from
+// the query string.
+func bindListOptions(c *gin.Context) (db.ListOptions, error) {
+	opts := db.ListOptions{
+		Sort:   c.Query("sort"),
+		Order:  c.Query("order"),
+		Cursor: c.Query("cursor"),
+	}
+
+	err := bindInts(c, []intParam{
+		{"limit", &opts.Limit},
+		{"offset", &opts.Offset},
+	})
+	if err != nil {
+		return opts, err
+	}
+
+	if raw := c.Query("seed"); raw != "" {
+		seed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid seed: %s", raw)
+		}
+		opts.Seed = seed
+	}
+
+	if raw := c.Query("all"); raw != "" {
+		all, err := strconv.ParseBool(raw)
+		if err != nil {
+			return opts, fmt.Errorf("invalid all: %s", raw)
+		}
+		opts.All = all
+	}
+
+	return opts, nil
+}
@@ -17,7 +17,7 @@ type CharacterPageData struct {
 }
 
 func GetAllCharactersHandler(c *gin.Context, database *sql.DB) {
-	characters, err := db.GetAllCharacters(database)
+	characters, err := db.GetAllCharacters(database, currentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch characters"})
 		return
@@ -56,7 +56,7 @@ func GetCharacterPageDataHandler(c *gin.Context, database *sql.DB) {
 		characterID = id
 	}
 
-	characterDetails, err := db.GetCharacterDetails(database, characterID)
+	characterDetails, err := db.GetCharacterDetails(database, currentUserID(c), characterID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch character details"})
 		return
@@ -66,7 +66,7 @@ func GetCharacterPageDataHandler(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	doujinshi, err := db.GetDoujinshiByCharacter(database, characterID)
+	doujinshi, err := db.GetDoujinshiByCharacter(database, currentUserID(c), characterID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch doujinshi for character"})
 		return
@@ -84,7 +84,7 @@ func GetCharacterPageDataHandler(c *gin.Context, database *sql.DB) {
 	}
 
 	// Fetch images associated with this character
-	images, err := db.GetImagesByCharacter(database, characterID)
+	images, err := db.GetImagesByCharacter(database, currentUserID(c), characterID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images for character"})
 		return
@@ -102,7 +102,7 @@ func GetCharacterPageDataHandler(c *gin.Context, database *sql.DB) {
 	}
 
 	// Fetch bookmarks for doujinshi associated with this character
-	bookmarks, err := db.GetBookmarksByEntity(database, "character", characterID)
+	bookmarks, err := db.GetBookmarksByEntity(database, currentUserID(c), "character", characterID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookmarks for character"})
 		return
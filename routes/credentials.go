@@ -0,0 +1,133 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/brayanMuniz/h_save/db"
+	"github.com/brayanMuniz/h_save/n"
+	"github.com/gin-gonic/gin"
+)
+
+const NhentaiProvider = "nhentai"
+
+// credentialsRequest is embedded in the nhentai requests. The cookies can be
+// left out to use the saved ones.
+type credentialsRequest struct {
+	SessionId string `json:"sessionId"`
+	CsrfToken string `json:"csrfToken"`
+}
+
+// httpConfig returns the cookies sent with the request, or else the user's
+// saved ones. stored tells which, so a failed check can be recorded.
+func (req credentialsRequest) httpConfig(c *gin.Context, database *sql.DB, key []byte) (config n.HTTPConfig, stored bool, ok bool) {
+	if req.SessionId != "" {
+		return n.HTTPConfig{SessionId: req.SessionId, CsrfToken: req.CsrfToken}, false, true
+	}
+
+	creds, err := db.GetProviderCredentials(database, key, currentUserID(c), NhentaiProvider)
+	if errors.Is(err, db.ErrNoCredentials) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No nhentai credentials saved, send sessionId and csrfToken or save them first"})
+		return config, false, false
+	}
+	if errors.Is(err, db.ErrCredentialsKey) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return config, false, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read saved credentials"})
+		return config, false, false
+	}
+	return n.HTTPConfig{SessionId: creds.SessionId, CsrfToken: creds.CsrfToken}, true, true
+}
+
+// CheckNhentaiSession logs in to nhentai with config and returns the account
+// name.
+func CheckNhentaiSession(config n.HTTPConfig) (string, error) {
+	return n.CheckSession(rootURL, config)
+}
+
+// checkSession logs in to nhentai with config, recording the result when the
+// cookies are the saved ones. It responds itself when they don't work.
+func checkSession(c *gin.Context, database *sql.DB, config n.HTTPConfig, stored bool) (string, bool) {
+	userName, err := CheckNhentaiSession(config)
+	if errors.Is(err, n.ErrSessionExpired) {
+		if stored {
+			db.SetCredentialsStatus(database, currentUserID(c), NhentaiProvider, db.CredentialsExpired, "")
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error(), "expired": true})
+		return "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to reach nhentai"})
+		return "", false
+	}
+	if stored {
+		if err := db.SetCredentialsStatus(database, currentUserID(c), NhentaiProvider, db.CredentialsValid, userName); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update credentials"})
+			return "", false
+		}
+	}
+	return userName, true
+}
+
+func GetCredentialsHandler(c *gin.Context, database *sql.DB) {
+	status, err := db.GetCredentialsStatus(database, currentUserID(c), NhentaiProvider)
+	if errors.Is(err, db.ErrNoCredentials) {
+		c.JSON(http.StatusOK, gin.H{"saved": false})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get credentials"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"saved": true, "credentials": status})
+}
+
+// SaveCredentialsHandler encrypts and stores the nhentai cookies, then checks
+// them. They are kept even when the check fails.
+func SaveCredentialsHandler(c *gin.Context, database *sql.DB, key []byte) {
+	var req credentialsRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.SessionId == "" || req.CsrfToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request, sessionId and csrfToken are required"})
+		return
+	}
+
+	creds := db.ProviderCredentials{SessionId: req.SessionId, CsrfToken: req.CsrfToken}
+	if err := db.SaveProviderCredentials(database, key, currentUserID(c), NhentaiProvider, creds); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save credentials"})
+		return
+	}
+
+	config := n.HTTPConfig{SessionId: req.SessionId, CsrfToken: req.CsrfToken}
+	if _, ok := checkSession(c, database, config, true); !ok {
+		return
+	}
+	GetCredentialsHandler(c, database)
+}
+
+// ValidateCredentialsHandler checks that the saved cookies still log in.
+func ValidateCredentialsHandler(c *gin.Context, database *sql.DB, key []byte) {
+	config, _, ok := credentialsRequest{}.httpConfig(c, database, key)
+	if !ok {
+		return
+	}
+	if _, ok := checkSession(c, database, config, true); !ok {
+		return
+	}
+	GetCredentialsHandler(c, database)
+}
+
+func DeleteCredentialsHandler(c *gin.Context, database *sql.DB) {
+	err := db.DeleteProviderCredentials(database, currentUserID(c), NhentaiProvider)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No credentials saved"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete credentials"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Credentials deleted"})
+}
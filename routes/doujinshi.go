@@ -2,6 +2,8 @@ package routes
 
 import (
 	"database/sql"
+	"errors"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -11,6 +13,8 @@ import (
 	"strings"
 
 	"github.com/brayanMuniz/h_save/db"
+	"github.com/brayanMuniz/h_save/library"
+	"github.com/brayanMuniz/h_save/types"
 	"github.com/gin-gonic/gin"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -21,28 +25,82 @@ type DoujinshiWithThumb struct {
 }
 
 func GetAllDoujinshi(c *gin.Context, database *sql.DB) {
-	doujinshi, err := db.GetAllDoujinshi(database)
+	filters, err := bindBrowseFilters(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	var result []DoujinshiWithThumb
-	for _, d := range doujinshi {
-		if d.FolderName != "" {
-			result = append(result, DoujinshiWithThumb{
-				Doujinshi:    d,
-				ThumbnailURL: "/api/doujinshi/" + strconv.FormatInt(d.ID, 10) + "/thumbnail",
-			})
-		}
+	opts, err := bindListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"doujinshi": result})
+	respondWithDoujinshiPage(c, database, filters, opts)
+}
+
+type SearchDoujinshiRequest struct {
+	types.BrowseFilters
+	db.ListOptions
+}
+
+// SearchDoujinshiHandler is the POST variant of GetAllDoujinshi for filter
+// payloads that are too large for a query string.
+func SearchDoujinshiHandler(c *gin.Context, database *sql.DB) {
+	var req SearchDoujinshiRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	respondWithDoujinshiPage(c, database, req.BrowseFilters, req.ListOptions)
+}
+
+func respondWithDoujinshiPage(c *gin.Context, database *sql.DB, filters types.BrowseFilters, opts db.ListOptions) {
+	page, err := db.ListDoujinshi(database, currentUserID(c), filters, opts)
+	if errors.Is(err, db.ErrInvalidListOptions) || errors.Is(err, types.ErrInvalidFilter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := gin.H{
+		"doujinshi":      withThumbnails(page.Doujinshi),
+		"total":          page.Total,
+		"fullTextSearch": db.FullTextSearchAvailable(),
+	}
+	if page.NextCursor != "" {
+		response["nextCursor"] = page.NextCursor
+	}
+	if page.Seed != 0 {
+		response["seed"] = page.Seed
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func withThumbnails(doujinshi []db.Doujinshi) []DoujinshiWithThumb {
+	result := []DoujinshiWithThumb{}
+	for _, d := range doujinshi {
+		result = append(result, DoujinshiWithThumb{
+			Doujinshi:    d,
+			ThumbnailURL: "/api/doujinshi/" + strconv.FormatInt(d.ID, 10) + "/thumbnail",
+		})
+	}
+	return result
 }
 
 func GetDoujinshi(c *gin.Context, database *sql.DB) {
 	id := c.Param("id")
-	doujinshiData, err := db.GetDoujinshi(database, id)
+	doujinshiData, err := db.GetDoujinshi(database, currentUserID(c), id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Doujinshi not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": err})
@@ -56,22 +114,44 @@ func GetDoujinshi(c *gin.Context, database *sql.DB) {
 	c.JSON(http.StatusOK, gin.H{"doujinshiData": result})
 }
 
-func GetDoujinshiThumbnail(c *gin.Context, database *sql.DB) {
-	id := c.Param("id")
-	doujinshiData, err := db.GetDoujinshi(database, id)
+// doujinshiFolder loads the doujinshi and resolves its folder in the library
+// root it is in. It responds itself when either can't be found.
+func doujinshiFolder(c *gin.Context, database *sql.DB, roots library.Roots) (db.Doujinshi, *library.Root, string, bool) {
+	doujinshiData, err := db.GetDoujinshi(database, currentUserID(c), c.Param("id"))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Doujinshi not found"})
+		return doujinshiData, nil, "", false
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": err})
-		return
+		return doujinshiData, nil, "", false
 	}
 
 	if doujinshiData.FolderName == "" {
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": "The doujin is not synced or downloaded"})
-		return
+		return doujinshiData, nil, "", false
+	}
+
+	root, err := roots.DoujinshiRoot(doujinshiData.LibraryRoot)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "The doujin is in a library root that isn't configured: " + doujinshiData.LibraryRoot})
+		return doujinshiData, nil, "", false
+	}
+	dir, err := root.ResolveFolder(doujinshiData.FolderName)
+	if err != nil {
+		libraryPathError(c, err, "Folder not found")
+		return doujinshiData, nil, "", false
 	}
+	return doujinshiData, root, dir, true
+}
 
-	dir := filepath.Join("doujinshi", doujinshiData.FolderName)
+func GetDoujinshiThumbnail(c *gin.Context, database *sql.DB, roots library.Roots) {
+	doujinshiData, root, dir, ok := doujinshiFolder(c, database, roots)
+	if !ok {
+		return
+	}
 	files, err := os.ReadDir(dir)
 	if err != nil || len(files) == 0 {
 		c.Status(http.StatusNotFound)
@@ -91,7 +171,11 @@ func GetDoujinshiThumbnail(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	thumbnailPath := filepath.Join(dir, imageFiles[0])
+	thumbnailPath, err := root.Resolve(filepath.Join(doujinshiData.FolderName, imageFiles[0]))
+	if err != nil {
+		libraryPathError(c, err, "Thumbnail not found")
+		return
+	}
 	c.Header("Cache-Control", "no-store")
 	c.File(thumbnailPath)
 }
@@ -101,6 +185,29 @@ func isImageFile(name string) bool {
 	return ext == ".jpg" || ext == ".jpeg" || ext == ".png" || ext == ".webp"
 }
 
+// libraryPathError responds to a path that couldn't be resolved in a library
+// root: 400 for paths trying to leave it, 404 for missing files.
+func libraryPathError(c *gin.Context, err error, notFound string) {
+	switch {
+	case errors.Is(err, library.ErrInvalidPath), errors.Is(err, library.ErrOutsideRoot):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, fs.ErrNotExist):
+		c.JSON(http.StatusNotFound, gin.H{"error": notFound})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read the library"})
+	}
+}
+
+// writeFailed responds 404 when the write pointed at something that doesn't
+// exist, see db.IsMissingReference, and 500 with message otherwise.
+func writeFailed(c *gin.Context, err error, message string) {
+	if db.IsMissingReference(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
 func GetArtistDoujins(c *gin.Context, database *sql.DB) {
 	artistIDStr := c.Param("id")
 	artistID, err := strconv.ParseInt(artistIDStr, 10, 64)
@@ -109,7 +216,7 @@ func GetArtistDoujins(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	doujinshi, err := db.GetDoujinshiByArtist(database, artistID)
+	doujinshi, err := db.GetDoujinshiByArtist(database, currentUserID(c), artistID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch doujinshi for artist"})
 		return
@@ -126,23 +233,13 @@ func GetArtistDoujins(c *gin.Context, database *sql.DB) {
 	c.JSON(http.StatusOK, gin.H{"doujinshi": result})
 }
 
-func GetDoujinshiPages(c *gin.Context, database *sql.DB) {
+func GetDoujinshiPages(c *gin.Context, database *sql.DB, roots library.Roots) {
 	id := c.Param("id")
 
-	doujinshiData, err := db.GetDoujinshi(database, id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError,
-			gin.H{"error": err})
-		return
-	}
-
-	if doujinshiData.FolderName == "" {
-		c.JSON(http.StatusInternalServerError,
-			gin.H{"error": "The doujin is not synced or downloaded"})
+	_, _, dir, ok := doujinshiFolder(c, database, roots)
+	if !ok {
 		return
 	}
-
-	dir := filepath.Join("doujinshi", doujinshiData.FolderName)
 	files, err := os.ReadDir(dir)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err})
@@ -160,63 +257,85 @@ func GetDoujinshiPages(c *gin.Context, database *sql.DB) {
 	c.JSON(http.StatusOK, gin.H{"pages": imageFiles})
 }
 
-func GetDoujinshiPage(c *gin.Context, database *sql.DB) {
-	id := c.Param("id")
+func GetDoujinshiPage(c *gin.Context, database *sql.DB, roots library.Roots) {
 	pageNumber := c.Param("pageNumber")
 
-	if !isImageFile(pageNumber) {
+	if !isImageFile(pageNumber) || strings.ContainsAny(pageNumber, `/\`) {
 		c.JSON(http.StatusBadRequest,
 			gin.H{"error": "provide a valid file type"})
 		return
 	}
 
-	doujinshiData, err := db.GetDoujinshi(database, id)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError,
-			gin.H{"error": err})
+	doujinshiData, root, _, ok := doujinshiFolder(c, database, roots)
+	if !ok {
 		return
 	}
-
-	if doujinshiData.FolderName == "" {
-		c.JSON(http.StatusInternalServerError,
-			gin.H{"error": "The doujin is not synced or downloaded"})
+	path, err := root.Resolve(filepath.Join(doujinshiData.FolderName, pageNumber))
+	if err != nil {
+		libraryPathError(c, err, "Page not found")
 		return
 	}
-
-	path := filepath.Join("doujinshi", doujinshiData.FolderName, pageNumber)
 	c.Header("Cache-Control", "no-store")
 	c.File(path)
 }
 
+type SimilarDoujinshiWithThumb struct {
+	DoujinshiWithThumb
+	Score  float64             `json:"score"`
+	Shared db.SharedAttributes `json:"shared"`
+}
+
+// GetSimilarDoujinshiByMetadata ranks doujinshi by shared metadata, rare
+// attributes weigh more. limit defaults to 20.
 func GetSimilarDoujinshiByMetadata(c *gin.Context, database *sql.DB) {
-	id := c.Param("id")
-	doujinshiData, err := db.GetDoujinshi(database, id)
-	if err != nil {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	limit, ok := parseSimilarLimit(c)
+	if !ok {
+		return
+	}
+
+	if _, err := db.GetDoujinshi(database, currentUserID(c), c.Param("id")); err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Doujinshi not found"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get doujinshi data"})
 		return
 	}
 
-	similarList, err := db.GetSimilarDoujinshiByMetaData(
-		database,
-		id,
-		doujinshiData.Characters,
-		doujinshiData.Tags,
-		doujinshiData.Parodies,
-	)
+	similarList, err := db.GetSimilarDoujinshi(database, currentUserID(c), id, limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var result []DoujinshiWithThumb
+	result := []SimilarDoujinshiWithThumb{}
 	for _, d := range similarList {
-		result = append(result, DoujinshiWithThumb{
-			Doujinshi:    d,
-			ThumbnailURL: "/api/doujinshi/" + strconv.FormatInt(d.ID, 10) + "/thumbnail",
+		result = append(result, SimilarDoujinshiWithThumb{
+			DoujinshiWithThumb: DoujinshiWithThumb{
+				Doujinshi:    d.Doujinshi,
+				ThumbnailURL: "/api/doujinshi/" + strconv.FormatInt(d.ID, 10) + "/thumbnail",
+			},
+			Score:  d.Score,
+			Shared: d.Shared,
 		})
 	}
 	c.JSON(http.StatusOK, gin.H{"similarDoujins": result})
+}
 
+func parseSimilarLimit(c *gin.Context) (int, bool) {
+	limit := 20
+	if raw := c.Query("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit: " + raw})
+			return 0, false
+		}
+		limit = v
+	}
+	return limit, true
 }
 
 var nonWord = regexp.MustCompile(`[^\p{L}\p{N}]+`)
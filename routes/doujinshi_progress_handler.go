@@ -15,7 +15,7 @@ type ProgressRequest struct {
 func GetDoujinshiProgress(c *gin.Context, database *sql.DB) {
 	id := c.Param("id")
 
-	progress, err := db.GetDoujinshiProgress(database, id)
+	progress, err := db.GetDoujinshiProgress(database, currentUserID(c), id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": "Failed to get doujinshi progress"})
@@ -54,17 +54,17 @@ func SetDoujinshiProgress(c *gin.Context, database *sql.DB) {
 
 	err := db.UpdateDoujinshiProgress(
 		database,
+		currentUserID(c),
 		id,
 		request.Rating,
 		request.LastPage,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError,
-			gin.H{"error": "Failed to update doujinshi progress"})
+		writeFailed(c, err, "Failed to update doujinshi progress")
 		return
 	}
 
-	progress, err := db.GetDoujinshiProgress(database, id)
+	progress, err := db.GetDoujinshiProgress(database, currentUserID(c), id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError,
 			gin.H{"error": "Failed to get updated progress"})
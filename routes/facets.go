@@ -0,0 +1,58 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/brayanMuniz/h_save/db"
+	"github.com/brayanMuniz/h_save/types"
+	"github.com/gin-gonic/gin"
+)
+
+// GetDoujinshiFacetsHandler reads the filters like GET /api/doujinshi, the
+// facetLimit param caps the values returned per facet.
+func GetDoujinshiFacetsHandler(c *gin.Context, database *sql.DB) {
+	filters, err := bindBrowseFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondWithFacets(c, database, filters)
+}
+
+// PostDoujinshiFacetsHandler takes a BrowseFilters payload as the body.
+func PostDoujinshiFacetsHandler(c *gin.Context, database *sql.DB) {
+	var filters types.BrowseFilters
+	if err := c.ShouldBindJSON(&filters); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	respondWithFacets(c, database, filters)
+}
+
+func respondWithFacets(c *gin.Context, database *sql.DB, filters types.BrowseFilters) {
+	limit := 0
+	if raw := c.Query("facetLimit"); raw != "" {
+		var err error
+		if limit, err = strconv.Atoi(raw); err != nil || limit < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid facetLimit: " + raw})
+			return
+		}
+	}
+
+	facets, err := db.GetDoujinshiFacets(database, currentUserID(c), filters, limit)
+	if errors.Is(err, types.ErrInvalidFilter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, facets)
+}
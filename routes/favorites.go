@@ -18,10 +18,12 @@ func parseID(ctx *gin.Context, param string) (int64, bool) {
 }
 
 func GetUserProfile(ctx *gin.Context, database *sql.DB) {
+	userID := currentUserID(ctx)
+
 	// Helper to get all names from a favorites table
 	getNames := func(table, col, entityTable string) []string {
 		rows, err := database.Query(
-			`SELECT e.name FROM ` + table + ` f JOIN ` + entityTable + ` e ON f.` + col + ` = e.id`)
+			`SELECT e.name FROM `+table+` f JOIN `+entityTable+` e ON f.`+col+` = e.id WHERE f.user_id = ?`, userID)
 		if err != nil {
 			return nil
 		}
@@ -48,7 +50,8 @@ func GetUserProfile(ctx *gin.Context, database *sql.DB) {
 	SELECT d.id, d.source, d.external_id, p.rating, p.last_page
 	FROM doujinshi_progress p
 	JOIN doujinshi d ON p.doujinshi_id = d.id
-`)
+	WHERE p.user_id = ?
+`, userID)
 
 	if err != nil {
 		ctx.JSON(500, gin.H{"error": "Failed to get progress"})
@@ -86,9 +89,9 @@ func GetUserProfile(ctx *gin.Context, database *sql.DB) {
 	})
 }
 
-func getNames(database *sql.DB, table, col, entityTable string) []string {
+func getNames(database *sql.DB, userID int64, table, col, entityTable string) []string {
 	rows, err := database.Query(
-		`SELECT e.name FROM ` + table + ` f JOIN ` + entityTable + ` e ON f.` + col + ` = e.id`)
+		`SELECT e.name FROM `+table+` f JOIN `+entityTable+` e ON f.`+col+` = e.id WHERE f.user_id = ?`, userID)
 	if err != nil {
 		return nil
 	}
@@ -108,7 +111,7 @@ func AddFavoriteByName(
 	database *sql.DB,
 	entityName string,
 	tableName string,
-	addFunc func(*sql.DB, int64) error,
+	addFunc func(database *sql.DB, userID, id int64) error,
 ) {
 	var req map[string]string
 	if err := ctx.ShouldBindJSON(&req); err != nil || req[entityName] == "" {
@@ -131,8 +134,8 @@ func AddFavoriteByName(
 	}
 
 	// Add to favorites
-	if err := addFunc(database, id); err != nil {
-		ctx.JSON(500, gin.H{"error": "Failed to add favorite " + entityName})
+	if err := addFunc(database, currentUserID(ctx), id); err != nil {
+		writeFailed(ctx, err, "Failed to add favorite "+entityName)
 		return
 	}
 	ctx.JSON(200, gin.H{"success": true, entityName + "ID": id})
@@ -143,7 +146,7 @@ func RemoveFavoriteByName(
 	database *sql.DB,
 	entityName string,
 	tableName string,
-	removeFunc func(*sql.DB, int64) error,
+	removeFunc func(database *sql.DB, userID, id int64) error,
 ) {
 	var req map[string]string
 	if err := ctx.ShouldBindJSON(&req); err != nil || req[entityName] == "" {
@@ -165,7 +168,7 @@ func RemoveFavoriteByName(
 		return
 	}
 
-	if err := removeFunc(database, id); err != nil {
+	if err := removeFunc(database, currentUserID(ctx), id); err != nil {
 		ctx.JSON(500, gin.H{"error": "Failed to remove favorite " + entityName})
 		return
 	}
@@ -181,7 +184,8 @@ func GetFavoriteNames(
 	entityName string,
 ) {
 	rows, err := database.Query(
-		`SELECT e.name FROM ` + table + ` f JOIN ` + entityTable + ` e ON f.` + col + ` = e.id`)
+		`SELECT e.name FROM `+table+` f JOIN `+entityTable+` e ON f.`+col+` = e.id WHERE f.user_id = ?`,
+		currentUserID(ctx))
 	if err != nil {
 		ctx.JSON(500, gin.H{"error": "Failed to get favorite " + entityName})
 		return
@@ -200,15 +204,15 @@ func GetFavoriteNames(
 func AddFavoriteByID(
 	ctx *gin.Context,
 	database *sql.DB,
-	addFunc func(*sql.DB, int64) error,
+	addFunc func(database *sql.DB, userID, id int64) error,
 ) {
 	id, ok := parseID(ctx, "id")
 	if !ok {
 		return
 	}
 
-	if err := addFunc(database, id); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add favorite"})
+	if err := addFunc(database, currentUserID(ctx), id); err != nil {
+		writeFailed(ctx, err, "Failed to add favorite")
 		return
 	}
 	ctx.JSON(http.StatusOK, gin.H{"success": true})
@@ -217,14 +221,14 @@ func AddFavoriteByID(
 func RemoveFavoriteByID(
 	ctx *gin.Context,
 	database *sql.DB,
-	removeFunc func(*sql.DB, int64) error,
+	removeFunc func(database *sql.DB, userID, id int64) error,
 ) {
 	id, ok := parseID(ctx, "id") // Get 'id' from the URL path
 	if !ok {
 		return
 	}
 
-	if err := removeFunc(database, id); err != nil {
+	if err := removeFunc(database, currentUserID(ctx), id); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove favorite"})
 		return
 	}
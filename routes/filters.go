@@ -9,9 +9,36 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// Kind is "doujinshi" (the default) or "images". Image filters go in
+// imageFilters, doujinshi filters in filters.
 type CreateFilterRequest struct {
-	Name    string              `json:"name" binding:"required"`
-	Filters types.BrowseFilters `json:"filters"`
+	Name         string              `json:"name" binding:"required"`
+	Kind         string              `json:"kind"`
+	Filters      types.BrowseFilters `json:"filters"`
+	ImageFilters *types.ImageFilters `json:"imageFilters"`
+}
+
+// savedFilterFromRequest validates the request and returns the filter to
+// store, or writes a 400 and returns false.
+func savedFilterFromRequest(c *gin.Context, name, kind string, filters types.BrowseFilters, imageFilters *types.ImageFilters) (db.SavedFilter, bool) {
+	sf := db.SavedFilter{Name: name, Kind: kind}
+	switch kind {
+	case "", db.SavedFilterDoujinshi:
+		if err := filters.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return sf, false
+		}
+		sf.Filters = filters
+	case db.SavedFilterImages:
+		if imageFilters == nil {
+			imageFilters = &types.ImageFilters{}
+		}
+		sf.ImageFilters = imageFilters
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be doujinshi or images"})
+		return sf, false
+	}
+	return sf, true
 }
 
 func CreateSavedFilterHandler(c *gin.Context, database *sql.DB) {
@@ -20,8 +47,12 @@ func CreateSavedFilterHandler(c *gin.Context, database *sql.DB) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
 		return
 	}
+	savedFilter, ok := savedFilterFromRequest(c, req.Name, req.Kind, req.Filters, req.ImageFilters)
+	if !ok {
+		return
+	}
 
-	id, err := db.CreateSavedFilter(database, req.Name, req.Filters)
+	id, err := db.CreateSavedFilter(database, currentUserID(c), savedFilter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save filter"})
 		return
@@ -31,7 +62,7 @@ func CreateSavedFilterHandler(c *gin.Context, database *sql.DB) {
 }
 
 func GetAllSavedFiltersHandler(c *gin.Context, database *sql.DB) {
-	filters, err := db.GetAllSavedFilters(database)
+	filters, err := db.GetAllSavedFilters(database, currentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve saved filters"})
 		return
@@ -44,9 +75,43 @@ func GetAllSavedFiltersHandler(c *gin.Context, database *sql.DB) {
 	c.JSON(http.StatusOK, gin.H{"savedFilters": filters})
 }
 
+// GetSavedFilterResultsHandler runs a saved filter and returns a page of the
+// matching doujinshi or images. Accepts the same sort and pagination params
+// as GET /api/doujinshi.
+func GetSavedFilterResultsHandler(c *gin.Context, database *sql.DB) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+
+	savedFilter, err := db.GetSavedFilter(database, currentUserID(c), id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Saved filter not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve saved filter"})
+		return
+	}
+
+	opts, err := bindListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if savedFilter.Kind == db.SavedFilterImages {
+		respondWithImagePage(c, database, *savedFilter.ImageFilters, opts)
+		return
+	}
+	respondWithDoujinshiPage(c, database, savedFilter.Filters, opts)
+}
+
 type UpdateFilterRequest struct {
-	Name    string              `json:"name" binding:"required"`
-	Filters types.BrowseFilters `json:"filters"`
+	Name         string              `json:"name" binding:"required"`
+	Kind         string              `json:"kind"`
+	Filters      types.BrowseFilters `json:"filters"`
+	ImageFilters *types.ImageFilters `json:"imageFilters"`
 }
 
 func UpdateSavedFilterHandler(c *gin.Context, database *sql.DB) {
@@ -60,8 +125,13 @@ func UpdateSavedFilterHandler(c *gin.Context, database *sql.DB) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
 		return
 	}
+	savedFilter, ok := savedFilterFromRequest(c, req.Name, req.Kind, req.Filters, req.ImageFilters)
+	if !ok {
+		return
+	}
+	savedFilter.ID = id
 
-	err := db.UpdateSavedFilter(database, id, req.Name, req.Filters)
+	err := db.UpdateSavedFilter(database, currentUserID(c), savedFilter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update saved filter"})
 		return
@@ -76,7 +146,7 @@ func DeleteSavedFilterHandler(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	err := db.DeleteSavedFilter(database, id)
+	err := db.DeleteSavedFilter(database, currentUserID(c), id)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete saved filter"})
 		return
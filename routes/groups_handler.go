@@ -18,7 +18,7 @@ type GroupPageData struct {
 }
 
 func GetAllGroupsHandler(c *gin.Context, database *sql.DB) {
-	groups, err := db.GetAllGroups(database)
+	groups, err := db.GetAllGroups(database, currentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch groups"})
 		return
@@ -58,7 +58,7 @@ func GetGroupPageDataHandler(c *gin.Context, database *sql.DB) {
 		groupID = id
 	}
 
-	groupDetails, err := db.GetGroupDetails(database, groupID)
+	groupDetails, err := db.GetGroupDetails(database, currentUserID(c), groupID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group details"})
 		return
@@ -68,7 +68,7 @@ func GetGroupPageDataHandler(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	doujinshi, err := db.GetDoujinshiByGroup(database, groupID)
+	doujinshi, err := db.GetDoujinshiByGroup(database, currentUserID(c), groupID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch doujinshi for group"})
 		return
@@ -86,7 +86,7 @@ func GetGroupPageDataHandler(c *gin.Context, database *sql.DB) {
 	}
 
 	// Fetch images associated with this group
-	images, err := db.GetImagesByGroup(database, groupID)
+	images, err := db.GetImagesByGroup(database, currentUserID(c), groupID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images for group"})
 		return
@@ -104,7 +104,7 @@ func GetGroupPageDataHandler(c *gin.Context, database *sql.DB) {
 	}
 
 	// Fetch bookmarks for doujinshi associated with this group
-	bookmarks, err := db.GetBookmarksByEntity(database, "group", groupID)
+	bookmarks, err := db.GetBookmarksByEntity(database, currentUserID(c), "group", groupID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookmarks for group"})
 		return
@@ -2,12 +2,15 @@ package routes
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"path/filepath"
 	"strconv"
 
 	"github.com/brayanMuniz/h_save/db"
+	"github.com/brayanMuniz/h_save/library"
+	"github.com/brayanMuniz/h_save/types"
 	"github.com/gin-gonic/gin"
 )
 
@@ -16,27 +19,83 @@ type ImageWithThumb struct {
 	ThumbnailURL string `json:"thumbnail_url"`
 }
 
+// GetAllImages lists images, see bindImageFilters and bindListOptions for the
+// accepted params. Without a limit every matching image is returned.
 func GetAllImages(c *gin.Context, database *sql.DB) {
-	images, err := db.GetAllImages(database)
+	filters, err := bindImageFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	opts, err := bindListOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondWithImagePage(c, database, filters, opts)
+}
+
+type SearchImagesRequest struct {
+	types.ImageFilters
+	db.ListOptions
+}
+
+// SearchImagesHandler is the POST variant of GetAllImages.
+func SearchImagesHandler(c *gin.Context, database *sql.DB) {
+	var req SearchImagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body: " + err.Error()})
+		return
+	}
+
+	respondWithImagePage(c, database, req.ImageFilters, req.ListOptions)
+}
+
+func respondWithImagePage(c *gin.Context, database *sql.DB, filters types.ImageFilters, opts db.ListOptions) {
+	page, err := db.ListImages(database, currentUserID(c), filters, opts)
+	if errors.Is(err, db.ErrInvalidListOptions) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var result []ImageWithThumb
+	response := gin.H{
+		"images": withImageThumbnails(page.Images),
+		"total":  page.Total,
+	}
+	if page.NextCursor != "" {
+		response["nextCursor"] = page.NextCursor
+	}
+	if page.Seed != 0 {
+		response["seed"] = page.Seed
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func withImageThumbnails(images []db.Image) []ImageWithThumb {
+	result := []ImageWithThumb{}
 	for _, img := range images {
 		result = append(result, ImageWithThumb{
 			Image:        img,
 			ThumbnailURL: "/api/images/" + strconv.FormatInt(img.ID, 10) + "/thumbnail",
 		})
 	}
-
-	c.JSON(http.StatusOK, gin.H{"images": result})
+	return result
 }
 
 func GetImage(c *gin.Context, database *sql.DB) {
 	id := c.Param("id")
-	imageData, err := db.GetImage(database, id)
+	imageData, err := db.GetImage(database, currentUserID(c), id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -50,71 +109,91 @@ func GetImage(c *gin.Context, database *sql.DB) {
 	c.JSON(http.StatusOK, gin.H{"imageData": result})
 }
 
-func GetImageFile(c *gin.Context, database *sql.DB) {
+func GetImageFile(c *gin.Context, database *sql.DB, root *library.Root) {
 	id := c.Param("id")
-	imageData, err := db.GetImage(database, id)
+	imageData, err := db.GetImage(database, currentUserID(c), id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	path, err := root.Confine(imageData.FilePath)
+	if err != nil {
+		libraryPathError(c, err, "Image file not found")
+		return
+	}
+
 	c.Header("Cache-Control", "public, max-age=86400") // Cache for 1 day
-	c.File(imageData.FilePath)
+	c.File(path)
+}
+
+func GetImageThumbnail(c *gin.Context, database *sql.DB, root *library.Root) {
+	GetImageFile(c, database, root)
 }
 
-func GetImageThumbnail(c *gin.Context, database *sql.DB) {
-	GetImageFile(c, database)
+type SimilarImageWithThumb struct {
+	ImageWithThumb
+	Score  float64             `json:"score"`
+	Shared db.SharedAttributes `json:"shared"`
 }
 
+// GetSimilarImagesByMetadata ranks images by shared metadata, rare attributes
+// weigh more. limit defaults to 20.
 func GetSimilarImagesByMetadata(c *gin.Context, database *sql.DB) {
-	id := c.Param("id")
-	imageData, err := db.GetImage(database, id)
-	if err != nil {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	limit, ok := parseSimilarLimit(c)
+	if !ok {
+		return
+	}
+
+	if _, err := db.GetImage(database, currentUserID(c), c.Param("id")); err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		return
+	} else if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get image data"})
 		return
 	}
 
-	similarList, err := db.GetSimilarImagesByMetaData(
-		database,
-		id,
-		imageData.Characters,
-		imageData.Tags,
-		imageData.Parodies,
-	)
+	similarList, err := db.GetSimilarImages(database, currentUserID(c), id, limit)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	var result []ImageWithThumb
+	result := []SimilarImageWithThumb{}
 	for _, img := range similarList {
-		result = append(result, ImageWithThumb{
-			Image:        img,
-			ThumbnailURL: "/api/images/" + strconv.FormatInt(img.ID, 10) + "/thumbnail",
+		result = append(result, SimilarImageWithThumb{
+			ImageWithThumb: ImageWithThumb{
+				Image:        img.Image,
+				ThumbnailURL: "/api/images/" + strconv.FormatInt(img.ID, 10) + "/thumbnail",
+			},
+			Score:  img.Score,
+			Shared: img.Shared,
 		})
 	}
 	c.JSON(http.StatusOK, gin.H{"similarImages": result})
 }
 
-func ScanImagesFolder(c *gin.Context, database *sql.DB) {
-	// Default folder path
-	folderPath := "images"
-
-	// Allow override via query parameter
-	if customPath := c.Query("path"); customPath != "" {
-		folderPath = customPath
-	}
+// ScanImagesFolder scans the images root, or the folder inside it given by
+// ?path=.
+func ScanImagesFolder(c *gin.Context, database *sql.DB, root *library.Root) {
+	folderPath := c.Query("path")
 
-	// Check if folder exists
-	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": fmt.Sprintf("Images folder '%s' does not exist", folderPath),
-		})
+	dir, err := root.Resolve(folderPath)
+	if err != nil {
+		libraryPathError(c, err, fmt.Sprintf("Images folder '%s' does not exist", filepath.Join(root.Dir, folderPath)))
 		return
 	}
 
 	// Perform the scan
-	result, err := db.ScanImagesFolder(database, folderPath)
+	result, err := db.ScanImagesFolder(database, root, dir)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -148,15 +227,15 @@ func UpdateImageProgress(c *gin.Context, database *sql.DB) {
 	}
 
 	if req.Rating != nil {
-		if err := db.UpdateImageRating(database, imageID, *req.Rating); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rating"})
+		if err := db.UpdateImageRating(database, currentUserID(c), imageID, *req.Rating); err != nil {
+			writeFailed(c, err, "Failed to update rating")
 			return
 		}
 	}
 
 	if req.OCount != nil {
-		if err := db.UpdateImageOCount(database, imageID, *req.OCount); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update O count"})
+		if err := db.UpdateImageOCount(database, currentUserID(c), imageID, *req.OCount); err != nil {
+			writeFailed(c, err, "Failed to update O count")
 			return
 		}
 	}
@@ -172,7 +251,7 @@ func GetImageProgress(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	progress, err := db.GetImageProgress(database, imageID)
+	progress, err := db.GetImageProgress(database, currentUserID(c), imageID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get progress"})
 		return
@@ -190,20 +269,20 @@ func ToggleImageFavorite(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	isFavorited, err := db.IsImageFavorited(database, imageID)
+	isFavorited, err := db.IsImageFavorited(database, currentUserID(c), imageID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check favorite status"})
 		return
 	}
 
 	if isFavorited {
-		err = db.RemoveFavoriteImage(database, imageID)
+		err = db.RemoveFavoriteImage(database, currentUserID(c), imageID)
 	} else {
-		err = db.AddFavoriteImage(database, imageID)
+		err = db.AddFavoriteImage(database, currentUserID(c), imageID)
 	}
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to toggle favorite"})
+		writeFailed(c, err, "Failed to toggle favorite")
 		return
 	}
 
@@ -221,7 +300,7 @@ func GetImageFavoriteStatus(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	isFavorited, err := db.IsImageFavorited(database, imageID)
+	isFavorited, err := db.IsImageFavorited(database, currentUserID(c), imageID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check favorite status"})
 		return
@@ -231,7 +310,7 @@ func GetImageFavoriteStatus(c *gin.Context, database *sql.DB) {
 }
 
 func GetFavoriteImages(c *gin.Context, database *sql.DB) {
-	images, err := db.GetFavoriteImages(database)
+	images, err := db.GetFavoriteImages(database, currentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -250,7 +329,7 @@ func GetFavoriteImages(c *gin.Context, database *sql.DB) {
 
 // Image artist handlers
 func GetAllImageArtists(c *gin.Context, database *sql.DB) {
-	artists, err := db.GetAllImageArtists(database)
+	artists, err := db.GetAllImageArtists(database, currentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -269,7 +348,7 @@ func GetImageArtistPageData(c *gin.Context, database *sql.DB) {
 
 	// Get artist details (you'll need to implement this in your db package)
 	// For now, let's just get the images
-	images, err := db.GetImagesByArtist(database, artistID)
+	images, err := db.GetImagesByArtist(database, currentUserID(c), artistID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
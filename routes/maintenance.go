@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/brayanMuniz/h_save/db"
+	"github.com/gin-gonic/gin"
+)
+
+// IntegrityCheckHandler reports damage to the database file and orphaned
+// rows. It changes nothing, see RepairIntegrityHandler.
+func IntegrityCheckHandler(c *gin.Context, database *sql.DB) {
+	report, err := db.CheckIntegrity(database, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check the database"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// RepairIntegrityHandler deletes the orphaned rows. A damaged file isn't
+// touched.
+func RepairIntegrityHandler(c *gin.Context, database *sql.DB) {
+	report, err := db.CheckIntegrity(database, true)
+	if errors.Is(err, db.ErrCorrupt) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "report": report})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to repair the database"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func ListBackupsHandler(c *gin.Context, backupDir string) {
+	backups, err := db.ListBackups(backupDir)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list backups"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"backups": backups})
+}
+
+// CreateBackupHandler backs up the database now. The oldest backups are
+// deleted so that keep are left, like after a scheduled one.
+func CreateBackupHandler(c *gin.Context, database *sql.DB, backupDir string, keep int) {
+	backup, err := db.CreateBackup(database, backupDir)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to back up the database"})
+		return
+	}
+	deleted, err := db.PruneBackups(backupDir, keep)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Backed up, but failed to delete the old backups"})
+		return
+	}
+	if deleted == nil {
+		deleted = []string{}
+	}
+	c.JSON(http.StatusCreated, gin.H{"backup": backup, "deleted": deleted})
+}
+
+func DownloadBackupHandler(c *gin.Context, backupDir string) {
+	name := c.Param("name")
+	path, err := db.BackupPath(backupDir, name)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+		return
+	}
+	c.FileAttachment(path, name)
+}
+
+// RestoreBackupHandler replaces the database with a backup, see
+// db.RestoreBackup. The current content is backed up first, and the
+// sessions become those of the backup, which can log everyone out.
+func RestoreBackupHandler(c *gin.Context, database *sql.DB, dbPath, backupDir string) {
+	path, err := db.BackupPath(backupDir, c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Backup not found"})
+		return
+	}
+
+	previous, err := db.RestoreBackup(database, dbPath, path, backupDir)
+	if errors.Is(err, db.ErrInvalidBackup) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "previous": previous})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Database restored", "previous": previous})
+}
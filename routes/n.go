@@ -23,12 +23,57 @@ type DownloadResult struct {
 	Skipped        []string `json:"skipped"`
 	Failed         []string `json:"failed"`
 	PagesProcessed int      `json:"pagesProcessed"`
+	// SessionExpired is set when the cookies stopped working during the
+	// download, the favorites after that weren't downloaded.
+	SessionExpired bool `json:"sessionExpired"`
 }
 
+// DownloadFavoritesHandler downloads the favorites' torrents into torrentDir,
+// with the cookies in the request or else the saved ones.
+func DownloadFavoritesHandler(c *gin.Context, database *sql.DB, key []byte, torrentDir string) {
+	var req struct {
+		credentialsRequest
+		SaveMetadata  bool `json:"saveMetadata"`
+		SkipOrganized bool `json:"skipOrganized"`
+		StartPage     int  `json:"startPage"`
+		MaxPages      int  `json:"maxPages"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	// defaults
+	if req.StartPage <= 0 {
+		req.StartPage = 1
+	}
+	if req.MaxPages <= 0 {
+		req.MaxPages = 20
+	}
+
+	httpConfig, stored, ok := req.httpConfig(c, database, key)
+	if !ok {
+		return
+	}
+	if _, ok := checkSession(c, database, httpConfig, stored); !ok {
+		return
+	}
+
+	result := DownloadAllFavorites(httpConfig, database, torrentDir,
+		req.SaveMetadata, req.SkipOrganized, req.StartPage, req.MaxPages)
+	if result.SessionExpired && stored {
+		db.SetCredentialsStatus(database, currentUserID(c), NhentaiProvider, db.CredentialsExpired, "")
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// DownloadAllFavorites downloads the torrents of maxPages pages of favorites
+// from startPage on, printing its progress.
 func DownloadAllFavorites(
-	c *gin.Context,
 	httpConfig n.HTTPConfig,
 	database *sql.DB,
+	torrentDir string,
 	saveMetadata bool,
 	skipOrganized bool,
 	startPage int,
@@ -60,12 +105,15 @@ func DownloadAllFavorites(
 			break
 		}
 
-		// reached the end
+		// reached the end, or got the login page
 		if len(listOfFavorites) == 0 {
+			if userName, _ := n.ReturnUserNameFromHTML(htmlPage); strings.TrimSpace(userName) == "" {
+				result.SessionExpired = true
+			}
 			break
 		}
 
-		processFavoritesPage(listOfFavorites, rootURL, httpConfig, database,
+		processFavoritesPage(listOfFavorites, rootURL, httpConfig, database, torrentDir,
 			saveMetadata, skipOrganized, &result)
 
 		result.PagesProcessed++
@@ -84,6 +132,7 @@ func processFavoritesPage(
 	rootURL string,
 	httpConfig n.HTTPConfig,
 	database *sql.DB,
+	torrentDir string,
 	saveMetadata bool,
 	skipOrganized bool,
 	result *DownloadResult) {
@@ -104,7 +153,7 @@ func processFavoritesPage(
 
 		// Download torrent
 		downloadRoute := fmt.Sprintf("%s/g/%s/download", rootURL, v.HolyNumbers)
-		err := n.DownloadTorrentFile(downloadRoute, v.Title, httpConfig)
+		err := n.DownloadTorrentFile(downloadRoute, v.Title, torrentDir, httpConfig)
 		if err != nil {
 			fmt.Printf("Failed to download %s: %v\n", v.Title, err)
 			result.Failed = append(result.Failed, v.Title)
@@ -179,6 +228,7 @@ func DownloadFavorites(
 	pageStart string,
 	http_config n.HTTPConfig,
 	database *sql.DB,
+	torrentDir string,
 	saveMetadata bool,
 	skipOrganized bool) {
 
@@ -227,7 +277,7 @@ func DownloadFavorites(
 		downloadRoute := strings.TrimSpace(rootURL + "/g/" + v.HolyNumbers + "/download")
 		fmt.Println("Going to download: ", downloadRoute)
 		titleName := v.Title
-		err := n.DownloadTorrentFile(downloadRoute, titleName, http_config)
+		err := n.DownloadTorrentFile(downloadRoute, titleName, torrentDir, http_config)
 		if err != nil {
 			fmt.Println("FAILED TO DOWNLOAD:", downloadRoute)
 			failed = append(failed, v.Title)
@@ -19,8 +19,8 @@ func SetOCountHandler(ctx *gin.Context, database *sql.DB) {
 		ctx.JSON(400, gin.H{"error": "Invalid request"})
 		return
 	}
-	if err := db.SetOCount(database, id, req.Filename, req.OCount); err != nil {
-		ctx.JSON(500, gin.H{"error": "Failed to set o count"})
+	if err := db.SetOCount(database, currentUserID(ctx), id, req.Filename, req.OCount); err != nil {
+		writeFailed(ctx, err, "Failed to set o count")
 		return
 	}
 	ctx.JSON(200, gin.H{"success": true})
@@ -36,7 +36,7 @@ func GetOCountHandler(ctx *gin.Context, database *sql.DB) {
 		ctx.JSON(400, gin.H{"error": "Missing filename"})
 		return
 	}
-	oCount, err := db.GetOCount(database, id, filename)
+	oCount, err := db.GetOCount(database, currentUserID(ctx), id, filename)
 	if err != nil {
 		ctx.JSON(500, gin.H{"error": "Failed to get o count"})
 		return
@@ -49,7 +49,7 @@ func GetTotalOCountHandler(ctx *gin.Context, database *sql.DB) {
 	if !ok {
 		return
 	}
-	total, err := db.GetTotalOCount(database, id)
+	total, err := db.GetTotalOCount(database, currentUserID(ctx), id)
 	if err != nil {
 		ctx.JSON(500, gin.H{"error": "Failed to get total o count"})
 		return
@@ -19,7 +19,7 @@ type ParodyPageData struct {
 
 // GetAllParodiesHandler handles the request to get all parodies.
 func GetAllParodiesHandler(c *gin.Context, database *sql.DB) {
-	parodies, err := db.GetAllParodies(database)
+	parodies, err := db.GetAllParodies(database, currentUserID(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch parodies"})
 		return
@@ -57,7 +57,7 @@ func GetParodyPageDataHandler(c *gin.Context, database *sql.DB) {
 		parodyID = id
 	}
 
-	parodyDetails, err := db.GetParodyDetails(database, parodyID)
+	parodyDetails, err := db.GetParodyDetails(database, currentUserID(c), parodyID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch parody details"})
 		return
@@ -67,7 +67,7 @@ func GetParodyPageDataHandler(c *gin.Context, database *sql.DB) {
 		return
 	}
 
-	doujinshi, err := db.GetDoujinshiByParody(database, parodyID)
+	doujinshi, err := db.GetDoujinshiByParody(database, currentUserID(c), parodyID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch doujinshi for parody"})
 		return
@@ -85,7 +85,7 @@ func GetParodyPageDataHandler(c *gin.Context, database *sql.DB) {
 	}
 
 	// Fetch images associated with this parody
-	images, err := db.GetImagesByParody(database, parodyID)
+	images, err := db.GetImagesByParody(database, currentUserID(c), parodyID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images for parody"})
 		return
@@ -103,7 +103,7 @@ func GetParodyPageDataHandler(c *gin.Context, database *sql.DB) {
 	}
 
 	// Fetch bookmarks for doujinshi associated with this parody
-	bookmarks, err := db.GetBookmarksByEntity(database, "parody", parodyID)
+	bookmarks, err := db.GetBookmarksByEntity(database, currentUserID(c), "parody", parodyID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookmarks for parody"})
 		return
@@ -2,49 +2,182 @@ package routes
 
 import (
 	"database/sql"
-	"net/http"
+	"log"
 
+	"github.com/brayanMuniz/h_save/config"
 	"github.com/brayanMuniz/h_save/db"
-	"github.com/brayanMuniz/h_save/n"
 	"github.com/gin-gonic/gin"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func SetupRouter(database *sql.DB) *gin.Engine {
+// SetupRouter registers every route. credentialsKey encrypts the provider
+// credentials, see db.DeriveCredentialsKey.
+func SetupRouter(database *sql.DB, cfg *config.Config, credentialsKey []byte) *gin.Engine {
 	r := gin.Default()
+	// X-Forwarded-For is only believed from the configured proxies, the
+	// login lockout and the audit trail rely on the client IP
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Printf("Ignoring the trusted proxies: %v", err)
+		r.SetTrustedProxies(nil)
+	}
+	roots := cfg.Roots()
+
+	// AUTHENTICATION ROUTES
+	public := r.Group("/api")
+	{
+		public.POST("/user/login", func(ctx *gin.Context) {
+			LoginHandler(ctx, database, cfg.LoginLimits)
+		})
+	}
+
+	// ACCOUNT ROUTES, usable before the default password is changed
+	account := r.Group("/api/user", RequireAuth(database))
+	{
+		account.POST("/logout", func(ctx *gin.Context) {
+			LogoutHandler(ctx, database)
+		})
+
+		account.POST("/password", RequireBrowserSession(), RejectGuests(database), func(ctx *gin.Context) {
+			ChangePasswordHandler(ctx, database)
+		})
+
+		account.GET("/sessions", RequireBrowserSession(), func(ctx *gin.Context) {
+			ListSessionsHandler(ctx, database)
+		})
+
+		account.DELETE("/sessions/:id", RequireBrowserSession(), func(ctx *gin.Context) {
+			RevokeSessionHandler(ctx, database)
+		})
+
+		account.GET("/me", func(ctx *gin.Context) {
+			GetCurrentUserHandler(ctx, database)
+		})
+
+		// API tokens for scripts, sent as "Authorization: Bearer <token>"
+		tokens := account.Group("/tokens", RequireBrowserSession(), RejectGuests(database))
+		{
+			tokens.GET("", func(ctx *gin.Context) {
+				ListAPITokensHandler(ctx, database)
+			})
+
+			tokens.POST("", func(ctx *gin.Context) {
+				CreateAPITokenHandler(ctx, database)
+			})
 
-	api := r.Group("/api")
+			tokens.DELETE("/:id", func(ctx *gin.Context) {
+				RevokeAPITokenHandler(ctx, database)
+			})
+		}
+	}
+
+	// USER MANAGEMENT ROUTES
+	admin := r.Group("/api/users", RequireAuth(database), RequireBrowserSession(), RequirePasswordChanged(database), RequireAdmin(database))
 	{
-		// AUTHENTICATION ROUTES
-		api.POST("/user/login", func(ctx *gin.Context) {
-			LoginHandler(ctx, database)
+		admin.GET("", func(ctx *gin.Context) {
+			ListUsersHandler(ctx, database)
+		})
+
+		admin.POST("", func(ctx *gin.Context) {
+			CreateUserHandler(ctx, database)
 		})
 
+		admin.DELETE("/:id", func(ctx *gin.Context) {
+			DeleteUserHandler(ctx, database)
+		})
+
+		admin.GET("/logins", func(ctx *gin.Context) {
+			ListLoginAttemptsHandler(ctx, database)
+		})
+
+		// content guests don't see
+		admin.GET("/hidden-content", func(ctx *gin.Context) {
+			GetHiddenContentHandler(ctx, database)
+		})
+
+		admin.PUT("/hidden-content", func(ctx *gin.Context) {
+			SetHiddenContentHandler(ctx, database)
+		})
+	}
+
+	// DATABASE MAINTENANCE ROUTES
+	maintenance := r.Group("/api/admin", RequireAuth(database), RequireBrowserSession(), RequirePasswordChanged(database), RequireAdmin(database))
+	{
+		maintenance.GET("/integrity", func(ctx *gin.Context) {
+			IntegrityCheckHandler(ctx, database)
+		})
+
+		maintenance.POST("/integrity/repair", func(ctx *gin.Context) {
+			RepairIntegrityHandler(ctx, database)
+		})
+
+		maintenance.GET("/backups", func(ctx *gin.Context) {
+			ListBackupsHandler(ctx, cfg.BackupDir)
+		})
+
+		maintenance.POST("/backups", func(ctx *gin.Context) {
+			CreateBackupHandler(ctx, database, cfg.BackupDir, cfg.BackupKeep)
+		})
+
+		maintenance.GET("/backups/:name", func(ctx *gin.Context) {
+			DownloadBackupHandler(ctx, cfg.BackupDir)
+		})
+
+		maintenance.POST("/backups/:name/restore", func(ctx *gin.Context) {
+			RestoreBackupHandler(ctx, database, cfg.DBPath, cfg.BackupDir)
+		})
+	}
+
+	// everything else needs a session and a changed password, guests can
+	// only read
+	api := r.Group("/api", RequireAuth(database), RequirePasswordChanged(database), RejectGuestWrites(database))
+	{
+
 		// DOUJINSHI CORE ROUTES
 		api.GET("/doujinshi", func(ctx *gin.Context) {
 			GetAllDoujinshi(ctx, database)
 		})
 
+		api.POST("/doujinshi/search", func(ctx *gin.Context) {
+			SearchDoujinshiHandler(ctx, database)
+		})
+
+		api.GET("/doujinshi/facets", func(ctx *gin.Context) {
+			GetDoujinshiFacetsHandler(ctx, database)
+		})
+
+		api.POST("/doujinshi/facets", func(ctx *gin.Context) {
+			PostDoujinshiFacetsHandler(ctx, database)
+		})
+
 		api.GET("/doujinshi/:id", func(ctx *gin.Context) {
 			GetDoujinshi(ctx, database)
 		})
 
 		api.GET("/doujinshi/:id/pages", func(ctx *gin.Context) {
-			GetDoujinshiPages(ctx, database)
+			GetDoujinshiPages(ctx, database, roots)
 		})
 
 		api.GET("/doujinshi/:id/page/:pageNumber", func(ctx *gin.Context) {
-			GetDoujinshiPage(ctx, database)
+			GetDoujinshiPage(ctx, database, roots)
 		})
 
 		api.GET("/doujinshi/:id/thumbnail", func(ctx *gin.Context) {
-			GetDoujinshiThumbnail(ctx, database)
+			GetDoujinshiThumbnail(ctx, database, roots)
 		})
 
 		api.GET("/doujinshi/:id/similar/metadata", func(ctx *gin.Context) {
 			GetSimilarDoujinshiByMetadata(ctx, database)
 		})
 
+		// SEARCH
+		api.GET("/search", func(ctx *gin.Context) {
+			GlobalSearchHandler(ctx, database)
+		})
+
+		api.GET("/search/doujinshi", func(ctx *gin.Context) {
+			SearchDoujinshiTextHandler(ctx, database)
+		})
+
 		// ARTIST
 		api.GET("/artists", func(ctx *gin.Context) {
 			GetAllArtist(ctx, database)
@@ -112,16 +245,20 @@ func SetupRouter(database *sql.DB) *gin.Engine {
 
 		// SYNC
 		api.POST("/sync", func(ctx *gin.Context) {
-			SyncDoujinshiHandler(ctx, database)
+			SyncDoujinshiHandler(ctx, database, roots)
 		})
 
 		// UTILITY
-		api.GET("/thumbnail", func(ctx *gin.Context) {
-			GetThumbnailByFolderHandler(ctx, database)
+		api.GET("/thumbnail", RejectGuests(database), func(ctx *gin.Context) {
+			GetThumbnailByFolderHandler(ctx, database, roots)
 		})
 
 		api.POST("/doujinshi/:id/manual-sync", func(ctx *gin.Context) {
-			ManualSyncHandler(ctx, database)
+			ManualSyncHandler(ctx, database, roots)
+		})
+
+		api.POST("/doujinshi/:id/move", func(ctx *gin.Context) {
+			MoveDoujinshiHandler(ctx, database, roots)
 		})
 
 		images := api.Group("/images")
@@ -130,16 +267,20 @@ func SetupRouter(database *sql.DB) *gin.Engine {
 				GetAllImages(ctx, database)
 			})
 
+			images.POST("/search", func(ctx *gin.Context) {
+				SearchImagesHandler(ctx, database)
+			})
+
 			images.GET("/:id", func(ctx *gin.Context) {
 				GetImage(ctx, database)
 			})
 
 			images.GET("/:id/file", func(ctx *gin.Context) {
-				GetImageFile(ctx, database)
+				GetImageFile(ctx, database, roots.Images)
 			})
 
 			images.GET("/:id/thumbnail", func(ctx *gin.Context) {
-				GetImageThumbnail(ctx, database)
+				GetImageThumbnail(ctx, database, roots.Images)
 			})
 
 			images.GET("/:id/similar/metadata", func(ctx *gin.Context) {
@@ -147,7 +288,7 @@ func SetupRouter(database *sql.DB) *gin.Engine {
 			})
 
 			images.POST("/scan", func(ctx *gin.Context) {
-				ScanImagesFolder(ctx, database)
+				ScanImagesFolder(ctx, database, roots.Images)
 			})
 
 			images.GET("/artists", func(ctx *gin.Context) {
@@ -407,6 +548,15 @@ func SetupRouter(database *sql.DB) *gin.Engine {
 				GetFavoriteImages(ctx, database)
 			})
 
+			// PORTABLE USER DATA ROUTES, to move it to another library
+			user.GET("/data/export", func(ctx *gin.Context) {
+				ExportUserDataHandler(ctx, database)
+			})
+
+			user.POST("/data/import", func(ctx *gin.Context) {
+				ImportUserDataHandler(ctx, database)
+			})
+
 			savedFilters := user.Group("/saved-filters")
 			{
 				savedFilters.POST("", func(ctx *gin.Context) {
@@ -417,6 +567,10 @@ func SetupRouter(database *sql.DB) *gin.Engine {
 					GetAllSavedFiltersHandler(ctx, database)
 				})
 
+				savedFilters.GET("/:id/results", func(ctx *gin.Context) {
+					GetSavedFilterResultsHandler(ctx, database)
+				})
+
 				savedFilters.PUT("/:id", func(ctx *gin.Context) {
 					UpdateSavedFilterHandler(ctx, database)
 				})
@@ -429,43 +583,30 @@ func SetupRouter(database *sql.DB) *gin.Engine {
 	}
 
 	// EXTERNAL SOURCE ROUTES
-	nhentai := r.Group("/nhentai")
+	nhentai := r.Group("/nhentai", RequireAuth(database), RequirePasswordChanged(database), RejectGuests(database))
 	{
 		nhentai.POST("/authCheck", func(ctx *gin.Context) {
-			AuthCheck(ctx, rootURL)
+			AuthCheck(ctx, database, credentialsKey)
 		})
 
-		nhentai.POST("/favorites/download", func(ctx *gin.Context) {
-			var req struct {
-				SessionId     string `json:"sessionId"`
-				CsrfToken     string `json:"csrfToken"`
-				SaveMetadata  bool   `json:"saveMetadata"`
-				SkipOrganized bool   `json:"skipOrganized"`
-				StartPage     int    `json:"startPage"`
-				MaxPages      int    `json:"maxPages"`
-			}
+		nhentai.GET("/credentials", func(ctx *gin.Context) {
+			GetCredentialsHandler(ctx, database)
+		})
 
-			if err := ctx.ShouldBindJSON(&req); err != nil {
-				ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-				return
-			}
+		nhentai.PUT("/credentials", func(ctx *gin.Context) {
+			SaveCredentialsHandler(ctx, database, credentialsKey)
+		})
 
-			// defaults
-			if req.StartPage <= 0 {
-				req.StartPage = 1
-			}
-			if req.MaxPages <= 0 {
-				req.MaxPages = 20
-			}
+		nhentai.POST("/credentials/validate", func(ctx *gin.Context) {
+			ValidateCredentialsHandler(ctx, database, credentialsKey)
+		})
 
-			httpConfig := n.HTTPConfig{
-				SessionId: req.SessionId,
-				CsrfToken: req.CsrfToken,
-			}
+		nhentai.DELETE("/credentials", func(ctx *gin.Context) {
+			DeleteCredentialsHandler(ctx, database)
+		})
 
-			result := DownloadAllFavorites(ctx, httpConfig, database,
-				req.SaveMetadata, req.SkipOrganized, req.StartPage, req.MaxPages)
-			ctx.JSON(http.StatusOK, result)
+		nhentai.POST("/favorites/download", func(ctx *gin.Context) {
+			DownloadFavoritesHandler(ctx, database, credentialsKey, cfg.TorrentDir)
 		})
 
 		nhentai.GET("/doujinshi/:id/cover", func(ctx *gin.Context) {
@@ -0,0 +1,129 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/brayanMuniz/h_save/db"
+	"github.com/gin-gonic/gin"
+)
+
+type DoujinshiSearchResultWithThumb struct {
+	Doujinshi  DoujinshiWithThumb  `json:"doujinshi"`
+	Rank       float64             `json:"rank"`
+	Highlights db.SearchHighlights `json:"highlights"`
+}
+
+func SearchDoujinshiTextHandler(c *gin.Context, database *sql.DB) {
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q query parameter is required"})
+		return
+	}
+
+	limit := 50
+	if raw := c.Query("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = v
+	}
+
+	results, err := db.SearchDoujinshiText(database, currentUserID(c), query, limit)
+	if errors.Is(err, db.ErrSearchUnavailable) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := []DoujinshiSearchResultWithThumb{}
+	for _, r := range results {
+		response = append(response, DoujinshiSearchResultWithThumb{
+			Doujinshi: DoujinshiWithThumb{
+				Doujinshi:    r.Doujinshi,
+				ThumbnailURL: "/api/doujinshi/" + strconv.FormatInt(r.Doujinshi.ID, 10) + "/thumbnail",
+			},
+			Rank:       r.Rank,
+			Highlights: r.Highlights,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": response})
+}
+
+type ScoredDoujinshiWithThumb struct {
+	DoujinshiWithThumb
+	Score float64 `json:"score"`
+}
+
+type ScoredImageWithThumb struct {
+	ImageWithThumb
+	Score float64 `json:"score"`
+}
+
+// GlobalSearchHandler searches doujinshi titles, image filenames and entity
+// names at once for quick-jump boxes. Matches tolerate typos and unfinished
+// words. limit applies to each group, defaults to 10 and is capped at 50.
+func GlobalSearchHandler(c *gin.Context, database *sql.DB) {
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q query parameter is required"})
+		return
+	}
+
+	limit := 10
+	if raw := c.Query("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(v, db.MaxGlobalSearchLimit)
+	}
+
+	results, err := db.GlobalSearch(database, currentUserID(c), query, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	doujinshi := []ScoredDoujinshiWithThumb{}
+	for _, d := range results.Doujinshi {
+		doujinshi = append(doujinshi, ScoredDoujinshiWithThumb{
+			DoujinshiWithThumb: DoujinshiWithThumb{
+				Doujinshi:    d.Doujinshi,
+				ThumbnailURL: "/api/doujinshi/" + strconv.FormatInt(d.ID, 10) + "/thumbnail",
+			},
+			Score: d.Score,
+		})
+	}
+
+	images := []ScoredImageWithThumb{}
+	for _, img := range results.Images {
+		images = append(images, ScoredImageWithThumb{
+			ImageWithThumb: ImageWithThumb{
+				Image:        img.Image,
+				ThumbnailURL: "/api/images/" + strconv.FormatInt(img.ID, 10) + "/thumbnail",
+			},
+			Score: img.Score,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"doujinshi":      doujinshi,
+		"images":         images,
+		"artists":        results.Artists,
+		"tags":           results.Tags,
+		"characters":     results.Characters,
+		"parodies":       results.Parodies,
+		"groups":         results.Groups,
+		"fullTextSearch": db.FullTextSearchAvailable(),
+	})
+}
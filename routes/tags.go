@@ -17,7 +17,7 @@ type TagPageData struct {
 }
 
 func GetAllTagsHandler(ctx *gin.Context, database *sql.DB) {
-	tags, err := db.GetAllTags(database)
+	tags, err := db.GetAllTags(database, currentUserID(ctx))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tags"})
 		return
@@ -56,7 +56,7 @@ func GetTagPageDataHandler(ctx *gin.Context, database *sql.DB) {
 		tagID = id
 	}
 
-	tagDetails, err := db.GetTagDetails(database, tagID)
+	tagDetails, err := db.GetTagDetails(database, currentUserID(ctx), tagID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tag details"})
 		return
@@ -66,7 +66,7 @@ func GetTagPageDataHandler(ctx *gin.Context, database *sql.DB) {
 		return
 	}
 
-	doujinshi, err := db.GetDoujinshiByTag(database, tagID)
+	doujinshi, err := db.GetDoujinshiByTag(database, currentUserID(ctx), tagID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch doujinshi for tag"})
 		return
@@ -84,7 +84,7 @@ func GetTagPageDataHandler(ctx *gin.Context, database *sql.DB) {
 	}
 
 	// Fetch images associated with this tag
-	images, err := db.GetImagesByTag(database, tagID)
+	images, err := db.GetImagesByTag(database, currentUserID(ctx), tagID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch images for tag"})
 		return
@@ -102,7 +102,7 @@ func GetTagPageDataHandler(ctx *gin.Context, database *sql.DB) {
 	}
 
 	// Fetch bookmarks for doujinshi associated with this tag
-	bookmarks, err := db.GetBookmarksByEntity(database, "tag", tagID)
+	bookmarks, err := db.GetBookmarksByEntity(database, currentUserID(ctx), "tag", tagID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch bookmarks for tag"})
 		return
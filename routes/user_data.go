@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/brayanMuniz/h_save/db"
+	"github.com/gin-gonic/gin"
+)
+
+// ExportUserDataHandler downloads the user's progress, favorites, bookmarks
+// and saved filters in the portable format of db.ExportUserData.
+func ExportUserDataHandler(c *gin.Context, database *sql.DB) {
+	data, err := db.ExportUserData(database, currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export the user data"})
+		return
+	}
+	filename := "h_save-" + data.Username + "-" + data.ExportedAt.Format("20060102") + ".json"
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.JSON(http.StatusOK, data)
+}
+
+// ImportUserDataHandler merges an export into the user's data. The policy
+// param picks the value kept when both have one, newest by default, and
+// dryRun=true only reports what would change.
+func ImportUserDataHandler(c *gin.Context, database *sql.DB) {
+	policy := c.DefaultQuery("policy", db.ConflictNewest)
+	if !db.ValidConflictPolicy(policy) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": db.ErrUnknownPolicy.Error()})
+		return
+	}
+	dryRun := false
+	if raw := c.Query("dryRun"); raw != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dryRun: " + raw})
+			return
+		}
+	}
+
+	var data db.UserData
+	if err := c.ShouldBindJSON(&data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user data: " + err.Error()})
+		return
+	}
+
+	report, err := db.ImportUserData(database, currentUserID(c), data, policy, dryRun)
+	if errors.Is(err, db.ErrInvalidUserData) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import the user data"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
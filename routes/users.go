@@ -0,0 +1,185 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/brayanMuniz/h_save/db"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin only lets admins through, it goes after RequireAuth.
+func RequireAdmin(database *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := db.GetUser(database, currentUserID(c))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+			return
+		}
+		if !user.IsAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admins only"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RejectGuests keeps guests out of the routes behind it, like syncing or
+// downloading. It goes after RequireAuth.
+func RejectGuests(database *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if rejectGuest(c, database) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// RejectGuestWrites lets guests browse but not change anything.
+func RejectGuestWrites(database *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isWriteRequest(c.Request) && rejectGuest(c, database) {
+			return
+		}
+		c.Next()
+	}
+}
+
+func rejectGuest(c *gin.Context, database *sql.DB) bool {
+	user, err := db.GetUser(database, currentUserID(c))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return true
+	}
+	if user.IsGuest {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Guests can't do this"})
+		return true
+	}
+	return false
+}
+
+func GetCurrentUserHandler(c *gin.Context, database *sql.DB) {
+	user, err := db.GetUser(database, currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+func ListUsersHandler(c *gin.Context, database *sql.DB) {
+	users, err := db.ListUsers(database)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list users"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"users": users})
+}
+
+// CreateUserHandler adds a user with a temporary password, which they have to
+// change when they first log in. Guests are read-only and keep the password.
+func CreateUserHandler(c *gin.Context, database *sql.DB) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		IsAdmin  bool   `json:"isAdmin"`
+		IsGuest  bool   `json:"isGuest"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	user, err := db.CreateUser(database, req.Username, req.Password, req.IsAdmin, req.IsGuest)
+	if errors.Is(err, db.ErrInvalidUsername) || errors.Is(err, db.ErrWeakPassword) || errors.Is(err, db.ErrGuestAdmin) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, db.ErrUserExists) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		return
+	}
+	c.JSON(http.StatusCreated, user)
+}
+
+// DeleteUserHandler removes a user and everything they saved. Admins can't
+// delete themselves, so there is always one left.
+func DeleteUserHandler(c *gin.Context, database *sql.DB) {
+	id, ok := parseID(c, "id")
+	if !ok {
+		return
+	}
+	if id == currentUserID(c) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can't delete yourself"})
+		return
+	}
+
+	err := db.DeleteUser(database, id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
+}
+
+// ListLoginAttemptsHandler shows recent logins, failed and locked out ones
+// included, to spot someone guessing passwords. ?ip= narrows it to one
+// address.
+func ListLoginAttemptsHandler(c *gin.Context, database *sql.DB) {
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		v, err := strconv.Atoi(raw)
+		if err != nil || v <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = v
+	}
+
+	attempts, err := db.ListLoginAttempts(database, c.Query("ip"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list login attempts"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"attempts": attempts})
+}
+
+func GetHiddenContentHandler(c *gin.Context, database *sql.DB) {
+	hidden, err := db.GetHiddenContent(database)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get hidden content"})
+		return
+	}
+	c.JSON(http.StatusOK, hidden)
+}
+
+// SetHiddenContentHandler replaces what guests don't see: tags, artists,
+// characters, parodies and groups by name, doujinshi and images by ID.
+func SetHiddenContentHandler(c *gin.Context, database *sql.DB) {
+	var req db.HiddenContent
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	err := db.SetHiddenContent(database, req)
+	if errors.Is(err, db.ErrUnknownHidden) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save hidden content"})
+		return
+	}
+	GetHiddenContentHandler(c, database)
+}
@@ -0,0 +1,136 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var ErrInvalidFilter = errors.New("invalid filter")
+
+const (
+	OpAnd = "and"
+	OpOr  = "or"
+	OpNot = "not"
+)
+
+// FilterExpr is a node of a boolean filter expression. And/Or nodes combine
+// their children, a Not node negates its only child and a node without an Op
+// is a condition comparing Field against Value.
+//
+//	{"op": "and", "children": [
+//	  {"op": "or", "children": [
+//	    {"field": "artist", "value": "a"},
+//	    {"field": "artist", "value": "b"}]},
+//	  {"op": "not", "children": [{"field": "tag", "value": "c"}]},
+//	  {"field": "rating", "cmp": ">=", "value": "4"}]}
+type FilterExpr struct {
+	Op       string       `json:"op,omitempty"`
+	Children []FilterExpr `json:"children,omitempty"`
+	Field    string       `json:"field,omitempty"`
+	Cmp      string       `json:"cmp,omitempty"`
+	Value    string       `json:"value,omitempty"`
+}
+
+// Fields matched by name against the related entity of the same kind.
+const (
+	FieldArtist    = "artist"
+	FieldTag       = "tag"
+	FieldCharacter = "character"
+	FieldParody    = "parody"
+	FieldGroup     = "group"
+	FieldLanguage  = "language"
+	FieldCategory  = "category"
+)
+
+// Fields compared as numbers.
+const (
+	FieldRating    = "rating"
+	FieldOCount    = "oCount"
+	FieldPages     = "pages"
+	FieldBookmarks = "bookmarks"
+)
+
+// FieldSearch matches free text against titles, FieldReading matches
+// doujinshi that are partially read and ignores its value.
+const (
+	FieldSearch  = "search"
+	FieldReading = "reading"
+)
+
+var nameFields = map[string]bool{
+	FieldArtist: true, FieldTag: true, FieldCharacter: true, FieldParody: true,
+	FieldGroup: true, FieldLanguage: true, FieldCategory: true,
+}
+
+var numericFields = map[string]bool{
+	FieldRating: true, FieldOCount: true, FieldPages: true, FieldBookmarks: true,
+}
+
+var comparators = map[string]bool{"=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+func IsNameField(field string) bool    { return nameFields[field] }
+func IsNumericField(field string) bool { return numericFields[field] }
+
+// Validate checks the whole tree, so callers can compile it without having
+// to handle malformed nodes.
+func (e FilterExpr) Validate() error {
+	switch e.Op {
+	case OpAnd, OpOr:
+		for _, child := range e.Children {
+			if err := child.Validate(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OpNot:
+		if len(e.Children) != 1 {
+			return fmt.Errorf("%w: not takes exactly one child", ErrInvalidFilter)
+		}
+		return e.Children[0].Validate()
+	case "":
+	default:
+		return fmt.Errorf("%w: unknown op %q", ErrInvalidFilter, e.Op)
+	}
+
+	if len(e.Children) > 0 {
+		return fmt.Errorf("%w: condition on %q can't have children", ErrInvalidFilter, e.Field)
+	}
+
+	switch {
+	case nameFields[e.Field], e.Field == FieldSearch:
+		if e.Cmp != "" && e.Cmp != "=" {
+			return fmt.Errorf("%w: %s can only be matched with =", ErrInvalidFilter, e.Field)
+		}
+		if e.Value == "" {
+			return fmt.Errorf("%w: %s needs a value", ErrInvalidFilter, e.Field)
+		}
+	case numericFields[e.Field]:
+		if e.Cmp != "" && !comparators[e.Cmp] {
+			return fmt.Errorf("%w: unknown comparison %q", ErrInvalidFilter, e.Cmp)
+		}
+		if _, err := strconv.Atoi(e.Value); err != nil {
+			return fmt.Errorf("%w: %s needs a whole number, got %q", ErrInvalidFilter, e.Field, e.Value)
+		}
+	case e.Field == FieldReading:
+	default:
+		return fmt.Errorf("%w: unknown field %q", ErrInvalidFilter, e.Field)
+	}
+	return nil
+}
+
+// Validate checks the expression and query parts of the filters, the flat
+// lists and ranges are always valid.
+func (f BrowseFilters) Validate() error {
+	if f.Expression != nil {
+		if err := f.Expression.Validate(); err != nil {
+			return err
+		}
+	}
+	if f.Query != "" {
+		if _, err := ParseQuery(f.Query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,18 +1,25 @@
 package types
 
 type BrowseFilters struct {
-	Artists    FilterGroup `json:"artists"`
-	Groups     FilterGroup `json:"groups"`
-	Tags       FilterGroup `json:"tags"`
-	Characters FilterGroup `json:"characters"`
-	Parodies   FilterGroup `json:"parodies"`
-	Languages  []string    `json:"languages"`
-	Rating     RangeFilter `json:"rating"`
-	OCount     RangeFilter `json:"oCount"`
-	Formats    []string    `json:"formats"`
-	Genres     []string    `json:"genres"`
-	Search     string      `json:"search"`
-	PageCount  RangeFilter `json:"pageCount"`
+	Artists          FilterGroup `json:"artists"`
+	Groups           FilterGroup `json:"groups"`
+	Tags             FilterGroup `json:"tags"`
+	Characters       FilterGroup `json:"characters"`
+	Parodies         FilterGroup `json:"parodies"`
+	Languages        []string    `json:"languages"`
+	Rating           RangeFilter `json:"rating"`
+	OCount           RangeFilter `json:"oCount"`
+	Formats          []string    `json:"formats"`
+	Genres           []string    `json:"genres"`
+	Search           string      `json:"search"`
+	PageCount        RangeFilter `json:"pageCount"`
+	BookmarkCount    RangeFilter `json:"bookmarkCount"`
+	CurrentlyReading bool        `json:"currentlyReading"`
+
+	// Expression and Query are ANDed with the fields above. Query uses the
+	// syntax accepted by ParseQuery.
+	Expression *FilterExpr `json:"expression,omitempty"`
+	Query      string      `json:"query,omitempty"`
 }
 
 type FilterGroup struct {
@@ -20,7 +27,36 @@ type FilterGroup struct {
 	Excluded []string `json:"excluded"`
 }
 
+// A zero Min or Max leaves that side of the range open.
 type RangeFilter struct {
 	Min int `json:"min"`
 	Max int `json:"max"`
 }
+
+// ImageFilters is the image gallery's counterpart of BrowseFilters.
+type ImageFilters struct {
+	Artists    FilterGroup `json:"artists"`
+	Groups     FilterGroup `json:"groups"`
+	Tags       FilterGroup `json:"tags"`
+	Characters FilterGroup `json:"characters"`
+	Parodies   FilterGroup `json:"parodies"`
+	Rating     RangeFilter `json:"rating"`
+	OCount     RangeFilter `json:"oCount"`
+	ViewCount  RangeFilter `json:"viewCount"`
+	// Favorite keeps only favorites when true and only non-favorites when
+	// false, nil doesn't filter.
+	Favorite    *bool            `json:"favorite,omitempty"`
+	Formats     []string         `json:"formats"`
+	Width       RangeFilter      `json:"width"`
+	Height      RangeFilter      `json:"height"`
+	AspectRatio FloatRangeFilter `json:"aspectRatio"`
+	// FileSize is in bytes.
+	FileSize RangeFilter `json:"fileSize"`
+}
+
+// FloatRangeFilter is a RangeFilter for fractional values such as width /
+// height.
+type FloatRangeFilter struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
@@ -0,0 +1,307 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// queryFields maps the keys accepted in a query string to filter fields.
+var queryFields = map[string]string{
+	"artist":     FieldArtist,
+	"artists":    FieldArtist,
+	"tag":        FieldTag,
+	"tags":       FieldTag,
+	"character":  FieldCharacter,
+	"characters": FieldCharacter,
+	"char":       FieldCharacter,
+	"parody":     FieldParody,
+	"parodies":   FieldParody,
+	"series":     FieldParody,
+	"group":      FieldGroup,
+	"groups":     FieldGroup,
+	"circle":     FieldGroup,
+	"language":   FieldLanguage,
+	"lang":       FieldLanguage,
+	"category":   FieldCategory,
+	"categories": FieldCategory,
+	"genre":      FieldCategory,
+	"rating":     FieldRating,
+	"o":          FieldOCount,
+	"ocount":     FieldOCount,
+	"pages":      FieldPages,
+	"page":       FieldPages,
+	"bookmarks":  FieldBookmarks,
+	"title":      FieldSearch,
+	"search":     FieldSearch,
+}
+
+type queryTokenKind int
+
+const (
+	tokenTerm queryTokenKind = iota
+	tokenLParen
+	tokenRParen
+	tokenNot
+	tokenAnd
+	tokenOr
+)
+
+type queryToken struct {
+	kind queryTokenKind
+	expr FilterExpr
+}
+
+// ParseQuery compiles a typed query string into a FilterExpr, for example
+//
+//	artist:"foo bar" -tag:netorare rating>=4 pages<30 lang:english
+//	(artist:a OR artist:b) AND NOT tag:c is:reading
+//
+// Terms next to each other are ANDed, OR (or |) binds looser than AND, and
+// - or NOT negates the term or group that follows. Words without a field
+// search the titles. An empty query returns nil.
+func ParseQuery(query string) (*FilterExpr, error) {
+	tokens, err := lexQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p := queryParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected )", ErrInvalidFilter)
+	}
+	return &expr, nil
+}
+
+func isQueryDelimiter(r rune) bool {
+	return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+}
+
+func lexQuery(query string) ([]queryToken, error) {
+	runes := []rune(query)
+	var tokens []queryToken
+
+	readQuoted := func(i int) (string, int, error) {
+		end := i + 1
+		for end < len(runes) && runes[end] != '"' {
+			end++
+		}
+		if end >= len(runes) {
+			return "", 0, fmt.Errorf("%w: missing closing quote", ErrInvalidFilter)
+		}
+		return string(runes[i+1 : end]), end + 1, nil
+	}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case r == '(':
+			tokens = append(tokens, queryToken{kind: tokenLParen})
+			i++
+			continue
+		case r == ')':
+			tokens = append(tokens, queryToken{kind: tokenRParen})
+			i++
+			continue
+		case r == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]):
+			tokens = append(tokens, queryToken{kind: tokenNot})
+			i++
+			continue
+		case r == '"':
+			phrase, next, err := readQuoted(i)
+			if err != nil {
+				return nil, err
+			}
+			i = next
+			if strings.TrimSpace(phrase) != "" {
+				tokens = append(tokens, queryToken{expr: FilterExpr{Field: FieldSearch, Value: phrase}})
+			}
+			continue
+		}
+
+		start := i
+		for i < len(runes) && !isQueryDelimiter(runes[i]) && !strings.ContainsRune(":<>=", runes[i]) {
+			i++
+		}
+		key := string(runes[start:i])
+
+		if i >= len(runes) || !strings.ContainsRune(":<>=", runes[i]) {
+			switch key {
+			case "OR", "|":
+				tokens = append(tokens, queryToken{kind: tokenOr})
+			case "AND":
+				tokens = append(tokens, queryToken{kind: tokenAnd})
+			case "NOT":
+				tokens = append(tokens, queryToken{kind: tokenNot})
+			default:
+				tokens = append(tokens, queryToken{expr: FilterExpr{Field: FieldSearch, Value: key}})
+			}
+			continue
+		}
+
+		// key followed by a comparison: ":" or "=" mean equals and ":" may
+		// be followed by another operator, as in rating:>=4
+		cmp := "="
+		if runes[i] == ':' {
+			i++
+		}
+		if i < len(runes) && (runes[i] == '<' || runes[i] == '>' || runes[i] == '=') {
+			cmp = string(runes[i])
+			i++
+			if cmp != "=" && i < len(runes) && runes[i] == '=' {
+				cmp += "="
+				i++
+			}
+		}
+
+		var value string
+		if i < len(runes) && runes[i] == '"' {
+			quoted, next, err := readQuoted(i)
+			if err != nil {
+				return nil, err
+			}
+			value, i = quoted, next
+		} else {
+			valueStart := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+				i++
+			}
+			value = string(runes[valueStart:i])
+		}
+
+		expr, err := queryCondition(key, cmp, value)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, queryToken{expr: expr})
+	}
+
+	return tokens, nil
+}
+
+func queryCondition(key, cmp, value string) (FilterExpr, error) {
+	lower := strings.ToLower(key)
+	if lower == "is" {
+		if !strings.EqualFold(value, "reading") {
+			return FilterExpr{}, fmt.Errorf("%w: unknown is:%s", ErrInvalidFilter, value)
+		}
+		return FilterExpr{Field: FieldReading}, nil
+	}
+
+	field, ok := queryFields[lower]
+	if !ok {
+		return FilterExpr{}, fmt.Errorf("%w: unknown field %q", ErrInvalidFilter, key)
+	}
+
+	expr := FilterExpr{Field: field, Cmp: cmp, Value: strings.TrimSpace(value)}
+	if !IsNumericField(field) {
+		expr.Cmp = ""
+		if cmp != "=" {
+			return FilterExpr{}, fmt.Errorf("%w: %s can only be matched with :", ErrInvalidFilter, key)
+		}
+	}
+	if err := expr.Validate(); err != nil {
+		return FilterExpr{}, err
+	}
+	return expr, nil
+}
+
+type queryParser struct {
+	tokens []queryToken
+	pos    int
+}
+
+func (p *queryParser) peek() (queryToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return queryToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *queryParser) parseOr() (FilterExpr, error) {
+	var children []FilterExpr
+	for {
+		child, err := p.parseAnd()
+		if err != nil {
+			return FilterExpr{}, err
+		}
+		children = append(children, child)
+
+		tok, ok := p.peek()
+		if !ok || tok.kind != tokenOr {
+			break
+		}
+		p.pos++
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return FilterExpr{Op: OpOr, Children: children}, nil
+}
+
+func (p *queryParser) parseAnd() (FilterExpr, error) {
+	var children []FilterExpr
+	for {
+		tok, ok := p.peek()
+		if !ok || tok.kind == tokenOr || tok.kind == tokenRParen {
+			break
+		}
+		if tok.kind == tokenAnd {
+			p.pos++
+			continue
+		}
+		child, err := p.parseUnary()
+		if err != nil {
+			return FilterExpr{}, err
+		}
+		children = append(children, child)
+	}
+
+	switch len(children) {
+	case 0:
+		return FilterExpr{}, fmt.Errorf("%w: expected a term", ErrInvalidFilter)
+	case 1:
+		return children[0], nil
+	}
+	return FilterExpr{Op: OpAnd, Children: children}, nil
+}
+
+func (p *queryParser) parseUnary() (FilterExpr, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return FilterExpr{}, fmt.Errorf("%w: expected a term", ErrInvalidFilter)
+	}
+	p.pos++
+
+	switch tok.kind {
+	case tokenNot:
+		child, err := p.parseUnary()
+		if err != nil {
+			return FilterExpr{}, err
+		}
+		return FilterExpr{Op: OpNot, Children: []FilterExpr{child}}, nil
+	case tokenLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return FilterExpr{}, err
+		}
+		if closing, ok := p.peek(); !ok || closing.kind != tokenRParen {
+			return FilterExpr{}, fmt.Errorf("%w: missing )", ErrInvalidFilter)
+		}
+		p.pos++
+		return expr, nil
+	case tokenTerm:
+		return tok.expr, nil
+	}
+	return FilterExpr{}, fmt.Errorf("%w: unexpected token", ErrInvalidFilter)
+}
@@ -0,0 +1,148 @@
+package types
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func cond(field, cmp, value string) FilterExpr {
+	return FilterExpr{Field: field, Cmp: cmp, Value: value}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  *FilterExpr
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"artist:foo", &FilterExpr{Field: FieldArtist, Value: "foo"}},
+		{`artist:"foo bar"`, &FilterExpr{Field: FieldArtist, Value: "foo bar"}},
+		{"Tags:netorare", &FilterExpr{Field: FieldTag, Value: "netorare"}},
+		{"rating>=4", &FilterExpr{Field: FieldRating, Cmp: ">=", Value: "4"}},
+		{"rating:>=4", &FilterExpr{Field: FieldRating, Cmp: ">=", Value: "4"}},
+		{"pages<30", &FilterExpr{Field: FieldPages, Cmp: "<", Value: "30"}},
+		{"o:2", &FilterExpr{Field: FieldOCount, Cmp: "=", Value: "2"}},
+		{"is:reading", &FilterExpr{Field: FieldReading}},
+		{"hello", &FilterExpr{Field: FieldSearch, Value: "hello"}},
+		{`"two words"`, &FilterExpr{Field: FieldSearch, Value: "two words"}},
+		{"-tag:netorare", &FilterExpr{Op: OpNot, Children: []FilterExpr{cond(FieldTag, "", "netorare")}}},
+		{"NOT tag:netorare", &FilterExpr{Op: OpNot, Children: []FilterExpr{cond(FieldTag, "", "netorare")}}},
+		{"tag:a tag:b", &FilterExpr{Op: OpAnd, Children: []FilterExpr{cond(FieldTag, "", "a"), cond(FieldTag, "", "b")}}},
+		{"tag:a AND tag:b", &FilterExpr{Op: OpAnd, Children: []FilterExpr{cond(FieldTag, "", "a"), cond(FieldTag, "", "b")}}},
+		{"tag:a | tag:b", &FilterExpr{Op: OpOr, Children: []FilterExpr{cond(FieldTag, "", "a"), cond(FieldTag, "", "b")}}},
+		{
+			"tag:a tag:b OR tag:c",
+			&FilterExpr{Op: OpOr, Children: []FilterExpr{
+				{Op: OpAnd, Children: []FilterExpr{cond(FieldTag, "", "a"), cond(FieldTag, "", "b")}},
+				cond(FieldTag, "", "c"),
+			}},
+		},
+		{
+			"(artist:a OR artist:b) AND NOT tag:c rating>=4",
+			&FilterExpr{Op: OpAnd, Children: []FilterExpr{
+				{Op: OpOr, Children: []FilterExpr{cond(FieldArtist, "", "a"), cond(FieldArtist, "", "b")}},
+				{Op: OpNot, Children: []FilterExpr{cond(FieldTag, "", "c")}},
+				cond(FieldRating, ">=", "4"),
+			}},
+		},
+		{
+			"-(tag:a OR tag:b)",
+			&FilterExpr{Op: OpNot, Children: []FilterExpr{
+				{Op: OpOr, Children: []FilterExpr{cond(FieldTag, "", "a"), cond(FieldTag, "", "b")}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery(%q) error = %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{"tag:a OR", "expected a term"},
+		{"OR tag:a", "expected a term"},
+		{"tag:a NOT", "expected a term"},
+		{"()", "expected a term"},
+		{"tag:a)", "unexpected )"},
+		{"(tag:a", "missing )"},
+		{`"unclosed`, "missing closing quote"},
+		{`artist:"unclosed`, "missing closing quote"},
+		{"tag:", "tag needs a value"},
+		{`tag:""`, "tag needs a value"},
+		{"tag>a", "tag can only be matched with :"},
+		{"rating:high", "rating needs a whole number"},
+		{"rating>=", "rating needs a whole number"},
+		{"colour:red", `unknown field "colour"`},
+		{"is:done", "unknown is:done"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := ParseQuery(tt.query)
+			if !errors.Is(err, ErrInvalidFilter) {
+				t.Fatalf("ParseQuery(%q) error = %v, want ErrInvalidFilter", tt.query, err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseQuery(%q) error = %q, want it to contain %q", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterExprValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    FilterExpr
+		wantErr string
+	}{
+		{"name field", cond(FieldTag, "", "a"), ""},
+		{"name field with =", cond(FieldTag, "=", "a"), ""},
+		{"numeric field", cond(FieldRating, ">=", "4"), ""},
+		{"reading", FilterExpr{Field: FieldReading}, ""},
+		{"empty and", FilterExpr{Op: OpAnd}, ""},
+		{"nested", FilterExpr{Op: OpOr, Children: []FilterExpr{cond(FieldTag, "", "a"), {Op: OpNot, Children: []FilterExpr{cond(FieldSearch, "", "b")}}}}, ""},
+		{"unknown op", FilterExpr{Op: "xor"}, `unknown op "xor"`},
+		{"not without child", FilterExpr{Op: OpNot}, "not takes exactly one child"},
+		{"not with two children", FilterExpr{Op: OpNot, Children: []FilterExpr{cond(FieldTag, "", "a"), cond(FieldTag, "", "b")}}, "not takes exactly one child"},
+		{"invalid child", FilterExpr{Op: OpAnd, Children: []FilterExpr{cond(FieldTag, "", "a"), cond(FieldTag, "", "")}}, "tag needs a value"},
+		{"condition with children", FilterExpr{Field: FieldTag, Value: "a", Children: []FilterExpr{cond(FieldTag, "", "b")}}, "can't have children"},
+		{"name field compared", cond(FieldArtist, ">", "a"), "artist can only be matched with ="},
+		{"missing value", cond(FieldSearch, "", ""), "search needs a value"},
+		{"unknown comparison", cond(FieldPages, "!=", "3"), `unknown comparison "!="`},
+		{"not a number", cond(FieldBookmarks, "=", "many"), "bookmarks needs a whole number"},
+		{"unknown field", cond("colour", "", "red"), `unknown field "colour"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.expr.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidFilter) {
+				t.Fatalf("Validate() error = %v, want ErrInvalidFilter", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %q, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -187,7 +187,7 @@ const BrowsePage = () => {
   }, [doujinshi, filters, sortBy]);
 
   useEffect(() => {
-    fetch("/api/doujinshi")
+    fetch("/api/doujinshi?all=true")
       .then((res) => res.json())
       .then((data) => {
         setDoujinshi(data.doujinshi || []);
@@ -138,7 +138,7 @@ const GalleryPage = () => {
 
   const fetchImages = async () => {
     try {
-      const response = await fetch("/api/images");
+      const response = await fetch("/api/images?all=true");
       const data = await response.json();
       setImages(data.images || []);
       setLoading(false);
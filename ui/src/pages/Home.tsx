@@ -17,7 +17,7 @@ const Home = () => {
 
   const fetchDoujinshi = async () => {
     try {
-      const response = await fetch("/api/doujinshi");
+      const response = await fetch("/api/doujinshi?all=true");
       const data = await response.json();
       setDoujinshi(data.doujinshi || []);
       setLoading(false);